@@ -0,0 +1,117 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jlewi/monogo/files"
+	"github.com/jlewi/monogo/helpers"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+// NewFilesCommands creates new commands for working with files
+func NewFilesCommands() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "files",
+		Short: "Commands for working with files in any of the supported storage systems e.g. local files and GCS",
+	}
+
+	cmd.AddCommand(NewTransformCommand())
+	return cmd
+}
+
+// NewTransformCommand creates a command to transform a batch of files
+func NewTransformCommand() *cobra.Command {
+	var input string
+	var output string
+	var workers int
+	var overwrite bool
+	var dryRun bool
+	var compression bool
+	cmd := &cobra.Command{
+		Use:   "transform --input=<regex> --output=<template> [-- command args...]",
+		Short: "Transform the files matching a regex into outputs named by a template.",
+		Long: `Transform the files matching a regex into outputs named by a template.
+
+--input is a regex; named groups can be referenced in the --output template e.g.
+
+devcli files transform --input='gs://bucket/in/(?P<name>.*)\.jsonl' --output='gs://bucket/out/{{.name}}.jsonl.gz' --compression
+
+If a command is given after -- it is run for each file; its arguments can refer to {{.Input}} and {{.Output}} e.g.
+
+devcli files transform --input='/data/(?P<name>.*)\.md' --output='/out/{{.name}}.html' -- pandoc {{.Input}} -o {{.Output}}
+
+Otherwise each input is copied to its output. Files whose output already exists are skipped unless --overwrite is set.
+`,
+		Run: func(cmd *cobra.Command, args []string) {
+			err := func() error {
+				ctx := context.Background()
+				if input == "" || output == "" {
+					return errors.New("--input and --output must be specified")
+				}
+				factory := &files.Factory{TransparentCompression: compression}
+				defer helpers.DeferIgnoreError(factory.Close)
+				mapping, err := files.BuildTransformList(ctx, factory, input, output)
+				if err != nil {
+					return err
+				}
+
+				if dryRun {
+					inputs := make([]string, 0, len(mapping))
+					for i := range mapping {
+						inputs = append(inputs, i)
+					}
+					sort.Strings(inputs)
+					for _, i := range inputs {
+						fmt.Fprintf(os.Stdout, "%v -> %v\n", i, mapping[i])
+					}
+					return nil
+				}
+
+				f := files.CopyTransform(factory)
+				if len(args) > 0 {
+					f, err = files.CommandTransform(args[0], args[1:]...)
+					if err != nil {
+						return err
+					}
+				}
+				results, tErr := files.Transform(ctx, mapping, f, &files.TransformOptions{
+					Workers:   workers,
+					Overwrite: overwrite,
+					Factory:   factory,
+				})
+
+				w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+				fmt.Fprintln(w, "STATUS\tINPUT\tOUTPUT\tDURATION\tERROR")
+				for _, r := range results {
+					errMsg := ""
+					if r.Err != nil {
+						errMsg = r.Err.Error()
+					}
+					fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\n", r.Status, r.Input, r.Output, r.Duration.Round(time.Millisecond), errMsg)
+				}
+				if err := w.Flush(); err != nil {
+					return err
+				}
+				return tErr
+			}()
+			if err != nil {
+				fmt.Printf("Error: %+v", err)
+				os.Exit(1)
+			}
+		},
+	}
+
+	cmd.Flags().StringVarP(&input, "input", "", "", "Regex matching the input files; named groups can be used in --output")
+	cmd.Flags().StringVarP(&output, "output", "", "", "Go template producing the output file for each input")
+	cmd.Flags().IntVarP(&workers, "workers", "", 8, "Number of files to transform concurrently")
+	cmd.Flags().BoolVarP(&overwrite, "overwrite", "", false, "Transform files even if the output already exists")
+	cmd.Flags().BoolVarP(&dryRun, "dry-run", "", false, "Print the inputs and outputs without transforming them")
+	cmd.Flags().BoolVarP(&compression, "compression", "", false, "Decompress and compress files based on their extension e.g. .gz; only applies when copying")
+	return cmd
+}
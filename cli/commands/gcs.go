@@ -0,0 +1,79 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/jlewi/monogo/gcp/gcs"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+// NewGCSCommands creates new commands for working with GCS
+func NewGCSCommands() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "gcs",
+		Short: "Commands for working with GCS",
+	}
+
+	cmd.AddCommand(NewSignURLCommand())
+	return cmd
+}
+
+// NewSignURLCommand creates a command to generate signed URLs
+func NewSignURLCommand() *cobra.Command {
+	var method string
+	var expires time.Duration
+	var keyFile string
+	var serviceAccount string
+	cmd := &cobra.Command{
+		Use:   "sign-url [gs://bucket/object]",
+		Args:  cobra.ExactArgs(1),
+		Short: "Generate a V4 signed URL for a GCS object.",
+		Long: `Generate a V4 signed URL for a GCS object.
+
+The URL can be signed with a service account key file or, if no key file is given, by calling the IAM signBlob API
+as the given service account. Using IAM requires application default credentials that are allowed to create
+tokens for the service account e.g.
+
+devcli gcs sign-url gs://bucket/object --service-account=signer@project.iam.gserviceaccount.com --expires=1h
+`,
+		Run: func(cmd *cobra.Command, args []string) {
+			err := func() error {
+				ctx := context.Background()
+				var signer *gcs.URLSigner
+				var err error
+				switch {
+				case keyFile != "":
+					signer, err = gcs.NewKeyFileSigner(keyFile)
+				case serviceAccount != "":
+					signer, err = gcs.NewIAMSigner(ctx, serviceAccount)
+				default:
+					return errors.New("Either --key-file or --service-account must be specified")
+				}
+				if err != nil {
+					return err
+				}
+
+				u, err := gcs.SignedURL(args[0], method, expires, signer)
+				if err != nil {
+					return err
+				}
+				fmt.Fprintln(os.Stdout, u)
+				return nil
+			}()
+			if err != nil {
+				fmt.Printf("Error: %+v", err)
+				os.Exit(1)
+			}
+		},
+	}
+
+	cmd.Flags().StringVarP(&method, "method", "", "GET", "The HTTP method the URL can be used with e.g. GET or PUT")
+	cmd.Flags().DurationVarP(&expires, "expires", "", time.Hour, "How long the URL is valid for; at most 7 days")
+	cmd.Flags().StringVarP(&keyFile, "key-file", "", "", "JSON key file of the service account to sign the URL with")
+	cmd.Flags().StringVarP(&serviceAccount, "service-account", "", "", "Email of the service account to sign the URL as using IAM; ignored if --key-file is set")
+	return cmd
+}
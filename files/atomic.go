@@ -0,0 +1,145 @@
+package files
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/jlewi/monogo/helpers"
+	"github.com/pkg/errors"
+)
+
+// Aborter is implemented by writers that can discard everything written so far instead of committing it.
+// Writers returned by FileHelper.NewWriter may implement it; use Abort to call it when available.
+type Aborter interface {
+	// Abort discards the data and releases any resources. The destination is left untouched.
+	Abort() error
+}
+
+// Abort aborts the writer if it implements Aborter. Otherwise it returns an error because the writer
+// can't guarantee the destination is untouched; the caller should e.g. cancel the writer's context instead.
+func Abort(w io.Writer) error {
+	a, ok := w.(Aborter)
+	if !ok {
+		return errors.Errorf("Writer of type %T doesn't support Abort", w)
+	}
+	return a.Abort()
+}
+
+// abortOrClose aborts the writer if possible so a partially written file isn't committed; otherwise it closes it.
+func abortOrClose(w io.WriteCloser) error {
+	if _, ok := w.(Aborter); ok {
+		return Abort(w)
+	}
+	return w.Close()
+}
+
+// AtomicFileWriter writes a local file atomically. Data is written to a temporary file in the same directory
+// which is fsynced and renamed into place on Close. Readers therefore never see a partially written file
+// and if the write fails the original file, if any, is left untouched.
+//
+// If a call to Write fails or the context used to create the writer is done, Close aborts the write.
+type AtomicFileWriter struct {
+	ctx  context.Context
+	path string
+	tmp  *os.File
+	// err is the first error returned by Write.
+	err  error
+	done bool
+}
+
+func newAtomicFileWriter(ctx context.Context, path string) (*AtomicFileWriter, error) {
+	dir, base := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+	tmp, err := os.CreateTemp(dir, "."+base+".tmp-*")
+	if err != nil {
+		return nil, errors.WithStack(errors.Wrapf(err, "Could not create temporary file for: %v", path))
+	}
+
+	// CreateTemp creates the file with mode 0600. Preserve the mode of the file being replaced; otherwise use
+	// the mode os.Create would typically produce.
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		helpers.IgnoreError(tmp.Close())
+		helpers.IgnoreError(os.Remove(tmp.Name()))
+		return nil, errors.WithStack(errors.Wrapf(err, "Could not set the mode of the temporary file for: %v", path))
+	}
+
+	return &AtomicFileWriter{
+		ctx:  ctx,
+		path: path,
+		tmp:  tmp,
+	}, nil
+}
+
+// Write writes to the temporary file.
+func (w *AtomicFileWriter) Write(p []byte) (int, error) {
+	if w.done {
+		return 0, errors.Errorf("Write called on closed writer for: %v", w.path)
+	}
+	n, err := w.tmp.Write(p)
+	if err != nil && w.err == nil {
+		w.err = err
+	}
+	return n, err
+}
+
+// Close commits the data by fsyncing the temporary file and renaming it to the destination.
+func (w *AtomicFileWriter) Close() error {
+	if w.done {
+		return nil
+	}
+	if w.err != nil {
+		return errors.Wrapf(w.abort(w.err), "Write to %v failed; the file wasn't modified", w.path)
+	}
+	if err := w.ctx.Err(); err != nil {
+		return errors.Wrapf(w.abort(err), "Context is done; %v wasn't modified", w.path)
+	}
+
+	w.done = true
+	if err := w.tmp.Sync(); err != nil {
+		helpers.IgnoreError(w.tmp.Close())
+		helpers.IgnoreError(os.Remove(w.tmp.Name()))
+		return errors.WithStack(errors.Wrapf(err, "Could not sync: %v", w.tmp.Name()))
+	}
+	if err := w.tmp.Close(); err != nil {
+		helpers.IgnoreError(os.Remove(w.tmp.Name()))
+		return errors.WithStack(errors.Wrapf(err, "Could not close: %v", w.tmp.Name()))
+	}
+	if err := os.Rename(w.tmp.Name(), w.path); err != nil {
+		helpers.IgnoreError(os.Remove(w.tmp.Name()))
+		return errors.WithStack(errors.Wrapf(err, "Could not rename %v to %v", w.tmp.Name(), w.path))
+	}
+	return nil
+}
+
+// Abort discards the data. The destination is left untouched.
+func (w *AtomicFileWriter) Abort() error {
+	if w.done {
+		return nil
+	}
+	return w.abort(nil)
+}
+
+// abort removes the temporary file and returns cause if it isn't nil.
+func (w *AtomicFileWriter) abort(cause error) error {
+	w.done = true
+	closeErr := w.tmp.Close()
+	removeErr := os.Remove(w.tmp.Name())
+	if cause != nil {
+		return cause
+	}
+	if closeErr != nil {
+		return errors.WithStack(closeErr)
+	}
+	if removeErr != nil {
+		return errors.WithStack(removeErr)
+	}
+	return nil
+}
@@ -0,0 +1,360 @@
+package files
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-logr/zapr"
+	"github.com/jlewi/monogo/helpers"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+const (
+	// DefaultCacheMaxSize is the size cap used when Cache.MaxSize isn't set.
+	DefaultCacheMaxSize = 256 << 20
+)
+
+// statter is implemented by helpers which can cheaply report the version of a file; every DirectoryHelper does.
+type statter interface {
+	Stat(ctx context.Context, uri string) (*FileInfo, error)
+}
+
+// Cache is a read-through cache which stores the contents of files on local disk. It is intended for CLIs which
+// read the same remote files (e.g. configuration stored in GCS or secret manager) on every invocation.
+//
+// Entries are keyed by the URI and the version of the file; i.e. the generation if the backend reports one,
+// otherwise the etag, otherwise the modification time and size. Each read revalidates the entry by calling Stat
+// on the underlying helper which is much cheaper than reading the contents. Helpers which don't implement Stat
+// aren't cached.
+//
+// When the total size of the entries exceeds MaxSize the least recently used entries are evicted. The access time
+// is tracked using the modification time of the entry so it persists across processes.
+//
+// N.B. Contents, including secrets, are stored unencrypted; the directory and entries are only readable by the
+// current user.
+type Cache struct {
+	// Dir is the directory where entries are stored.
+	Dir string
+	// MaxSize is the maximum total size of the entries in bytes. Defaults to DefaultCacheMaxSize.
+	MaxSize int64
+
+	mu sync.Mutex
+}
+
+// NewCache creates a cache which stores entries in dir, creating it if necessary. If dir is empty
+// DefaultCacheDir is used.
+func NewCache(dir string, maxSize int64) (*Cache, error) {
+	if dir == "" {
+		d, err := DefaultCacheDir()
+		if err != nil {
+			return nil, err
+		}
+		dir = d
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, errors.Wrapf(err, "Failed to create cache directory %v", dir)
+	}
+	return &Cache{Dir: dir, MaxSize: maxSize}, nil
+}
+
+// DefaultCacheDir returns the default directory for the cache; e.g. ~/.cache/monogo/files on linux.
+func DefaultCacheDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", errors.Wrapf(err, "Failed to locate the user's cache directory")
+	}
+	return filepath.Join(dir, "monogo", "files"), nil
+}
+
+// Wrap returns a FileHelper which reads through the cache. Writes go directly to h and invalidate the entries
+// for the file.
+func (c *Cache) Wrap(h FileHelper) FileHelper {
+	return &cachingHelper{h: h, c: c}
+}
+
+// WrapDir is the DirectoryHelper version of Wrap.
+func (c *Cache) WrapDir(h DirectoryHelper) DirectoryHelper {
+	return &cachingDirHelper{DirectoryHelper: h, c: c}
+}
+
+// Clear removes all the entries.
+func (c *Cache) Clear() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entries, err := os.ReadDir(c.Dir)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to read cache directory %v", c.Dir)
+	}
+	for _, e := range entries {
+		if err := os.Remove(filepath.Join(c.Dir, e.Name())); err != nil && !os.IsNotExist(err) {
+			return errors.Wrapf(err, "Failed to remove cache entry %v", e.Name())
+		}
+	}
+	return nil
+}
+
+func (c *Cache) maxSize() int64 {
+	if c.MaxSize <= 0 {
+		return DefaultCacheMaxSize
+	}
+	return c.MaxSize
+}
+
+// uriKey returns the prefix of the names of the entries for uri.
+func uriKey(uri string) string {
+	sum := sha256.Sum256([]byte(uri))
+	return hex.EncodeToString(sum[:16])
+}
+
+// versionKey returns a key identifying the version of the file described by info or "" if the version can't be
+// determined.
+func versionKey(info *FileInfo) string {
+	var v string
+	switch {
+	case info.Generation != 0:
+		v = fmt.Sprintf("g%d", info.Generation)
+	case info.Etag != "":
+		v = "e" + info.Etag
+	case !info.ModTime.IsZero():
+		v = fmt.Sprintf("m%d-%d", info.ModTime.UnixNano(), info.Size)
+	default:
+		return ""
+	}
+	sum := sha256.Sum256([]byte(v))
+	return hex.EncodeToString(sum[:16])
+}
+
+// read returns a reader for uri, serving it from the cache if the cached version is current.
+func (c *Cache) read(ctx context.Context, h FileHelper, uri string) (io.ReadCloser, error) {
+	log := zapr.NewLogger(zap.L())
+	s, ok := h.(statter)
+	if !ok {
+		return h.NewReaderContext(ctx, uri)
+	}
+	info, err := s.Stat(ctx, uri)
+	if err != nil {
+		return nil, err
+	}
+	version := versionKey(info)
+	if version == "" || info.Size > c.maxSize() {
+		return h.NewReaderContext(ctx, uri)
+	}
+
+	entry := filepath.Join(c.Dir, uriKey(uri)+"."+version)
+	if f, err := os.Open(entry); err == nil {
+		// Record the access for LRU eviction.
+		now := time.Now()
+		if err := os.Chtimes(entry, now, now); err != nil {
+			log.V(1).Info("Failed to update the access time of cache entry", "uri", uri, "err", err)
+		}
+		return f, nil
+	}
+
+	tmp, err := c.fetch(ctx, h, uri)
+	if err != nil {
+		return nil, err
+	}
+	defer helpers.DeferIgnoreError(func() error { return os.Remove(tmp) })
+
+	// If the file changed while we were reading it we don't know which version we have so don't cache it.
+	after, err := s.Stat(ctx, uri)
+	if err == nil && versionKey(after) == version {
+		if err := c.store(uri, tmp, entry); err != nil {
+			log.Error(err, "Failed to add file to the cache", "uri", uri)
+		}
+	}
+	// The open file remains readable after tmp is removed.
+	f, err := os.Open(tmp)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to open the copy of %v", uri)
+	}
+	return f, nil
+}
+
+// fetch copies the contents of uri to a temporary file in the cache directory and returns its path.
+func (c *Cache) fetch(ctx context.Context, h FileHelper, uri string) (string, error) {
+	r, err := h.NewReaderContext(ctx, uri)
+	if err != nil {
+		return "", err
+	}
+	defer helpers.DeferIgnoreError(r.Close)
+
+	if err := os.MkdirAll(c.Dir, 0700); err != nil {
+		return "", errors.Wrapf(err, "Failed to create cache directory %v", c.Dir)
+	}
+	// Temporary files start with "." so they aren't mistaken for entries.
+	f, err := os.CreateTemp(c.Dir, ".tmp-*")
+	if err != nil {
+		return "", errors.Wrapf(err, "Failed to create a temporary file in %v", c.Dir)
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		helpers.IgnoreError(f.Close())
+		helpers.IgnoreError(os.Remove(f.Name()))
+		return "", errors.Wrapf(err, "Failed to read %v", uri)
+	}
+	if err := f.Close(); err != nil {
+		helpers.IgnoreError(os.Remove(f.Name()))
+		return "", errors.Wrapf(err, "Failed to write the cached copy of %v", uri)
+	}
+	return f.Name(), nil
+}
+
+// store adds tmp to the cache as entry, removing older versions of the uri and evicting entries if the cache is
+// over its size cap.
+func (c *Cache) store(uri string, tmp string, entry string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.invalidateLocked(uri); err != nil {
+		return err
+	}
+	// Link rather than rename so tmp can still be read by the caller.
+	if err := os.Link(tmp, entry); err != nil && !os.IsExist(err) {
+		return errors.Wrapf(err, "Failed to create cache entry for %v", uri)
+	}
+	return c.evictLocked()
+}
+
+// invalidate removes all the entries for uri.
+func (c *Cache) invalidate(uri string) error {
+	if _, err := os.Stat(c.Dir); os.IsNotExist(err) {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.invalidateLocked(uri)
+}
+
+func (c *Cache) invalidateLocked(uri string) error {
+	entries, err := os.ReadDir(c.Dir)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to read cache directory %v", c.Dir)
+	}
+	prefix := uriKey(uri) + "."
+	for _, e := range entries {
+		if !strings.HasPrefix(e.Name(), prefix) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(c.Dir, e.Name())); err != nil && !os.IsNotExist(err) {
+			return errors.Wrapf(err, "Failed to remove cache entry %v", e.Name())
+		}
+	}
+	return nil
+}
+
+// evictLocked removes the least recently used entries until the cache is within its size cap.
+func (c *Cache) evictLocked() error {
+	dirEntries, err := os.ReadDir(c.Dir)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to read cache directory %v", c.Dir)
+	}
+	entries := make([]os.FileInfo, 0, len(dirEntries))
+	total := int64(0)
+	for _, e := range dirEntries {
+		if strings.HasPrefix(e.Name(), ".") || e.IsDir() {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			// The entry was removed e.g. by another process.
+			continue
+		}
+		entries = append(entries, info)
+		total += info.Size()
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].ModTime().Before(entries[j].ModTime())
+	})
+	for _, e := range entries {
+		if total <= c.maxSize() {
+			break
+		}
+		if err := os.Remove(filepath.Join(c.Dir, e.Name())); err != nil && !os.IsNotExist(err) {
+			return errors.Wrapf(err, "Failed to evict cache entry %v", e.Name())
+		}
+		total -= e.Size()
+	}
+	return nil
+}
+
+// write creates a writer for uri after invalidating the cached copies.
+func (c *Cache) write(ctx context.Context, h FileHelper, uri string) (io.WriteCloser, error) {
+	if err := c.invalidate(uri); err != nil {
+		return nil, err
+	}
+	return h.NewWriterContext(ctx, uri)
+}
+
+// cachingHelper wraps a FileHelper so that reads go through a Cache.
+type cachingHelper struct {
+	h FileHelper
+	c *Cache
+}
+
+func (c *cachingHelper) Exists(uri string) (bool, error) {
+	return c.h.Exists(uri)
+}
+
+func (c *cachingHelper) ExistsContext(ctx context.Context, uri string) (bool, error) {
+	return c.h.ExistsContext(ctx, uri)
+}
+
+func (c *cachingHelper) NewReader(uri string) (io.ReadCloser, error) {
+	return c.NewReaderContext(context.Background(), uri)
+}
+
+func (c *cachingHelper) NewReaderContext(ctx context.Context, uri string) (io.ReadCloser, error) {
+	return c.c.read(ctx, c.h, uri)
+}
+
+func (c *cachingHelper) NewWriter(uri string) (io.WriteCloser, error) {
+	return c.NewWriterContext(context.Background(), uri)
+}
+
+func (c *cachingHelper) NewWriterContext(ctx context.Context, uri string) (io.WriteCloser, error) {
+	return c.c.write(ctx, c.h, uri)
+}
+
+// cachingDirHelper is the DirectoryHelper version of cachingHelper.
+type cachingDirHelper struct {
+	DirectoryHelper
+	c *Cache
+}
+
+func (c *cachingDirHelper) NewReader(uri string) (io.ReadCloser, error) {
+	return c.NewReaderContext(context.Background(), uri)
+}
+
+func (c *cachingDirHelper) NewReaderContext(ctx context.Context, uri string) (io.ReadCloser, error) {
+	return c.c.read(ctx, c.DirectoryHelper, uri)
+}
+
+func (c *cachingDirHelper) NewWriter(uri string) (io.WriteCloser, error) {
+	return c.NewWriterContext(context.Background(), uri)
+}
+
+func (c *cachingDirHelper) NewWriterContext(ctx context.Context, uri string) (io.WriteCloser, error) {
+	return c.c.write(ctx, c.DirectoryHelper, uri)
+}
+
+// Delete deletes the file and its cached copies.
+func (c *cachingDirHelper) Delete(ctx context.Context, uri string) error {
+	if err := c.c.invalidate(uri); err != nil {
+		return err
+	}
+	return c.DirectoryHelper.Delete(ctx, uri)
+}
+
+// Watch watches the underlying helper so it is used if it implements Watcher rather than polling.
+func (c *cachingDirHelper) Watch(ctx context.Context, uri string, opts *WatchOptions) (<-chan WatchEvent, error) {
+	return Watch(ctx, c.DirectoryHelper, uri, opts)
+}
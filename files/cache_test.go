@@ -0,0 +1,131 @@
+package files
+
+import (
+	"context"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+// countingHelper counts the number of times each file is read.
+type countingHelper struct {
+	*MemFileHelper
+	reads map[string]int
+}
+
+func (h *countingHelper) NewReaderContext(ctx context.Context, uri string) (io.ReadCloser, error) {
+	h.reads[uri]++
+	return h.MemFileHelper.NewReaderContext(ctx, uri)
+}
+
+func Test_Cache(t *testing.T) {
+	ctx := context.Background()
+	tDir, err := os.MkdirTemp("", "testCache")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(tDir)
+
+	c, err := NewCache(tDir, 25)
+	if err != nil {
+		t.Fatalf("NewCache failed; error: %v", err)
+	}
+	mem := &countingHelper{MemFileHelper: NewMemFileHelper(), reads: map[string]int{}}
+	h := c.WrapDir(mem)
+
+	// check reads uri through the cache and checks the contents and the number of times the underlying helper
+	// has read it.
+	check := func(uri string, expected string, reads int) {
+		t.Helper()
+		r, err := h.NewReaderContext(ctx, uri)
+		if err != nil {
+			t.Fatalf("NewReaderContext(%v) failed; error: %v", uri, err)
+		}
+		actual, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("ReadAll(%v) failed; error: %v", uri, err)
+		}
+		if err := r.Close(); err != nil {
+			t.Fatalf("Close(%v) failed; error: %v", uri, err)
+		}
+		if string(actual) != expected {
+			t.Errorf("Read(%v) got %v; want %v", uri, string(actual), expected)
+		}
+		if mem.reads[uri] != reads {
+			t.Errorf("%v was read %v times; want %v", uri, mem.reads[uri], reads)
+		}
+	}
+
+	writeFile(t, h, "mem://cache/a", "aaaaaaaaaa")
+	check("mem://cache/a", "aaaaaaaaaa", 1)
+	// The second read is served from the cache.
+	check("mem://cache/a", "aaaaaaaaaa", 1)
+
+	// Changing the file changes its generation so the entry is stale.
+	writeFile(t, h, "mem://cache/a", "AAAAAAAAAA")
+	check("mem://cache/a", "AAAAAAAAAA", 2)
+	check("mem://cache/a", "AAAAAAAAAA", 2)
+
+	// Writes that bypass the cache are detected when the entry is revalidated.
+	writeFile(t, mem, "mem://cache/a", "bypass")
+	check("mem://cache/a", "bypass", 3)
+
+	// Only one version of each file is kept.
+	entries, err := os.ReadDir(tDir)
+	if err != nil {
+		t.Fatalf("ReadDir failed; error: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("Got %v cache entries; want 1", len(entries))
+	}
+
+	// Adding b and c exceeds the size cap so a, the least recently used entry, is evicted.
+	writeFile(t, h, "mem://cache/b", strings.Repeat("b", 10))
+	writeFile(t, h, "mem://cache/c", strings.Repeat("c", 10))
+	check("mem://cache/b", strings.Repeat("b", 10), 1)
+	check("mem://cache/c", strings.Repeat("c", 10), 1)
+	check("mem://cache/b", strings.Repeat("b", 10), 1)
+	check("mem://cache/c", strings.Repeat("c", 10), 1)
+	check("mem://cache/a", "bypass", 4)
+
+	// Files larger than the cache aren't cached.
+	writeFile(t, h, "mem://cache/big", strings.Repeat("x", 100))
+	check("mem://cache/big", strings.Repeat("x", 100), 1)
+	check("mem://cache/big", strings.Repeat("x", 100), 2)
+}
+
+func Test_CacheFactory(t *testing.T) {
+	defer DefaultMemFileHelper.Reset()
+	tDir, err := os.MkdirTemp("", "testCacheFactory")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(tDir)
+
+	c, err := NewCache(tDir, 0)
+	if err != nil {
+		t.Fatalf("NewCache failed; error: %v", err)
+	}
+	f := &Factory{Cache: c}
+	writeMemFile(t, "mem://cachefactory/config.yaml", "key: value")
+	h, err := f.Get("mem://cachefactory/config.yaml")
+	if err != nil {
+		t.Fatalf("Get failed; error: %v", err)
+	}
+	r, err := h.NewReader("mem://cachefactory/config.yaml")
+	if err != nil {
+		t.Fatalf("NewReader failed; error: %v", err)
+	}
+	defer r.Close()
+	if _, ok := r.(*os.File); !ok {
+		t.Errorf("Got reader of type %T; want the file to be read through the cache", r)
+	}
+	entries, err := os.ReadDir(tDir)
+	if err != nil {
+		t.Fatalf("ReadDir failed; error: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("Got %v cache entries; want 1", len(entries))
+	}
+}
@@ -0,0 +1,217 @@
+package files
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/jlewi/monogo/helpers"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+)
+
+// Codec compresses and decompresses files whose names end in a particular extension e.g. .gz.
+type Codec struct {
+	// NewReader returns a reader that decompresses the data read from r. Closing it mustn't close r.
+	NewReader func(r io.Reader) (io.ReadCloser, error)
+	// NewWriter returns a writer that compresses the data and writes it to w. Closing it must flush any buffered
+	// data but mustn't close w.
+	NewWriter func(w io.Writer) (io.WriteCloser, error)
+}
+
+var (
+	codecsMu sync.RWMutex
+	codecs   map[string]Codec
+)
+
+// resetCodecs restores the codec registry to just the built in codecs.
+func resetCodecs() {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	codecs = map[string]Codec{
+		".gz": {
+			NewReader: func(r io.Reader) (io.ReadCloser, error) {
+				return gzip.NewReader(r)
+			},
+			NewWriter: func(w io.Writer) (io.WriteCloser, error) {
+				return gzip.NewWriter(w), nil
+			},
+		},
+		".zst": {
+			NewReader: func(r io.Reader) (io.ReadCloser, error) {
+				d, err := zstd.NewReader(r)
+				if err != nil {
+					return nil, err
+				}
+				return d.IOReadCloser(), nil
+			},
+			NewWriter: func(w io.Writer) (io.WriteCloser, error) {
+				return zstd.NewWriter(w)
+			},
+		},
+	}
+}
+
+// RegisterCodec registers a codec for files with the extension ext e.g. ".bz2". Codecs are used by helpers
+// returned by a Factory with TransparentCompression enabled. gzip (.gz) and zstd (.zst) are built in.
+// An error is returned if a codec is already registered for ext.
+func RegisterCodec(ext string, c Codec) error {
+	if !strings.HasPrefix(ext, ".") || len(ext) < 2 || strings.Contains(ext, "/") {
+		return errors.Errorf("Invalid extension %q; it must start with . e.g. .bz2", ext)
+	}
+	if c.NewReader == nil || c.NewWriter == nil {
+		return errors.Errorf("Can't register codec for %v; NewReader and NewWriter are required", ext)
+	}
+	ext = strings.ToLower(ext)
+
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	if _, ok := codecs[ext]; ok {
+		return errors.Errorf("A codec is already registered for %v", ext)
+	}
+	codecs[ext] = c
+	return nil
+}
+
+// codecFor returns the codec for the uri or nil if the data isn't compressed.
+func codecFor(uri string) *Codec {
+	ext := strings.ToLower(path.Ext(uri))
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	c, ok := codecs[ext]
+	if !ok {
+		return nil
+	}
+	return &c
+}
+
+// compressingHelper wraps a FileHelper so that files with a registered compression extension are decompressed
+// when read and compressed when written.
+type compressingHelper struct {
+	h FileHelper
+}
+
+func (c *compressingHelper) Exists(uri string) (bool, error) {
+	return c.h.Exists(uri)
+}
+
+func (c *compressingHelper) ExistsContext(ctx context.Context, uri string) (bool, error) {
+	return c.h.ExistsContext(ctx, uri)
+}
+
+func (c *compressingHelper) NewReader(uri string) (io.ReadCloser, error) {
+	return c.NewReaderContext(context.Background(), uri)
+}
+
+func (c *compressingHelper) NewReaderContext(ctx context.Context, uri string) (io.ReadCloser, error) {
+	return newDecompressingReader(ctx, c.h, uri)
+}
+
+func (c *compressingHelper) NewWriter(uri string) (io.WriteCloser, error) {
+	return c.NewWriterContext(context.Background(), uri)
+}
+
+func (c *compressingHelper) NewWriterContext(ctx context.Context, uri string) (io.WriteCloser, error) {
+	return newCompressingWriter(ctx, c.h, uri)
+}
+
+// compressingDirHelper is the DirectoryHelper version of compressingHelper. Stat and List report the size of the
+// compressed data.
+type compressingDirHelper struct {
+	DirectoryHelper
+}
+
+func (c *compressingDirHelper) NewReader(uri string) (io.ReadCloser, error) {
+	return c.NewReaderContext(context.Background(), uri)
+}
+
+func (c *compressingDirHelper) NewReaderContext(ctx context.Context, uri string) (io.ReadCloser, error) {
+	return newDecompressingReader(ctx, c.DirectoryHelper, uri)
+}
+
+func (c *compressingDirHelper) NewWriter(uri string) (io.WriteCloser, error) {
+	return c.NewWriterContext(context.Background(), uri)
+}
+
+func (c *compressingDirHelper) NewWriterContext(ctx context.Context, uri string) (io.WriteCloser, error) {
+	return newCompressingWriter(ctx, c.DirectoryHelper, uri)
+}
+
+// Watch watches the underlying helper so it is used if it implements Watcher rather than polling.
+func (c *compressingDirHelper) Watch(ctx context.Context, uri string, opts *WatchOptions) (<-chan WatchEvent, error) {
+	return Watch(ctx, c.DirectoryHelper, uri, opts)
+}
+
+func newDecompressingReader(ctx context.Context, h FileHelper, uri string) (io.ReadCloser, error) {
+	codec := codecFor(uri)
+	r, err := h.NewReaderContext(ctx, uri)
+	if err != nil || codec == nil {
+		return r, err
+	}
+	dec, err := codec.NewReader(r)
+	if err != nil {
+		helpers.IgnoreError(r.Close())
+		return nil, errors.Wrapf(err, "Failed to decompress %v", uri)
+	}
+	return &decompressingReader{ReadCloser: dec, src: r}, nil
+}
+
+// decompressingReader closes both the decompressor and the underlying reader.
+type decompressingReader struct {
+	io.ReadCloser
+	src io.ReadCloser
+}
+
+func (r *decompressingReader) Close() error {
+	err := r.ReadCloser.Close()
+	if srcErr := r.src.Close(); err == nil {
+		err = srcErr
+	}
+	return err
+}
+
+func newCompressingWriter(ctx context.Context, h FileHelper, uri string) (io.WriteCloser, error) {
+	codec := codecFor(uri)
+	w, err := h.NewWriterContext(ctx, uri)
+	if err != nil || codec == nil {
+		return w, err
+	}
+	enc, err := codec.NewWriter(w)
+	if err != nil {
+		helpers.IgnoreError(abortOrClose(w))
+		return nil, errors.Wrapf(err, "Failed to compress %v", uri)
+	}
+	cw := &compressingWriter{WriteCloser: enc, dst: w, uri: uri}
+	if _, ok := w.(Aborter); ok {
+		return &abortingCompressingWriter{compressingWriter: cw}, nil
+	}
+	return cw, nil
+}
+
+// compressingWriter flushes the compressor and then closes the underlying writer.
+type compressingWriter struct {
+	io.WriteCloser
+	dst io.WriteCloser
+	uri string
+}
+
+func (w *compressingWriter) Close() error {
+	if err := w.WriteCloser.Close(); err != nil {
+		helpers.IgnoreError(abortOrClose(w.dst))
+		return errors.Wrapf(err, "Failed to compress %v", w.uri)
+	}
+	return w.dst.Close()
+}
+
+// abortingCompressingWriter is a compressingWriter whose underlying writer implements Aborter.
+type abortingCompressingWriter struct {
+	*compressingWriter
+}
+
+// Abort discards the data; see Aborter.
+func (w *abortingCompressingWriter) Abort() error {
+	return Abort(w.dst)
+}
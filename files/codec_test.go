@@ -0,0 +1,231 @@
+package files
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"hash/crc32"
+	"io"
+	"strings"
+	"testing"
+)
+
+func Test_TransparentCompression(t *testing.T) {
+	defer DefaultMemFileHelper.Reset()
+	ctx := context.Background()
+	f := &Factory{TransparentCompression: true}
+	contents := strings.Repeat("some line of data\n", 100)
+
+	for _, uri := range []string{"mem://codec/data.jsonl.gz", "mem://codec/data.jsonl.zst", "mem://codec/data.jsonl"} {
+		h, err := f.GetDirHelper(uri)
+		if err != nil {
+			t.Fatalf("GetDirHelper(%v) error: %v", uri, err)
+		}
+		writeFile(t, h, uri, contents)
+
+		r, err := h.NewReaderContext(ctx, uri)
+		if err != nil {
+			t.Fatalf("NewReaderContext(%v) error: %v", uri, err)
+		}
+		actual, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("ReadAll(%v) error: %v", uri, err)
+		}
+		if err := r.Close(); err != nil {
+			t.Fatalf("Close(%v) error: %v", uri, err)
+		}
+		if string(actual) != contents {
+			t.Errorf("Read(%v) didn't return the data that was written", uri)
+		}
+	}
+
+	// The data is stored compressed.
+	raw, err := Read("mem://codec/data.jsonl.gz")
+	if err != nil {
+		t.Fatalf("Read() error: %v", err)
+	}
+	if !bytes.HasPrefix(raw, []byte{0x1f, 0x8b}) || len(raw) >= len(contents) {
+		t.Errorf("mem://codec/data.jsonl.gz isn't gzip compressed")
+	}
+	raw, err = Read("mem://codec/data.jsonl.zst")
+	if err != nil {
+		t.Fatalf("Read() error: %v", err)
+	}
+	if !bytes.HasPrefix(raw, []byte{0x28, 0xb5, 0x2f, 0xfd}) || len(raw) >= len(contents) {
+		t.Errorf("mem://codec/data.jsonl.zst isn't zstd compressed")
+	}
+	raw, err = Read("mem://codec/data.jsonl")
+	if err != nil {
+		t.Fatalf("Read() error: %v", err)
+	}
+	if string(raw) != contents {
+		t.Errorf("mem://codec/data.jsonl shouldn't be compressed")
+	}
+}
+
+func Test_RegisterCodec(t *testing.T) {
+	defer resetRegistry()
+	defer DefaultMemFileHelper.Reset()
+	f := &Factory{TransparentCompression: true}
+
+	h, err := f.Get("mem://codec/data.upper")
+	if err != nil {
+		t.Fatalf("Get() error: %v", err)
+	}
+
+	// A toy codec which upper cases the data when it is written; it's enough to check the codec is used.
+	codec := Codec{
+		NewReader: func(r io.Reader) (io.ReadCloser, error) {
+			return io.NopCloser(r), nil
+		},
+		NewWriter: func(w io.Writer) (io.WriteCloser, error) {
+			return &upperWriter{w: w}, nil
+		},
+	}
+	if err := RegisterCodec(".upper", codec); err != nil {
+		t.Fatalf("RegisterCodec() error: %v", err)
+	}
+	if err := RegisterCodec(".zst", codec); err == nil {
+		t.Errorf("RegisterCodec() should fail when the extension is already registered")
+	}
+	if err := RegisterCodec("upper", codec); err == nil {
+		t.Errorf("RegisterCodec() should fail for an extension without a dot")
+	}
+
+	writeFile(t, h, "mem://codec/data.upper", "hello")
+	raw, err := Read("mem://codec/data.upper")
+	if err != nil {
+		t.Fatalf("Read() error: %v", err)
+	}
+	if string(raw) != "HELLO" {
+		t.Errorf("Got %v; want the data to be encoded by the registered codec", string(raw))
+	}
+}
+
+type upperWriter struct {
+	w io.Writer
+}
+
+func (u *upperWriter) Write(p []byte) (int, error) {
+	return u.w.Write([]byte(strings.ToUpper(string(p))))
+}
+
+func (u *upperWriter) Close() error {
+	return nil
+}
+
+func Test_Digest(t *testing.T) {
+	data := strings.Repeat("0123456789", 1000)
+	sha := sha256.Sum256([]byte(data))
+	expected := Digest{
+		SHA256: sha[:],
+		CRC32C: crc32.Checksum([]byte(data), crc32cTable),
+		Size:   int64(len(data)),
+	}
+
+	r := NewDigestReader(io.NopCloser(strings.NewReader(data)))
+	r.Expected = &expected
+	if _, err := io.Copy(io.Discard, r); err != nil {
+		t.Fatalf("Reading failed; error: %v", err)
+	}
+	if r.Digest().SHA256Hex() != expected.SHA256Hex() || r.Digest().CRC32C != expected.CRC32C {
+		t.Errorf("DigestReader computed the wrong digest")
+	}
+
+	corrupt := NewDigestReader(io.NopCloser(strings.NewReader(data + "x")))
+	corrupt.Expected = &Digest{SHA256: sha[:]}
+	if _, err := io.Copy(io.Discard, corrupt); err == nil {
+		t.Errorf("DigestReader should fail when the SHA-256 doesn't match")
+	}
+
+	buf := &bytes.Buffer{}
+	w := NewDigestWriter(nopWriteCloser{buf})
+	if _, err := io.WriteString(w, data); err != nil {
+		t.Fatalf("Write failed; error: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed; error: %v", err)
+	}
+	if err := w.Digest().verify(expected); err != nil {
+		t.Errorf("DigestWriter computed the wrong digest; %v", err)
+	}
+}
+
+func Test_WrappersAbort(t *testing.T) {
+	ctx := context.Background()
+
+	// If the underlying writer can't abort, neither can the wrappers so abortOrClose closes the underlying writer.
+	rec := &closeRecorder{}
+	h := &fixedWriterHelper{MemFileHelper: NewMemFileHelper(), w: rec}
+	cw, err := newCompressingWriter(ctx, h, "mem://codec/data.gz")
+	if err != nil {
+		t.Fatalf("newCompressingWriter error: %v", err)
+	}
+	for name, w := range map[string]io.WriteCloser{"compressing": cw, "digest": NewDigestWriter(rec)} {
+		rec.closed = false
+		if _, ok := w.(Aborter); ok {
+			t.Errorf("%v writer shouldn't implement Aborter if the underlying writer doesn't", name)
+		}
+		if err := abortOrClose(w); err != nil {
+			t.Errorf("%v abortOrClose error: %v", name, err)
+		}
+		if !rec.closed {
+			t.Errorf("%v abortOrClose didn't close the underlying writer", name)
+		}
+	}
+
+	// If the underlying writer can abort, so can the wrappers and nothing is written.
+	mem := NewMemFileHelper()
+	cw, err = newCompressingWriter(ctx, mem, "mem://codec/compressed.gz")
+	if err != nil {
+		t.Fatalf("newCompressingWriter error: %v", err)
+	}
+	mw, err := mem.NewWriterContext(ctx, "mem://codec/digest.txt")
+	if err != nil {
+		t.Fatalf("NewWriterContext error: %v", err)
+	}
+	for uri, w := range map[string]io.WriteCloser{"mem://codec/compressed.gz": cw, "mem://codec/digest.txt": NewDigestWriter(mw)} {
+		if _, err := io.WriteString(w, "data"); err != nil {
+			t.Fatalf("Write(%v) error: %v", uri, err)
+		}
+		if err := Abort(w); err != nil {
+			t.Errorf("Abort(%v) error: %v", uri, err)
+		}
+		exists, err := mem.Exists(uri)
+		if err != nil {
+			t.Fatalf("Exists(%v) error: %v", uri, err)
+		}
+		if exists {
+			t.Errorf("Abort(%v) should discard the data", uri)
+		}
+	}
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// closeRecorder is a writer which can't abort and records whether it was closed.
+type closeRecorder struct {
+	bytes.Buffer
+	closed bool
+}
+
+func (w *closeRecorder) Close() error {
+	w.closed = true
+	return nil
+}
+
+// fixedWriterHelper returns w from NewWriterContext.
+type fixedWriterHelper struct {
+	*MemFileHelper
+	w io.WriteCloser
+}
+
+func (h *fixedWriterHelper) NewWriterContext(ctx context.Context, uri string) (io.WriteCloser, error) {
+	return h.w, nil
+}
@@ -5,4 +5,6 @@ const (
 	GCSScheme = "gs"
 	// FileScheme is the scheme for local files
 	FileScheme = "file"
+	// MemScheme is the scheme for the in memory filesystem
+	MemScheme = "mem"
 )
@@ -0,0 +1,263 @@
+package files
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"io"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-logr/zapr"
+	"github.com/jlewi/monogo/gcp/gcs"
+	"github.com/jlewi/monogo/helpers"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// Copy copies src to dst. src and dst can use any schemes supported by Factory e.g. a local file can be copied
+// to GCS. The data is streamed so files aren't buffered in memory. If src and dst are both in GCS the copy
+// is done server side.
+func Copy(ctx context.Context, src string, dst string) error {
+	f := &Factory{}
+	defer helpers.DeferIgnoreError(f.Close)
+	srcHelper, err := f.GetContext(ctx, src)
+	if err != nil {
+		return err
+	}
+	dstHelper, err := f.GetContext(ctx, dst)
+	if err != nil {
+		return err
+	}
+	return copyWithHelpers(ctx, srcHelper, src, dstHelper, dst)
+}
+
+// Move moves src to dst. It is a Copy followed by deleting src, so src must use a scheme with a DirectoryHelper.
+// It is an error for src and dst to refer to the same file since deleting src would delete the only copy.
+func Move(ctx context.Context, src string, dst string) error {
+	same, err := isSameFile(src, dst)
+	if err != nil {
+		return err
+	}
+	if same {
+		return errors.Errorf("Can't move %v to %v; they are the same file", src, dst)
+	}
+	f := &Factory{}
+	defer helpers.DeferIgnoreError(f.Close)
+	srcHelper, err := f.GetDirHelperContext(ctx, src)
+	if err != nil {
+		return errors.Wrapf(err, "Move requires a DirectoryHelper for the source %v", src)
+	}
+	dstHelper, err := f.GetContext(ctx, dst)
+	if err != nil {
+		return err
+	}
+	if err := copyWithHelpers(ctx, srcHelper, src, dstHelper, dst); err != nil {
+		return err
+	}
+	return srcHelper.Delete(ctx, src)
+}
+
+// SyncResult reports what Sync did.
+type SyncResult struct {
+	// Copied is the list of destination files that were written.
+	Copied []string
+	// Skipped is the list of destination files that were already up to date.
+	Skipped []string
+}
+
+// Sync copies all the files in srcDir, including those in subdirectories, to dstDir. Files which already
+// exist in dstDir with the same size and MD5 hash are skipped. Files in dstDir which aren't in srcDir are
+// left alone.
+func Sync(ctx context.Context, srcDir string, dstDir string) (*SyncResult, error) {
+	log := zapr.NewLogger(zap.L())
+	f := &Factory{}
+	defer helpers.DeferIgnoreError(f.Close)
+	srcHelper, err := f.GetDirHelperContext(ctx, srcDir)
+	if err != nil {
+		return nil, err
+	}
+	dstHelper, err := f.GetDirHelperContext(ctx, dstDir)
+	if err != nil {
+		return nil, err
+	}
+
+	srcFiles, err := srcHelper.List(ctx, srcDir)
+	if err != nil {
+		return nil, err
+	}
+
+	root, err := dirRoot(srcDir)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &SyncResult{
+		Copied:  []string{},
+		Skipped: []string{},
+	}
+	for _, srcInfo := range srcFiles {
+		if !strings.HasPrefix(srcInfo.URI, root) {
+			return result, errors.Errorf("Listing %v returned %v which isn't inside %v", srcDir, srcInfo.URI, root)
+		}
+		rel := strings.TrimPrefix(strings.TrimPrefix(srcInfo.URI, root), "/")
+		dst := dstHelper.Join(dstDir, rel)
+
+		unchanged, err := isUnchanged(ctx, srcHelper, srcInfo, dstHelper, dst)
+		if err != nil {
+			return result, err
+		}
+		if unchanged {
+			log.V(1).Info("Skipping unchanged file", "src", srcInfo.URI, "dst", dst)
+			result.Skipped = append(result.Skipped, dst)
+			continue
+		}
+
+		log.Info("Copying file", "src", srcInfo.URI, "dst", dst)
+		if err := copyWithHelpers(ctx, srcHelper, srcInfo.URI, dstHelper, dst); err != nil {
+			return result, err
+		}
+		result.Copied = append(result.Copied, dst)
+	}
+	return result, nil
+}
+
+func copyWithHelpers(ctx context.Context, srcHelper FileHelper, src string, dstHelper FileHelper, dst string) error {
+	if srcGcs, ok := srcHelper.(*gcs.GcsHelper); ok {
+		if _, ok := dstHelper.(*gcs.GcsHelper); ok {
+			return srcGcs.Copy(ctx, src, dst)
+		}
+	}
+
+	r, err := srcHelper.NewReaderContext(ctx, src)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	// Use a separate context for the writer so we can abort the write if the copy fails.
+	// Writers such as GCS commit the data on Close so we need to make sure a partial copy isn't committed.
+	wCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	w, err := dstHelper.NewWriterContext(wCtx, dst)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(w, r); err != nil {
+		cancel()
+		helpers.IgnoreError(abortOrClose(w))
+		return errors.Wrapf(err, "Failed to copy %v to %v", src, dst)
+	}
+
+	if err := w.Close(); err != nil {
+		return errors.Wrapf(err, "Failed to close %v", dst)
+	}
+	return nil
+}
+
+// isSameFile returns true if src and dst are different spellings of the same URI e.g. a/../b and b.
+func isSameFile(src string, dst string) (bool, error) {
+	srcKey, err := normalizeURI(src)
+	if err != nil {
+		return false, err
+	}
+	dstKey, err := normalizeURI(dst)
+	if err != nil {
+		return false, err
+	}
+	return srcKey == dstKey, nil
+}
+
+// normalizeURI returns a canonical form of uri so that equivalent URIs can be compared.
+func normalizeURI(uri string) (string, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", errors.Wrapf(err, "Failed to parse URI %v", uri)
+	}
+	switch u.Scheme {
+	case "", FileScheme:
+		p, err := filepath.Abs(strings.TrimPrefix(uri, FileScheme+"://"))
+		if err != nil {
+			return "", errors.Wrapf(err, "Failed to get the absolute path of %v", uri)
+		}
+		return p, nil
+	case GCSScheme:
+		p, err := gcs.Parse(uri)
+		if err != nil {
+			return "", err
+		}
+		return p.ToURI(), nil
+	case MemScheme:
+		return memKey(uri), nil
+	default:
+		return uri, nil
+	}
+}
+
+// isUnchanged returns true if dst exists and has the same contents as src.
+func isUnchanged(ctx context.Context, srcHelper DirectoryHelper, srcInfo *FileInfo, dstHelper DirectoryHelper, dst string) (bool, error) {
+	exists, err := dstHelper.ExistsContext(ctx, dst)
+	if err != nil {
+		return false, err
+	}
+	if !exists {
+		return false, nil
+	}
+	dstInfo, err := dstHelper.Stat(ctx, dst)
+	if err != nil {
+		return false, err
+	}
+	if srcInfo.Size != dstInfo.Size {
+		return false, nil
+	}
+
+	srcHash, err := fileMD5(ctx, srcHelper, srcInfo)
+	if err != nil {
+		return false, err
+	}
+	dstHash, err := fileMD5(ctx, dstHelper, dstInfo)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(srcHash, dstHash), nil
+}
+
+// fileMD5 returns the MD5 of the file. If the backend doesn't store it, the file is read to compute it.
+func fileMD5(ctx context.Context, h FileHelper, info *FileInfo) ([]byte, error) {
+	if len(info.MD5) > 0 {
+		return info.MD5, nil
+	}
+	r, err := h.NewReaderContext(ctx, info.URI)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	hash := md5.New()
+	if _, err := io.Copy(hash, r); err != nil {
+		return nil, errors.Wrapf(err, "Failed to compute the MD5 of %v", info.URI)
+	}
+	return hash.Sum(nil), nil
+}
+
+// dirRoot returns the prefix that the URIs returned by DirectoryHelper.List(dir) will have.
+func dirRoot(dir string) (string, error) {
+	u, err := url.Parse(dir)
+	if err != nil {
+		return "", errors.Wrapf(err, "Failed to parse URI %v", dir)
+	}
+	switch u.Scheme {
+	case "", FileScheme:
+		root := filepath.Clean(strings.TrimPrefix(dir, FileScheme+"://"))
+		if root == "." {
+			// filepath.WalkDir doesn't prefix paths with ./
+			return "", nil
+		}
+		return root, nil
+	case MemScheme:
+		return memKey(dir), nil
+	default:
+		return strings.TrimSuffix(dir, "/"), nil
+	}
+}
@@ -0,0 +1,183 @@
+package files
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func writeMemFile(t *testing.T, uri string, contents string) {
+	t.Helper()
+	writeFile(t, DefaultMemFileHelper, uri, contents)
+}
+
+func Test_CopyAndMove(t *testing.T) {
+	defer DefaultMemFileHelper.Reset()
+	tDir, err := os.MkdirTemp("", "testCopy")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(tDir)
+
+	ctx := context.Background()
+	writeMemFile(t, "mem://copy/src.txt", "hello")
+
+	local := filepath.Join(tDir, "sub", "dst.txt")
+	if err := Copy(ctx, "mem://copy/src.txt", local); err != nil {
+		t.Fatalf("Copy() error: %v", err)
+	}
+	actual, err := os.ReadFile(local)
+	if err != nil {
+		t.Fatalf("ReadFile() error: %v", err)
+	}
+	if string(actual) != "hello" {
+		t.Errorf("Copy() wrote %v; want hello", string(actual))
+	}
+
+	if err := Move(ctx, local, "mem://copy/moved.txt"); err != nil {
+		t.Fatalf("Move() error: %v", err)
+	}
+	if _, err := os.Stat(local); !os.IsNotExist(err) {
+		t.Errorf("Move() didn't delete the source %v", local)
+	}
+	moved, err := Read("mem://copy/moved.txt")
+	if err != nil {
+		t.Fatalf("Read() error: %v", err)
+	}
+	if string(moved) != "hello" {
+		t.Errorf("Move() wrote %v; want hello", string(moved))
+	}
+
+	// Moving a file onto itself must not delete it.
+	for _, dst := range []string{"mem://copy/moved.txt", "mem://copy/sub/../moved.txt"} {
+		if err := Move(ctx, "mem://copy/moved.txt", dst); err == nil {
+			t.Errorf("Move() to %v should fail", dst)
+		}
+	}
+	if _, err := Read("mem://copy/moved.txt"); err != nil {
+		t.Errorf("Move() onto itself deleted the source; error: %v", err)
+	}
+}
+
+func Test_IsSameFile(t *testing.T) {
+	type testCase struct {
+		src      string
+		dst      string
+		expected bool
+	}
+	cases := []testCase{
+		{src: "/tmp/a.txt", dst: "/tmp/a.txt", expected: true},
+		{src: "/tmp/a.txt", dst: "file:///tmp/sub/../a.txt", expected: true},
+		{src: "/tmp/a.txt", dst: "/tmp/b.txt", expected: false},
+		{src: "gs://bucket/a.txt", dst: "gs://bucket/a.txt", expected: true},
+		{src: "gs://bucket/a.txt", dst: "gs://other/a.txt", expected: false},
+		{src: "mem://a/b.txt", dst: "mem://a//b.txt", expected: true},
+	}
+	for _, c := range cases {
+		actual, err := isSameFile(c.src, c.dst)
+		if err != nil {
+			t.Errorf("isSameFile(%v, %v) error: %v", c.src, c.dst, err)
+			continue
+		}
+		if actual != c.expected {
+			t.Errorf("isSameFile(%v, %v) got %v; want %v", c.src, c.dst, actual, c.expected)
+		}
+	}
+}
+
+func Test_Sync(t *testing.T) {
+	defer DefaultMemFileHelper.Reset()
+	tDir, err := os.MkdirTemp("", "testSync")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(tDir)
+
+	for p, contents := range map[string]string{"a.txt": "a", "sub/b.txt": "b", "sub/c.txt": "c"} {
+		full := filepath.Join(tDir, p)
+		if err := os.MkdirAll(filepath.Dir(full), 0700); err != nil {
+			t.Fatalf("MkdirAll() error: %v", err)
+		}
+		if err := os.WriteFile(full, []byte(contents), 0600); err != nil {
+			t.Fatalf("WriteFile() error: %v", err)
+		}
+	}
+
+	// b.txt is up to date and c.txt has the same size but different contents.
+	writeMemFile(t, "mem://sync/dst/sub/b.txt", "b")
+	writeMemFile(t, "mem://sync/dst/sub/c.txt", "x")
+
+	result, err := Sync(context.Background(), tDir, "mem://sync/dst")
+	if err != nil {
+		t.Fatalf("Sync() error: %v", err)
+	}
+
+	expected := &SyncResult{
+		Copied:  []string{"mem://sync/dst/a.txt", "mem://sync/dst/sub/c.txt"},
+		Skipped: []string{"mem://sync/dst/sub/b.txt"},
+	}
+	if d := cmp.Diff(expected, result); d != "" {
+		t.Errorf("Sync() mismatch (-want +got):\n%s", d)
+	}
+
+	c, err := Read("mem://sync/dst/sub/c.txt")
+	if err != nil {
+		t.Fatalf("Read() error: %v", err)
+	}
+	if string(c) != "c" {
+		t.Errorf("Sync() didn't update c.txt; got %v", string(c))
+	}
+}
+
+func Test_CopyToFileURI(t *testing.T) {
+	defer DefaultMemFileHelper.Reset()
+	tDir, err := os.MkdirTemp("", "testCopyFileURI")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(tDir)
+
+	ctx := context.Background()
+	writeMemFile(t, "mem://fileuri/src/a.txt", "a")
+	writeMemFile(t, "mem://fileuri/src/sub/b.txt", "b")
+
+	if err := Copy(ctx, "mem://fileuri/src/a.txt", "file://"+filepath.Join(tDir, "copy", "a.txt")); err != nil {
+		t.Fatalf("Copy() error: %v", err)
+	}
+
+	dst := "file://" + filepath.Join(tDir, "sync")
+	result, err := Sync(ctx, "mem://fileuri/src", dst)
+	if err != nil {
+		t.Fatalf("Sync() error: %v", err)
+	}
+	expected := &SyncResult{
+		Copied:  []string{dst + "/a.txt", dst + "/sub/b.txt"},
+		Skipped: []string{},
+	}
+	if d := cmp.Diff(expected, result); d != "" {
+		t.Errorf("Sync() mismatch (-want +got):\n%s", d)
+	}
+
+	for p, want := range map[string]string{"copy/a.txt": "a", "sync/a.txt": "a", "sync/sub/b.txt": "b"} {
+		actual, err := os.ReadFile(filepath.Join(tDir, p))
+		if err != nil {
+			t.Errorf("ReadFile(%v) error: %v", p, err)
+			continue
+		}
+		if string(actual) != want {
+			t.Errorf("%v got %v; want %v", p, string(actual), want)
+		}
+	}
+
+	// Syncing again skips the files since they are unchanged.
+	result, err = Sync(ctx, "mem://fileuri/src", dst)
+	if err != nil {
+		t.Fatalf("Sync() error: %v", err)
+	}
+	if d := cmp.Diff([]string{dst + "/a.txt", dst + "/sub/b.txt"}, result.Skipped); d != "" {
+		t.Errorf("Sync() skipped mismatch (-want +got):\n%s", d)
+	}
+}
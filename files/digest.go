@@ -0,0 +1,156 @@
+package files
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"hash/crc32"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Digest is the digest of a file's contents.
+type Digest struct {
+	SHA256 []byte
+	// CRC32C uses the Castagnoli polynomial; the checksum GCS stores for every object.
+	CRC32C uint32
+	Size   int64
+}
+
+// SHA256Hex returns the SHA-256 as a hex string e.g. as printed by sha256sum.
+func (d Digest) SHA256Hex() string {
+	return hex.EncodeToString(d.SHA256)
+}
+
+// verify returns an error if the digest doesn't match expected. Fields of expected that are zero aren't checked.
+func (d Digest) verify(expected Digest) error {
+	if expected.Size != 0 && d.Size != expected.Size {
+		return errors.Errorf("Size mismatch; got %v bytes want %v", d.Size, expected.Size)
+	}
+	if len(expected.SHA256) != 0 && !bytes.Equal(d.SHA256, expected.SHA256) {
+		return errors.Errorf("SHA-256 mismatch; got %v want %v", d.SHA256Hex(), expected.SHA256Hex())
+	}
+	if expected.CRC32C != 0 && d.CRC32C != expected.CRC32C {
+		return errors.Errorf("CRC32C mismatch; got %08x want %08x", d.CRC32C, expected.CRC32C)
+	}
+	return nil
+}
+
+// digester computes a Digest incrementally.
+type digester struct {
+	sha  hash.Hash
+	crc  hash.Hash32
+	size int64
+}
+
+func newDigester() *digester {
+	return &digester{
+		sha: sha256.New(),
+		crc: crc32.New(crc32cTable),
+	}
+}
+
+func (d *digester) update(p []byte) {
+	// Writing to a hash never returns an error.
+	d.sha.Write(p)
+	d.crc.Write(p)
+	d.size += int64(len(p))
+}
+
+func (d *digester) digest() Digest {
+	return Digest{
+		SHA256: d.sha.Sum(nil),
+		CRC32C: d.crc.Sum32(),
+		Size:   d.size,
+	}
+}
+
+// DigestReader computes the digest of the data as it is read so callers don't need a second pass over the data.
+// Wrap the reader returned by a FileHelper; if the helper decompresses the data, e.g. because TransparentCompression
+// is enabled, the digest is of the decompressed data.
+type DigestReader struct {
+	r io.ReadCloser
+	d *digester
+	// Expected, if set, is checked when the end of the data is reached; if the digest doesn't match Read returns
+	// an error instead of io.EOF so corrupted data isn't mistaken for complete data. Fields that are zero
+	// aren't checked.
+	Expected *Digest
+}
+
+// NewDigestReader creates a new DigestReader.
+func NewDigestReader(r io.ReadCloser) *DigestReader {
+	return &DigestReader{r: r, d: newDigester()}
+}
+
+func (r *DigestReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.d.update(p[:n])
+	if err == io.EOF && r.Expected != nil {
+		if vErr := r.d.digest().verify(*r.Expected); vErr != nil {
+			return n, errors.Wrapf(vErr, "Data failed verification")
+		}
+	}
+	return n, err
+}
+
+// Close closes the underlying reader.
+func (r *DigestReader) Close() error {
+	return r.r.Close()
+}
+
+// Digest returns the digest of the data read so far.
+func (r *DigestReader) Digest() Digest {
+	return r.d.digest()
+}
+
+// DigestWriter computes the digest of the data as it is written. It implements Aborter if the underlying writer
+// does.
+type DigestWriter interface {
+	io.WriteCloser
+	// Digest returns the digest of the data written so far. Callers should check that Close succeeded before
+	// relying on it.
+	Digest() Digest
+}
+
+// NewDigestWriter creates a new DigestWriter.
+func NewDigestWriter(w io.WriteCloser) DigestWriter {
+	dw := &digestWriter{w: w, d: newDigester()}
+	if _, ok := w.(Aborter); ok {
+		return &abortingDigestWriter{digestWriter: dw}
+	}
+	return dw
+}
+
+type digestWriter struct {
+	w io.WriteCloser
+	d *digester
+}
+
+func (w *digestWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.d.update(p[:n])
+	return n, err
+}
+
+// Close closes the underlying writer.
+func (w *digestWriter) Close() error {
+	return w.w.Close()
+}
+
+func (w *digestWriter) Digest() Digest {
+	return w.d.digest()
+}
+
+// abortingDigestWriter is a digestWriter whose underlying writer implements Aborter.
+type abortingDigestWriter struct {
+	*digestWriter
+}
+
+// Abort discards the data; see Aborter.
+func (w *abortingDigestWriter) Abort() error {
+	return Abort(w.w)
+}
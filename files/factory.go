@@ -3,66 +3,143 @@ package files
 import (
 	"context"
 	"net/url"
+	"sync"
 
 	"cloud.google.com/go/storage"
 	"github.com/jlewi/monogo/gcp/gcs"
 	"github.com/pkg/errors"
+	"golang.org/x/oauth2"
 )
 
-// Factory returns the correct filehelper based on a files scheme
-type Factory struct{}
+// Factory returns the correct filehelper based on a files scheme.
+//
+// The schemes that are supported are determined by the registry; see RegisterFileHelper and
+// RegisterDirectoryHelper.
+type Factory struct {
+	// TransparentCompression, if true, makes the helpers decompress files when reading them and compress them
+	// when writing them if the file name ends with the extension of a registered codec e.g. .gz or .zst; see
+	// RegisterCodec.
+	TransparentCompression bool
+	// Cache, if set, makes reads go through the cache; see Cache. It applies to every scheme. When
+	// TransparentCompression is also enabled the cache stores the compressed data.
+	Cache *Cache
+	// HTTPTokenSource, if set, is used to authorize requests for http(s) URIs; see HTTPFileHelper.
+	HTTPTokenSource oauth2.TokenSource
+	// HTTPAllowInsecureTokens, if true, allows HTTPTokenSource to be used for http:// URIs; see
+	// HTTPFileHelper.AllowInsecureTokens.
+	HTTPAllowInsecureTokens bool
 
+	mu sync.Mutex
+	// storageClient is shared by the GCS helpers created by the factory; it is closed by Close.
+	storageClient *storage.Client
+}
+
+// Get returns the correct FileHelper based on a files scheme. See GetContext.
 func (f *Factory) Get(uri string) (FileHelper, error) {
+	return f.GetContext(context.Background(), uri)
+}
+
+// GetContext returns the correct FileHelper based on a files scheme. Helpers which need a context for methods
+// that don't take one, e.g. GcsHelper, use ctx. Close should be called once the helpers are no longer needed.
+func (f *Factory) GetContext(ctx context.Context, uri string) (FileHelper, error) {
 	u, err := url.Parse(uri)
 	if err != nil {
 		return nil, errors.Wrapf(err, "Failed to parse URI %v", uri)
 	}
 
-	switch u.Scheme {
-	case "":
-		return &LocalFileHelper{}, nil
-	case GCSScheme:
-		ctx := context.Background()
-		client, err := storage.NewClient(ctx)
-		if err != nil {
-			return nil, errors.Wrapf(err, "Failed to create GCS storage client")
-		}
-		return &gcs.GcsHelper{
-			Ctx:    ctx,
-			Client: client,
-		}, nil
-	case FileScheme:
-		return &LocalFileHelper{}, nil
-	case SecretManagerScheme:
-		return &GCPSecretManager{}, nil
-	default:
+	factory, ok := lookupFileHelper(u.Scheme)
+	if !ok {
 		return nil, errors.Errorf("Scheme %v is not supported", u.Scheme)
 	}
+	h, err := factory(ctx, f, u)
+	if err != nil {
+		return nil, err
+	}
+	if f.Cache != nil {
+		h = f.Cache.Wrap(h)
+	}
+	if f.TransparentCompression {
+		h = &compressingHelper{h: h}
+	}
+	return h, nil
 }
 
 // GetDirHelper returns the correct DirectoryHelper based on a files scheme
 func (f *Factory) GetDirHelper(uri string) (DirectoryHelper, error) {
+	return f.GetDirHelperContext(context.Background(), uri)
+}
+
+// GetDirHelperContext returns the correct DirectoryHelper based on a files scheme. See GetContext.
+func (f *Factory) GetDirHelperContext(ctx context.Context, uri string) (DirectoryHelper, error) {
 	u, err := url.Parse(uri)
 	if err != nil {
 		return nil, errors.Wrapf(err, "Failed to parse URI %v", uri)
 	}
 
-	switch u.Scheme {
-	case "":
-		return &LocalFileHelper{}, nil
-	case GCSScheme:
-		ctx := context.Background()
-		client, err := storage.NewClient(ctx)
+	factory, ok := lookupDirectoryHelper(u.Scheme)
+	if !ok {
+		return nil, errors.Errorf("Scheme %v is not supported", u.Scheme)
+	}
+	h, err := factory(ctx, f, u)
+	if err != nil {
+		return nil, err
+	}
+	if f.Cache != nil {
+		h = f.Cache.WrapDir(h)
+	}
+	if f.TransparentCompression {
+		h = &compressingDirHelper{DirectoryHelper: h}
+	}
+	return h, nil
+}
+
+func newLocalFileHelper(ctx context.Context, f *Factory, u *url.URL) (DirectoryHelper, error) {
+	return &LocalFileHelper{}, nil
+}
+
+func newGcsHelper(ctx context.Context, f *Factory, u *url.URL) (DirectoryHelper, error) {
+	client, err := f.gcsClient()
+	if err != nil {
+		return nil, err
+	}
+	return &gcs.GcsHelper{
+		Ctx:    ctx,
+		Client: client,
+	}, nil
+}
+
+// gcsClient returns the storage client shared by the GCS helpers, creating it if necessary. It isn't created
+// with the caller's context because the client outlives it; e.g. the context is used to refresh tokens.
+func (f *Factory) gcsClient() (*storage.Client, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.storageClient == nil {
+		client, err := storage.NewClient(context.Background())
 		if err != nil {
 			return nil, errors.Wrapf(err, "Failed to create GCS storage client")
 		}
-		return &gcs.GcsHelper{
-			Ctx:    ctx,
-			Client: client,
-		}, nil
-	case FileScheme:
-		return &LocalFileHelper{}, nil
-	default:
-		return nil, errors.Errorf("Scheme %v is not supported", u.Scheme)
+		f.storageClient = client
+	}
+	return f.storageClient, nil
+}
+
+// Close releases the resources, e.g. the GCS storage client, shared by the helpers created by the factory.
+// The helpers can't be used after the factory is closed.
+func (f *Factory) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.storageClient == nil {
+		return nil
 	}
+	err := f.storageClient.Close()
+	f.storageClient = nil
+	return errors.Wrapf(err, "Failed to close GCS storage client")
+}
+
+func newMemFileHelper(ctx context.Context, f *Factory, u *url.URL) (DirectoryHelper, error) {
+	return DefaultMemFileHelper, nil
+}
+
+func newGCPSecretManager(ctx context.Context, f *Factory, u *url.URL) (DirectoryHelper, error) {
+	return &GCPSecretManager{}, nil
 }
@@ -0,0 +1,6 @@
+package files
+
+import "github.com/jlewi/monogo/helpers"
+
+// FileInfo describes a file. It is returned by DirectoryHelper.Stat and DirectoryHelper.List.
+type FileInfo = helpers.FileInfo
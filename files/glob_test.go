@@ -0,0 +1,111 @@
+package files
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/jlewi/monogo/gcp/gcs"
+	"github.com/jlewi/monogo/gcp/gcs/gcstest"
+)
+
+// globBackend is a DirectoryHelper under test along with the root in which the test files are created.
+type globBackend struct {
+	name string
+	h    DirectoryHelper
+	root string
+}
+
+func newGlobBackends(t *testing.T) []globBackend {
+	t.Helper()
+	tDir, err := os.MkdirTemp("", "testGlob")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(tDir) })
+
+	srv := gcstest.NewServer()
+	t.Cleanup(srv.Close)
+	srv.CreateBucket("bucket")
+	ctx := context.Background()
+	client, err := srv.Client(ctx)
+	if err != nil {
+		t.Fatalf("Failed to create GCS client; error: %v", err)
+	}
+
+	return []globBackend{
+		{name: "local", h: &LocalFileHelper{ExcludeDirs: true}, root: filepath.ToSlash(tDir) + "/"},
+		{name: "mem", h: NewMemFileHelper(), root: "mem://bucket/"},
+		{name: "gcs", h: &gcs.GcsHelper{Ctx: ctx, Client: client}, root: "gs://bucket/"},
+	}
+}
+
+// Test_GlobConformance verifies that every DirectoryHelper for files interprets glob patterns the same way.
+func Test_GlobConformance(t *testing.T) {
+	files := []string{
+		"a.txt",
+		"b.csv",
+		"dir/a.txt",
+		"dir/b.txt",
+		"dir/file-1.txt",
+		"dir/file-22.txt",
+		"dir/sub/c.txt",
+		"dir/sub/deeper/d.txt",
+		"dir2/e.txt",
+		"star*.txt",
+	}
+
+	type testCase struct {
+		pattern  string
+		expected []string
+	}
+
+	cases := []testCase{
+		{pattern: "*.txt", expected: []string{"a.txt", "star*.txt"}},
+		{pattern: "dir/*.txt", expected: []string{"dir/a.txt", "dir/b.txt", "dir/file-1.txt", "dir/file-22.txt"}},
+		{pattern: "dir/*", expected: []string{"dir/a.txt", "dir/b.txt", "dir/file-1.txt", "dir/file-22.txt"}},
+		{pattern: "dir/file-?.txt", expected: []string{"dir/file-1.txt"}},
+		{pattern: "dir/[ab].txt", expected: []string{"dir/a.txt", "dir/b.txt"}},
+		{pattern: "dir/[!a].txt", expected: []string{"dir/b.txt"}},
+		{pattern: "dir*/{a,e}.txt", expected: []string{"dir/a.txt", "dir2/e.txt"}},
+		{pattern: "dir/**", expected: []string{"dir/a.txt", "dir/b.txt", "dir/file-1.txt", "dir/file-22.txt", "dir/sub/c.txt", "dir/sub/deeper/d.txt"}},
+		{pattern: "dir/**/*.txt", expected: []string{"dir/a.txt", "dir/b.txt", "dir/file-1.txt", "dir/file-22.txt", "dir/sub/c.txt", "dir/sub/deeper/d.txt"}},
+		{pattern: "**/c.txt", expected: []string{"dir/sub/c.txt"}},
+		{pattern: "dir/*/c.txt", expected: []string{"dir/sub/c.txt"}},
+		{pattern: `star\*.txt`, expected: []string{"star*.txt"}},
+		{pattern: "dir/a.txt", expected: []string{"dir/a.txt"}},
+		{pattern: "dir/sub", expected: []string{}},
+		{pattern: "missing/*.txt", expected: []string{}},
+	}
+
+	for _, b := range newGlobBackends(t) {
+		t.Run(b.name, func(t *testing.T) {
+			for _, f := range files {
+				writeFile(t, b.h, b.root+f, f)
+			}
+
+			for _, c := range cases {
+				t.Run(c.pattern, func(t *testing.T) {
+					matches, err := b.h.Glob(b.root + c.pattern)
+					if err != nil {
+						t.Fatalf("Glob(%v) error: %v", c.pattern, err)
+					}
+					actual := []string{}
+					for _, m := range matches {
+						actual = append(actual, strings.TrimPrefix(filepath.ToSlash(m), b.root))
+					}
+					if d := cmp.Diff(c.expected, actual); d != "" {
+						t.Errorf("Unexpected matches; diff:\n%v", d)
+					}
+				})
+			}
+
+			if _, err := b.h.Glob(b.root + "dir/[ab.txt"); err == nil {
+				t.Errorf("Glob should fail for an invalid pattern")
+			}
+		})
+	}
+}
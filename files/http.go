@@ -0,0 +1,253 @@
+package files
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jlewi/monogo/helpers"
+	"github.com/pkg/errors"
+	"golang.org/x/oauth2"
+)
+
+const (
+	// HTTPScheme is the scheme for files served over HTTP
+	HTTPScheme = "http"
+	// HTTPSScheme is the scheme for files served over HTTPS
+	HTTPSScheme = "https"
+)
+
+// ErrNotModified is returned by HTTPFileHelper.NewReaderWithOptions when the preconditions show the client's copy
+// is current.
+var ErrNotModified = errors.New("not modified")
+
+// HTTPFileHelper is a read-only FileHelper for files served over http(s) e.g. config files on internal web
+// servers. It implements Stat using a HEAD request so reads can be cached; see Cache.
+type HTTPFileHelper struct {
+	// Client is used to make the requests. Defaults to http.DefaultClient.
+	Client *http.Client
+	// TokenSource, if set, is used to add a bearer token to every request; e.g. a token source obtained from
+	// gcp.CredentialHelper or, for endpoints protected by IAP, an oauthutil.IDTokenSource. So that tokens aren't
+	// sent in the clear, requests for http:// URIs fail unless AllowInsecureTokens is true.
+	TokenSource oauth2.TokenSource
+	// AllowInsecureTokens, if true, allows the token to be sent for http:// URIs e.g. to a server on localhost.
+	AllowInsecureTokens bool
+}
+
+// HTTPReadOptions control NewReaderWithOptions.
+type HTTPReadOptions struct {
+	// IfNoneMatch, if set, makes the request conditional on the etag of the file not matching; if it matches
+	// ErrNotModified is returned.
+	IfNoneMatch string
+	// IfModifiedSince, if set, makes the request conditional on the file having been modified after this time;
+	// if it hasn't ErrNotModified is returned.
+	IfModifiedSince time.Time
+	// Offset is the byte offset at which to start reading.
+	Offset int64
+	// Length is the number of bytes to read; if it is <= 0 the file is read to the end.
+	Length int64
+}
+
+func (h *HTTPFileHelper) client() *http.Client {
+	if h.Client == nil {
+		return http.DefaultClient
+	}
+	return h.Client
+}
+
+// do sends a request for uri adding the bearer token if there is one.
+func (h *HTTPFileHelper) do(ctx context.Context, method string, uri string, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, uri, nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to create %v request for %v", method, uri)
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if h.TokenSource != nil {
+		if req.URL.Scheme != HTTPSScheme && !h.AllowInsecureTokens {
+			return nil, errors.Errorf("Refusing to send a token to %v; it doesn't use https. Set AllowInsecureTokens to allow it", uri)
+		}
+		tok, err := h.TokenSource.Token()
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to get a token to access %v", uri)
+		}
+		tok.SetAuthHeader(req)
+	}
+	resp, err := h.client().Do(req)
+	if err != nil {
+		return nil, errors.Wrapf(err, "%v %v failed", method, uri)
+	}
+	return resp, nil
+}
+
+// NewReader creates a new reader for the file.
+func (h *HTTPFileHelper) NewReader(uri string) (io.ReadCloser, error) {
+	return h.NewReaderContext(context.Background(), uri)
+}
+
+// NewReaderContext creates a new reader for the file.
+func (h *HTTPFileHelper) NewReaderContext(ctx context.Context, uri string) (io.ReadCloser, error) {
+	r, _, err := h.NewReaderWithOptions(ctx, uri, nil)
+	return r, err
+}
+
+// NewRangeReader returns a reader for length bytes of the file starting at offset. If length is <= 0 the file
+// is read to the end.
+func (h *HTTPFileHelper) NewRangeReader(ctx context.Context, uri string, offset int64, length int64) (io.ReadCloser, error) {
+	r, _, err := h.NewReaderWithOptions(ctx, uri, &HTTPReadOptions{Offset: offset, Length: length})
+	return r, err
+}
+
+// NewReaderWithOptions creates a new reader for the file and returns information about it from the response
+// headers. If the preconditions in opts show the caller's copy is current, an error wrapping ErrNotModified is
+// returned. Range reads use a Range header; if the server ignores it the data before the offset is discarded.
+func (h *HTTPFileHelper) NewReaderWithOptions(ctx context.Context, uri string, opts *HTTPReadOptions) (io.ReadCloser, *FileInfo, error) {
+	if opts == nil {
+		opts = &HTTPReadOptions{}
+	}
+	if opts.Offset < 0 {
+		return nil, nil, errors.Errorf("Invalid offset %v for %v; it must be >= 0", opts.Offset, uri)
+	}
+	header := http.Header{}
+	if opts.IfNoneMatch != "" {
+		header.Set("If-None-Match", opts.IfNoneMatch)
+	}
+	if !opts.IfModifiedSince.IsZero() {
+		header.Set("If-Modified-Since", opts.IfModifiedSince.UTC().Format(http.TimeFormat))
+	}
+	ranged := opts.Offset > 0 || opts.Length > 0
+	if ranged {
+		if opts.Length > 0 {
+			header.Set("Range", fmt.Sprintf("bytes=%d-%d", opts.Offset, opts.Offset+opts.Length-1))
+		} else {
+			header.Set("Range", fmt.Sprintf("bytes=%d-", opts.Offset))
+		}
+	}
+
+	resp, err := h.do(ctx, http.MethodGet, uri, header)
+	if err != nil {
+		return nil, nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		if !ranged {
+			return resp.Body, responseInfo(uri, resp), nil
+		}
+		// The server doesn't support range requests.
+		if _, err := io.CopyN(io.Discard, resp.Body, opts.Offset); err != nil && err != io.EOF {
+			helpers.IgnoreError(resp.Body.Close())
+			return nil, nil, errors.Wrapf(err, "Failed to read %v", uri)
+		}
+		var r io.Reader = resp.Body
+		if opts.Length > 0 {
+			r = io.LimitReader(resp.Body, opts.Length)
+		}
+		return &readCloser{Reader: r, Closer: resp.Body}, responseInfo(uri, resp), nil
+	case http.StatusPartialContent:
+		return resp.Body, responseInfo(uri, resp), nil
+	case http.StatusNotModified:
+		helpers.IgnoreError(resp.Body.Close())
+		return nil, responseInfo(uri, resp), errors.Wrapf(ErrNotModified, "%v", uri)
+	default:
+		return nil, nil, statusError(http.MethodGet, uri, resp)
+	}
+}
+
+// readCloser combines a Reader with the Closer of the underlying stream.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// NewWriter returns an error; HTTPFileHelper is read-only.
+func (h *HTTPFileHelper) NewWriter(uri string) (io.WriteCloser, error) {
+	return h.NewWriterContext(context.Background(), uri)
+}
+
+// NewWriterContext returns an error; HTTPFileHelper is read-only.
+func (h *HTTPFileHelper) NewWriterContext(ctx context.Context, uri string) (io.WriteCloser, error) {
+	return nil, errors.Errorf("Can't write %v; http(s) URIs are read-only", uri)
+}
+
+// Exists checks whether the file exists.
+func (h *HTTPFileHelper) Exists(uri string) (bool, error) {
+	return h.ExistsContext(context.Background(), uri)
+}
+
+// ExistsContext checks whether the file exists using a HEAD request. A 404 isn't an error.
+func (h *HTTPFileHelper) ExistsContext(ctx context.Context, uri string) (bool, error) {
+	resp, err := h.do(ctx, http.MethodHead, uri, nil)
+	if err != nil {
+		return false, err
+	}
+	helpers.IgnoreError(resp.Body.Close())
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return false, nil
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return true, nil
+	default:
+		return false, statusError(http.MethodHead, uri, resp)
+	}
+}
+
+// Stat returns information about the file using a HEAD request. Size is -1 if the server doesn't report it.
+func (h *HTTPFileHelper) Stat(ctx context.Context, uri string) (*FileInfo, error) {
+	resp, err := h.do(ctx, http.MethodHead, uri, nil)
+	if err != nil {
+		return nil, err
+	}
+	helpers.IgnoreError(resp.Body.Close())
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, statusError(http.MethodHead, uri, resp)
+	}
+	return responseInfo(uri, resp), nil
+}
+
+// responseInfo builds a FileInfo from the headers of the response.
+func responseInfo(uri string, resp *http.Response) *FileInfo {
+	info := &FileInfo{
+		URI:  uri,
+		Size: resp.ContentLength,
+		Etag: resp.Header.Get("Etag"),
+	}
+	if t, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		info.ModTime = t
+	}
+	if mt, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type")); err == nil {
+		info.ContentType = helpers.ContentType(mt)
+	}
+	// For a partial response the size of the file is reported by Content-Range e.g. "bytes 0-9/100".
+	if resp.StatusCode == http.StatusPartialContent {
+		info.Size = -1
+		if cr := resp.Header.Get("Content-Range"); cr != "" {
+			if i := strings.LastIndexByte(cr, '/'); i >= 0 {
+				if n, err := strconv.ParseInt(cr[i+1:], 10, 64); err == nil {
+					info.Size = n
+				}
+			}
+		}
+	}
+	return info
+}
+
+// statusError returns an error describing an unexpected response.
+func statusError(method string, uri string, resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	helpers.IgnoreError(resp.Body.Close())
+	return errors.Errorf("%v %v failed; status: %v; body: %v", method, uri, resp.Status, string(body))
+}
+
+func newHTTPFileHelper(ctx context.Context, f *Factory, u *url.URL) (FileHelper, error) {
+	return &HTTPFileHelper{
+		TokenSource:         f.HTTPTokenSource,
+		AllowInsecureTokens: f.HTTPAllowInsecureTokens,
+	}, nil
+}
@@ -0,0 +1,131 @@
+package files
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/oauth2"
+)
+
+func Test_HTTPFileHelper(t *testing.T) {
+	ctx := context.Background()
+	contents := "0123456789abcdefghij"
+	modTime := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path != "/config.yaml" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Etag", `"v1"`)
+		http.ServeContent(w, r, "config.yaml", modTime, strings.NewReader(contents))
+	}))
+	defer server.Close()
+	uri := server.URL + "/config.yaml"
+
+	// The test server doesn't use TLS so sending the token has to be allowed explicitly.
+	f := &Factory{
+		HTTPTokenSource:         oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "secret"}),
+		HTTPAllowInsecureTokens: true,
+	}
+	fh, err := f.Get(uri)
+	if err != nil {
+		t.Fatalf("Get failed; error: %v", err)
+	}
+	h, ok := fh.(*HTTPFileHelper)
+	if !ok {
+		t.Fatalf("Got helper of type %T; want *HTTPFileHelper", fh)
+	}
+
+	readAll := func(r io.ReadCloser, err error) string {
+		t.Helper()
+		if err != nil {
+			t.Fatalf("Failed to create reader; error: %v", err)
+		}
+		defer r.Close()
+		b, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("Failed to read; error: %v", err)
+		}
+		return string(b)
+	}
+
+	if actual := readAll(h.NewReaderContext(ctx, uri)); actual != contents {
+		t.Errorf("Got %v; want %v", actual, contents)
+	}
+	if actual := readAll(h.NewRangeReader(ctx, uri, 5, 3)); actual != "567" {
+		t.Errorf("Range read got %v; want 567", actual)
+	}
+	if actual := readAll(h.NewRangeReader(ctx, uri, 15, 0)); actual != "fghij" {
+		t.Errorf("Range read got %v; want fghij", actual)
+	}
+
+	info, err := h.Stat(ctx, uri)
+	if err != nil {
+		t.Fatalf("Stat failed; error: %v", err)
+	}
+	if info.Etag != `"v1"` || info.Size != int64(len(contents)) || !info.ModTime.Equal(modTime) {
+		t.Errorf("Stat returned the wrong info; got %+v", info)
+	}
+
+	if _, _, err := h.NewReaderWithOptions(ctx, uri, &HTTPReadOptions{IfNoneMatch: `"v1"`}); !errors.Is(err, ErrNotModified) {
+		t.Errorf("Got error %v; want ErrNotModified", err)
+	}
+	if _, _, err := h.NewReaderWithOptions(ctx, uri, &HTTPReadOptions{IfModifiedSince: modTime}); !errors.Is(err, ErrNotModified) {
+		t.Errorf("Got error %v; want ErrNotModified", err)
+	}
+
+	exists, err := h.ExistsContext(ctx, server.URL+"/missing")
+	if err != nil || exists {
+		t.Errorf("ExistsContext(missing) got %v, %v; want false, nil", exists, err)
+	}
+	if _, err := h.NewWriterContext(ctx, uri); err == nil {
+		t.Errorf("NewWriterContext should fail; http URIs are read-only")
+	}
+
+	// Without a token the server rejects the request.
+	if _, err := (&HTTPFileHelper{}).NewReaderContext(ctx, uri); err == nil {
+		t.Errorf("NewReaderContext should fail without a token")
+	}
+}
+
+func Test_HTTPInsecureTokens(t *testing.T) {
+	ctx := context.Background()
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	uri := server.URL + "/config.yaml"
+
+	f := &Factory{HTTPTokenSource: oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "secret"})}
+	h, err := f.Get(uri)
+	if err != nil {
+		t.Fatalf("Get failed; error: %v", err)
+	}
+	if _, err := h.NewReaderContext(ctx, uri); err == nil {
+		t.Errorf("NewReaderContext should fail; the token would be sent over http")
+	}
+	if _, err := h.ExistsContext(ctx, uri); err == nil {
+		t.Errorf("ExistsContext should fail; the token would be sent over http")
+	}
+	if requests != 0 {
+		t.Errorf("Got %v requests; the request shouldn't be sent", requests)
+	}
+
+	// Without a token source plain http is fine.
+	exists, err := (&HTTPFileHelper{}).ExistsContext(ctx, uri)
+	if err != nil || !exists {
+		t.Errorf("ExistsContext got %v, %v; want true, nil", exists, err)
+	}
+}
@@ -1,6 +1,7 @@
 package files
 
 import (
+	"context"
 	"io"
 )
 
@@ -8,18 +9,43 @@ import (
 
 // FileHelper is an interface intended to transparently handle working with GCS, local files, and other filesystems
 // e.g. GCP Secret manager.
+//
+// Each method has a Context variant which should be preferred; the context can be used to cancel an operation or
+// apply a deadline to it. The methods without a context are kept for backwards compatibility; they are thin
+// adapters around the Context variants.
 type FileHelper interface {
 	Exists(path string) (bool, error)
-	NewReader(path string) (io.Reader, error)
+	ExistsContext(ctx context.Context, path string) (bool, error)
+	// NewReader creates a new reader. Callers should close the reader when they are done with it.
+	NewReader(path string) (io.ReadCloser, error)
+	// NewReaderContext creates a new reader. The context applies to the lifetime of the reader not just its
+	// creation; i.e. cancelling the context may cause subsequent reads to fail.
+	NewReaderContext(ctx context.Context, path string) (io.ReadCloser, error)
 	// NewWriter creates a new writer.
 	// If the path already exists the file is truncated.
 	// Caller should call exists to test if it already exists.
-	// TODO(jlewi): Should the return type be io.WriteCloser?
-	NewWriter(path string) (io.Writer, error)
+	//
+	// Callers must call Close and check the error it returns. For some backends (e.g. GCS) the data isn't
+	// committed until Close is called and errors writing the data are only reported by Close.
+	NewWriter(path string) (io.WriteCloser, error)
+	// NewWriterContext creates a new writer. The context applies to the lifetime of the writer; cancelling it
+	// may abort the write.
+	NewWriterContext(ctx context.Context, path string) (io.WriteCloser, error)
 }
 
 type DirectoryHelper interface {
 	FileHelper
+	// Glob returns the files matching the pattern. Every DirectoryHelper for files (i.e. not secrets) uses the
+	// syntax described by util.Glob so a pattern matches the same files regardless of where they are stored.
 	Glob(pattern string) ([]string, error)
+	GlobContext(ctx context.Context, pattern string) ([]string, error)
 	Join(elem ...string) string
+	// Delete deletes the file. An error is returned if it doesn't exist.
+	Delete(ctx context.Context, path string) error
+	// Stat returns information about the file.
+	Stat(ctx context.Context, path string) (*FileInfo, error)
+	// List returns all the files inside the directory, including those in subdirectories. Unlike Glob
+	// the path is not a pattern. For object stores, path is treated as a directory, i.e. listing gs://b/dir
+	// doesn't return gs://b/dir2/file.
+	List(ctx context.Context, path string) ([]*FileInfo, error)
 }
@@ -1,20 +1,37 @@
 package files
 
 import (
+	"context"
 	"io"
+	"io/fs"
+	"mime"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 
 	"github.com/jlewi/monogo/helpers"
+	"github.com/jlewi/monogo/util"
 
 	"github.com/pkg/errors"
 )
 
-type LocalFileHelper struct{}
+type LocalFileHelper struct {
+	// ExcludeDirs excludes directories from the results of Glob so they match object stores which don't have
+	// directories. By default directories are returned like filepath.Glob does.
+	ExcludeDirs bool
+}
 
 // NewReader creates a new Reader for local file.
-func (h *LocalFileHelper) NewReader(uri string) (io.Reader, error) {
+func (h *LocalFileHelper) NewReader(uri string) (io.ReadCloser, error) {
+	return h.NewReaderContext(context.Background(), uri)
+}
+
+// NewReaderContext creates a new Reader for local file.
+func (h *LocalFileHelper) NewReaderContext(ctx context.Context, uri string) (io.ReadCloser, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, errors.WithStack(err)
+	}
 	schemePrefix := FileScheme + "://"
 	uri = strings.TrimPrefix(uri, schemePrefix)
 	reader, err := os.Open(uri)
@@ -32,14 +49,26 @@ func (h *LocalFileHelper) NewReader(uri string) (io.Reader, error) {
 // so that the file is truncated if it already exists. If the caller doesn't want to overwrite it, they should
 // use exists to check if the file exists before calling this function. This change was made because we want to
 // support truncation.
-func (h *LocalFileHelper) NewWriter(uri string) (io.Writer, error) {
+//
+// The file is written atomically; see AtomicFileWriter. The file isn't modified until the writer is closed and
+// calling Abort on the writer leaves the file untouched.
+func (h *LocalFileHelper) NewWriter(uri string) (io.WriteCloser, error) {
+	return h.NewWriterContext(context.Background(), uri)
+}
+
+// NewWriterContext creates a new Writer for the local file. See NewWriter.
+func (h *LocalFileHelper) NewWriterContext(ctx context.Context, uri string) (io.WriteCloser, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	uri = strings.TrimPrefix(uri, FileScheme+"://")
 	dir := filepath.Dir(uri)
 	if _, err := os.Stat(dir); os.IsNotExist(err) {
 		if err := os.MkdirAll(dir, helpers.UserGroupAllPerm); err != nil {
 			return nil, errors.WithStack(errors.Wrapf(err, "Could not create directory: %v", dir))
 		}
 	}
-	writer, err := os.Create(uri)
+	writer, err := newAtomicFileWriter(ctx, uri)
 
 	if err != nil {
 		return nil, errors.WithStack(errors.Wrapf(err, "Could not write: %v", uri))
@@ -50,6 +79,15 @@ func (h *LocalFileHelper) NewWriter(uri string) (io.Writer, error) {
 
 // Exists checks whether the file exists.
 func (h *LocalFileHelper) Exists(uri string) (bool, error) {
+	return h.ExistsContext(context.Background(), uri)
+}
+
+// ExistsContext checks whether the file exists.
+func (h *LocalFileHelper) ExistsContext(ctx context.Context, uri string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, errors.WithStack(err)
+	}
+	uri = strings.TrimPrefix(uri, FileScheme+"://")
 	_, err := os.Stat(uri)
 	if os.IsNotExist(err) {
 		return false, nil
@@ -59,9 +97,147 @@ func (h *LocalFileHelper) Exists(uri string) (bool, error) {
 
 // Glob returns the list of files that match the pattern.
 func (h *LocalFileHelper) Glob(uri string) ([]string, error) {
-	return filepath.Glob(uri)
+	return h.GlobContext(context.Background(), uri)
 }
 
+// GlobContext returns the list of files that match the pattern sorted by path. The syntax is described by
+// util.Glob and paths are matched using forward slashes. Directories are returned unless ExcludeDirs is set.
+func (h *LocalFileHelper) GlobContext(ctx context.Context, uri string) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	pattern := filepath.ToSlash(strings.TrimPrefix(uri, FileScheme+"://"))
+	g, err := util.CompileGlob(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	matches := []string{}
+	if g.IsLiteral() {
+		info, err := os.Stat(g.Prefix())
+		if err == nil && !(h.ExcludeDirs && info.IsDir()) {
+			matches = append(matches, filepath.FromSlash(g.Prefix()))
+		}
+		return matches, nil
+	}
+
+	// Walk the deepest directory that doesn't contain a wildcard.
+	dir := g.Prefix()[:strings.LastIndex(g.Prefix(), "/")+1]
+	root := dir
+	if root == "" {
+		root = "."
+	}
+	// Unless the pattern contains ** we don't need to descend further than the number of segments in the pattern.
+	maxDepth := -1
+	if !strings.Contains(pattern, "**") {
+		maxDepth = strings.Count(pattern[len(dir):], "/")
+	}
+
+	err = filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if p == root && errors.Is(err, fs.ErrNotExist) {
+				return fs.SkipDir
+			}
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		rel = filepath.ToSlash(rel)
+		if g.Match(dir+rel) && !(h.ExcludeDirs && d.IsDir()) {
+			matches = append(matches, filepath.FromSlash(dir+rel))
+		}
+		if d.IsDir() && maxDepth >= 0 && strings.Count(rel, "/") >= maxDepth {
+			return fs.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.WithStack(errors.Wrapf(err, "Could not glob: %v", uri))
+	}
+	sort.Strings(matches)
+	return matches, nil
+}
+
+// Join joins the elements of a path. If elem[0] is a file:// URI the result is too.
 func (h *LocalFileHelper) Join(elem ...string) string {
+	schemePrefix := FileScheme + "://"
+	if len(elem) > 0 && strings.HasPrefix(elem[0], schemePrefix) {
+		pieces := append([]string{strings.TrimPrefix(elem[0], schemePrefix)}, elem[1:]...)
+		return schemePrefix + filepath.Join(pieces...)
+	}
 	return filepath.Join(elem...)
 }
+
+// Delete deletes the file.
+func (h *LocalFileHelper) Delete(ctx context.Context, uri string) error {
+	if err := ctx.Err(); err != nil {
+		return errors.WithStack(err)
+	}
+	uri = strings.TrimPrefix(uri, FileScheme+"://")
+	if err := os.Remove(uri); err != nil {
+		return errors.WithStack(errors.Wrapf(err, "Could not delete: %v", uri))
+	}
+	return nil
+}
+
+// Stat returns information about the file.
+func (h *LocalFileHelper) Stat(ctx context.Context, uri string) (*FileInfo, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	uri = strings.TrimPrefix(uri, FileScheme+"://")
+	info, err := os.Stat(uri)
+	if err != nil {
+		return nil, errors.WithStack(errors.Wrapf(err, "Could not stat: %v", uri))
+	}
+	return localFileInfo(uri, info), nil
+}
+
+// List returns all the files in the directory and its subdirectories sorted by path.
+// Directories themselves are not included.
+func (h *LocalFileHelper) List(ctx context.Context, uri string) ([]*FileInfo, error) {
+	uri = strings.TrimPrefix(uri, FileScheme+"://")
+	results := []*FileInfo{}
+	err := filepath.WalkDir(uri, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		results = append(results, localFileInfo(p, info))
+		return nil
+	})
+	if err != nil {
+		return nil, errors.WithStack(errors.Wrapf(err, "Could not list: %v", uri))
+	}
+	// WalkDir's order isn't lexicographic for full paths (e.g. a/b sorts after a.txt) so we sort to match GCS.
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].URI < results[j].URI
+	})
+	return results, nil
+}
+
+func localFileInfo(path string, info fs.FileInfo) *FileInfo {
+	return &FileInfo{
+		URI:         path,
+		Size:        info.Size(),
+		ModTime:     info.ModTime(),
+		ContentType: helpers.ContentType(mime.TypeByExtension(filepath.Ext(path))),
+	}
+}
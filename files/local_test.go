@@ -1,10 +1,14 @@
 package files
 
 import (
+	"context"
 	"io"
 	"os"
 	"path/filepath"
 	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/jlewi/monogo/helpers"
 )
 
 func Test_LocalNewWriter(t *testing.T) {
@@ -44,11 +48,7 @@ func Test_LocalNewWriter(t *testing.T) {
 				t.Fatalf("Write() error: %v", err)
 			}
 
-			closer, ok := w.(io.Closer)
-			if !ok {
-				t.Fatalf("Writer is not a Closer")
-			}
-			if err := closer.Close(); err != nil {
+			if err := w.Close(); err != nil {
 				t.Fatalf("Close() error: %v", err)
 			}
 
@@ -58,3 +58,224 @@ func Test_LocalNewWriter(t *testing.T) {
 		})
 	}
 }
+
+func Test_LocalCancelledContext(t *testing.T) {
+	tDir, err := os.MkdirTemp("", "testLocalCancelledContext")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(tDir)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	h := &LocalFileHelper{}
+	path := filepath.Join(tDir, "test.txt")
+	if _, err := h.NewWriterContext(ctx, path); err == nil {
+		t.Errorf("NewWriterContext() with cancelled context should return an error")
+	}
+
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("NewWriterContext() with cancelled context should not create %v", path)
+	}
+
+	if _, err := h.NewReaderContext(ctx, path); err == nil {
+		t.Errorf("NewReaderContext() with cancelled context should return an error")
+	}
+}
+
+func Test_LocalStatListDelete(t *testing.T) {
+	tDir, err := os.MkdirTemp("", "testLocalStatListDelete")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(tDir)
+
+	files := map[string]string{
+		filepath.Join(tDir, "a.txt"):         "a",
+		filepath.Join(tDir, "sub", "b.json"): "bb",
+		filepath.Join(tDir, "sub", "c", "d"): "ddd",
+	}
+	for p, contents := range files {
+		if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+			t.Fatalf("MkdirAll() error: %v", err)
+		}
+		if err := os.WriteFile(p, []byte(contents), 0600); err != nil {
+			t.Fatalf("WriteFile() error: %v", err)
+		}
+	}
+
+	ctx := context.Background()
+	h := &LocalFileHelper{}
+
+	info, err := h.Stat(ctx, filepath.Join(tDir, "sub", "b.json"))
+	if err != nil {
+		t.Fatalf("Stat() error: %v", err)
+	}
+	if info.Size != 2 {
+		t.Errorf("Stat() got size %v; want 2", info.Size)
+	}
+	if info.ContentType != helpers.ContentTypeJSON {
+		t.Errorf("Stat() got content type %v; want %v", info.ContentType, helpers.ContentTypeJSON)
+	}
+
+	listed, err := h.List(ctx, tDir)
+	if err != nil {
+		t.Fatalf("List() error: %v", err)
+	}
+	actual := []string{}
+	for _, i := range listed {
+		actual = append(actual, i.URI)
+	}
+	expected := []string{
+		filepath.Join(tDir, "a.txt"),
+		filepath.Join(tDir, "sub", "b.json"),
+		filepath.Join(tDir, "sub", "c", "d"),
+	}
+	if d := cmp.Diff(expected, actual); d != "" {
+		t.Errorf("List() mismatch (-want +got):\n%s", d)
+	}
+
+	if err := h.Delete(ctx, filepath.Join(tDir, "a.txt")); err != nil {
+		t.Fatalf("Delete() error: %v", err)
+	}
+	if exists, _ := h.Exists(filepath.Join(tDir, "a.txt")); exists {
+		t.Errorf("File still exists after Delete()")
+	}
+	if err := h.Delete(ctx, filepath.Join(tDir, "a.txt")); err == nil {
+		t.Errorf("Delete() of a missing file should return an error")
+	}
+}
+
+func Test_LocalAtomicWriter(t *testing.T) {
+	tDir, err := os.MkdirTemp("", "testLocalAtomicWriter")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(tDir)
+
+	path := filepath.Join(tDir, "test.txt")
+	if err := os.WriteFile(path, []byte("original"), 0600); err != nil {
+		t.Fatalf("WriteFile() error: %v", err)
+	}
+
+	type testCase struct {
+		name     string
+		finish   func(w io.WriteCloser, cancel context.CancelFunc) error
+		wantErr  bool
+		expected string
+	}
+
+	cases := []testCase{
+		{
+			name: "abort",
+			finish: func(w io.WriteCloser, cancel context.CancelFunc) error {
+				return Abort(w)
+			},
+			expected: "original",
+		},
+		{
+			name: "cancelled",
+			finish: func(w io.WriteCloser, cancel context.CancelFunc) error {
+				cancel()
+				return w.Close()
+			},
+			wantErr:  true,
+			expected: "original",
+		},
+		{
+			name: "close",
+			finish: func(w io.WriteCloser, cancel context.CancelFunc) error {
+				return w.Close()
+			},
+			expected: "modified",
+		},
+	}
+
+	h := &LocalFileHelper{}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			w, err := h.NewWriterContext(ctx, path)
+			if err != nil {
+				t.Fatalf("NewWriterContext() error: %v", err)
+			}
+			if _, err := w.Write([]byte("modified")); err != nil {
+				t.Fatalf("Write() error: %v", err)
+			}
+
+			// The file shouldn't change until the writer is closed.
+			if actual, _ := os.ReadFile(path); string(actual) != "original" {
+				t.Errorf("File was modified before Close; got %v", string(actual))
+			}
+
+			err = c.finish(w, cancel)
+			if c.wantErr && err == nil {
+				t.Errorf("Expected an error")
+			}
+			if !c.wantErr && err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+
+			actual, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("ReadFile() error: %v", err)
+			}
+			if string(actual) != c.expected {
+				t.Errorf("Got %v; want %v", string(actual), c.expected)
+			}
+
+			// Make sure the temporary file was cleaned up.
+			entries, err := os.ReadDir(tDir)
+			if err != nil {
+				t.Fatalf("ReadDir() error: %v", err)
+			}
+			if len(entries) != 1 {
+				t.Errorf("Expected only %v in %v; got %v entries", path, tDir, len(entries))
+			}
+		})
+	}
+}
+
+func Test_LocalGlobDirs(t *testing.T) {
+	tDir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(tDir, "dir", "sub"), 0700); err != nil {
+		t.Fatalf("MkdirAll() error: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(tDir, "dir", "a.txt"), []byte("a"), 0600); err != nil {
+		t.Fatalf("WriteFile() error: %v", err)
+	}
+
+	type testCase struct {
+		pattern     string
+		excludeDirs bool
+		expected    []string
+	}
+
+	cases := []testCase{
+		{pattern: "dir/*", expected: []string{"dir/a.txt", "dir/sub"}},
+		{pattern: "dir/*", excludeDirs: true, expected: []string{"dir/a.txt"}},
+		{pattern: "dir/sub", expected: []string{"dir/sub"}},
+		{pattern: "dir/sub", excludeDirs: true, expected: []string{}},
+	}
+
+	for _, c := range cases {
+		h := &LocalFileHelper{ExcludeDirs: c.excludeDirs}
+		matches, err := h.Glob(filepath.Join(tDir, c.pattern))
+		if err != nil {
+			t.Fatalf("Glob(%v) error: %v", c.pattern, err)
+		}
+		actual := []string{}
+		for _, m := range matches {
+			rel, err := filepath.Rel(tDir, m)
+			if err != nil {
+				t.Fatalf("Rel() error: %v", err)
+			}
+			actual = append(actual, filepath.ToSlash(rel))
+		}
+		if d := cmp.Diff(c.expected, actual); d != "" {
+			t.Errorf("Glob(%v) ExcludeDirs=%v mismatch (-want +got):\n%s", c.pattern, c.excludeDirs, d)
+		}
+	}
+}
@@ -0,0 +1,305 @@
+package files
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jlewi/monogo/util"
+	"github.com/pkg/errors"
+)
+
+// DefaultMemFileHelper is the MemFileHelper that Factory returns for mem:// URIs.
+// Since it is shared by everything in the process, tests should use unique paths or call Reset.
+var DefaultMemFileHelper = NewMemFileHelper()
+
+// MemFileHelper implements FileHelper and DirectoryHelper using an in memory filesystem.
+// It is intended for hermetic tests. URIs look like mem://some/path.
+//
+// Errors and latency can be injected for individual paths in order to test how callers handle failures.
+type MemFileHelper struct {
+	mu         sync.Mutex
+	files      map[string]*memFile
+	errs       map[string]error
+	latency    map[string]time.Duration
+	generation int64
+}
+
+type memFile struct {
+	data       []byte
+	md5        [md5.Size]byte
+	modTime    time.Time
+	generation int64
+}
+
+// NewMemFileHelper creates a new, empty in memory filesystem.
+func NewMemFileHelper() *MemFileHelper {
+	h := &MemFileHelper{}
+	h.Reset()
+	return h
+}
+
+// Reset removes all files as well as any injected errors and latency.
+func (h *MemFileHelper) Reset() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.files = map[string]*memFile{}
+	h.errs = map[string]error{}
+	h.latency = map[string]time.Duration{}
+}
+
+// InjectError causes all operations on uri to return err. Pass a nil error to remove it.
+func (h *MemFileHelper) InjectError(uri string, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	key := memKey(uri)
+	if err == nil {
+		delete(h.errs, key)
+		return
+	}
+	h.errs[key] = err
+}
+
+// InjectLatency causes all operations on uri to block for d before doing anything.
+// Operations return early with an error if their context is done first. Pass 0 to remove it.
+func (h *MemFileHelper) InjectLatency(uri string, d time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	key := memKey(uri)
+	if d == 0 {
+		delete(h.latency, key)
+		return
+	}
+	h.latency[key] = d
+}
+
+// NewReader creates a new Reader for the file.
+func (h *MemFileHelper) NewReader(uri string) (io.ReadCloser, error) {
+	return h.NewReaderContext(context.Background(), uri)
+}
+
+// NewReaderContext creates a new Reader for the file.
+func (h *MemFileHelper) NewReaderContext(ctx context.Context, uri string) (io.ReadCloser, error) {
+	key := memKey(uri)
+	if err := h.fault(ctx, key); err != nil {
+		return nil, err
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	f, ok := h.files[key]
+	if !ok {
+		return nil, errors.Errorf("Could not read: %v; it doesn't exist", uri)
+	}
+	return io.NopCloser(bytes.NewReader(f.data)), nil
+}
+
+// NewWriter creates a new Writer for the file. The contents are only visible to readers once the writer
+// is closed. If the file already exists it is replaced.
+func (h *MemFileHelper) NewWriter(uri string) (io.WriteCloser, error) {
+	return h.NewWriterContext(context.Background(), uri)
+}
+
+// NewWriterContext creates a new Writer for the file. See NewWriter.
+func (h *MemFileHelper) NewWriterContext(ctx context.Context, uri string) (io.WriteCloser, error) {
+	key := memKey(uri)
+	if err := h.fault(ctx, key); err != nil {
+		return nil, err
+	}
+	return &memWriter{h: h, key: key}, nil
+}
+
+// Exists checks whether the file exists.
+func (h *MemFileHelper) Exists(uri string) (bool, error) {
+	return h.ExistsContext(context.Background(), uri)
+}
+
+// ExistsContext checks whether the file exists.
+func (h *MemFileHelper) ExistsContext(ctx context.Context, uri string) (bool, error) {
+	key := memKey(uri)
+	if err := h.fault(ctx, key); err != nil {
+		return false, err
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, ok := h.files[key]
+	return ok, nil
+}
+
+// Glob returns the list of files that match the pattern. The syntax is described by util.Glob.
+func (h *MemFileHelper) Glob(pattern string) ([]string, error) {
+	return h.GlobContext(context.Background(), pattern)
+}
+
+// GlobContext returns the list of files that match the pattern.
+func (h *MemFileHelper) GlobContext(ctx context.Context, pattern string) ([]string, error) {
+	key := memKey(pattern)
+	if err := h.fault(ctx, key); err != nil {
+		return nil, err
+	}
+	g, err := util.CompileGlob(key)
+	if err != nil {
+		return nil, err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	matches := []string{}
+	for k := range h.files {
+		if g.Match(k) {
+			matches = append(matches, k)
+		}
+	}
+	sort.Strings(matches)
+	return matches, nil
+}
+
+// Join joins the elements of a mem:// URI.
+func (h *MemFileHelper) Join(elem ...string) string {
+	if len(elem) == 0 {
+		return ""
+	}
+	prefix := MemScheme + "://"
+	if !strings.HasPrefix(elem[0], prefix) {
+		return path.Join(elem...)
+	}
+	pieces := []string{strings.TrimPrefix(elem[0], prefix)}
+	pieces = append(pieces, elem[1:]...)
+	return prefix + path.Join(pieces...)
+}
+
+// Delete deletes the file.
+func (h *MemFileHelper) Delete(ctx context.Context, uri string) error {
+	key := memKey(uri)
+	if err := h.fault(ctx, key); err != nil {
+		return err
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.files[key]; !ok {
+		return errors.Errorf("Could not delete: %v; it doesn't exist", uri)
+	}
+	delete(h.files, key)
+	return nil
+}
+
+// Stat returns information about the file.
+func (h *MemFileHelper) Stat(ctx context.Context, uri string) (*FileInfo, error) {
+	key := memKey(uri)
+	if err := h.fault(ctx, key); err != nil {
+		return nil, err
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	f, ok := h.files[key]
+	if !ok {
+		return nil, errors.Errorf("Could not stat: %v; it doesn't exist", uri)
+	}
+	return f.info(key), nil
+}
+
+// List returns all the files in the directory and its subdirectories sorted by URI.
+func (h *MemFileHelper) List(ctx context.Context, uri string) ([]*FileInfo, error) {
+	key := memKey(uri)
+	if err := h.fault(ctx, key); err != nil {
+		return nil, err
+	}
+	prefix := key
+	if !strings.HasSuffix(prefix, "/") {
+		prefix = prefix + "/"
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	results := []*FileInfo{}
+	for k, f := range h.files {
+		if strings.HasPrefix(k, prefix) {
+			results = append(results, f.info(k))
+		}
+	}
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].URI < results[j].URI
+	})
+	return results, nil
+}
+
+func (f *memFile) info(key string) *FileInfo {
+	return &FileInfo{
+		URI:        key,
+		Size:       int64(len(f.data)),
+		ModTime:    f.modTime,
+		Generation: f.generation,
+		MD5:        f.md5[:],
+	}
+}
+
+// fault applies any latency or error injected for key.
+func (h *MemFileHelper) fault(ctx context.Context, key string) error {
+	h.mu.Lock()
+	d := h.latency[key]
+	err := h.errs[key]
+	h.mu.Unlock()
+
+	if d > 0 {
+		t := time.NewTimer(d)
+		defer t.Stop()
+		select {
+		case <-ctx.Done():
+			return errors.WithStack(ctx.Err())
+		case <-t.C:
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return errors.WithStack(err)
+	}
+	return err
+}
+
+// memKey normalizes the URI so that equivalent URIs refer to the same file.
+func memKey(uri string) string {
+	p := strings.TrimPrefix(uri, MemScheme+"://")
+	p = strings.TrimPrefix(path.Clean("/"+p), "/")
+	return MemScheme + "://" + p
+}
+
+// memWriter buffers the data and stores it when it is closed.
+type memWriter struct {
+	h      *MemFileHelper
+	key    string
+	buf    bytes.Buffer
+	closed bool
+}
+
+func (w *memWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, errors.Errorf("Write called on closed writer for %v", w.key)
+	}
+	return w.buf.Write(p)
+}
+
+// Abort discards the data without modifying the file.
+func (w *memWriter) Abort() error {
+	w.closed = true
+	return nil
+}
+
+func (w *memWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	w.h.mu.Lock()
+	defer w.h.mu.Unlock()
+	w.h.generation++
+	w.h.files[w.key] = &memFile{
+		data:       w.buf.Bytes(),
+		md5:        md5.Sum(w.buf.Bytes()),
+		modTime:    time.Now(),
+		generation: w.h.generation,
+	}
+	return nil
+}
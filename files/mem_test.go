@@ -0,0 +1,155 @@
+package files
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/pkg/errors"
+)
+
+func Test_MemFileHelper(t *testing.T) {
+	h := NewMemFileHelper()
+
+	files := map[string]string{
+		"mem://bucket/dir/a.txt":     "a",
+		"mem://bucket/dir/b.txt":     "b",
+		"mem://bucket/dir/sub/c.txt": "c",
+		"mem://bucket/other.csv":     "other",
+	}
+
+	for uri, contents := range files {
+		writeFile(t, h, uri, contents)
+	}
+
+	for uri, contents := range files {
+		exists, err := h.Exists(uri)
+		if err != nil {
+			t.Fatalf("Exists(%v) error: %v", uri, err)
+		}
+		if !exists {
+			t.Errorf("Exists(%v) = false; want true", uri)
+		}
+
+		r, err := h.NewReader(uri)
+		if err != nil {
+			t.Fatalf("NewReader(%v) error: %v", uri, err)
+		}
+		actual, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("ReadAll(%v) error: %v", uri, err)
+		}
+		if string(actual) != contents {
+			t.Errorf("NewReader(%v) got %v; want %v", uri, string(actual), contents)
+		}
+	}
+
+	if exists, err := h.Exists("mem://bucket/missing.txt"); err != nil || exists {
+		t.Errorf("Exists(missing) = %v, %v; want false, nil", exists, err)
+	}
+
+	if _, err := h.NewReader("mem://bucket/missing.txt"); err == nil {
+		t.Errorf("NewReader(missing) should return an error")
+	}
+
+	matches, err := h.Glob("mem://bucket/dir/*.txt")
+	if err != nil {
+		t.Fatalf("Glob error: %v", err)
+	}
+	expected := []string{"mem://bucket/dir/a.txt", "mem://bucket/dir/b.txt"}
+	if d := cmp.Diff(expected, matches); d != "" {
+		t.Errorf("Glob() mismatch (-want +got):\n%s", d)
+	}
+
+	if actual := h.Join("mem://bucket/dir/", "sub", "c.txt"); actual != "mem://bucket/dir/sub/c.txt" {
+		t.Errorf("Join() got %v; want mem://bucket/dir/sub/c.txt", actual)
+	}
+}
+
+func Test_MemFileHelperFaults(t *testing.T) {
+	h := NewMemFileHelper()
+	uri := "mem://bucket/flaky.txt"
+
+	injected := errors.New("injected error")
+	h.InjectError(uri, injected)
+	if _, err := h.NewWriter(uri); err != injected {
+		t.Errorf("NewWriter() got error %v; want %v", err, injected)
+	}
+	if _, err := h.Exists(uri); err != injected {
+		t.Errorf("Exists() got error %v; want %v", err, injected)
+	}
+	h.InjectError(uri, nil)
+	if _, err := h.Exists(uri); err != nil {
+		t.Errorf("Exists() got error %v after the error was removed", err)
+	}
+
+	h.InjectLatency(uri, time.Minute)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := h.ExistsContext(ctx, uri); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("ExistsContext() got error %v; want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func Test_FactoryMem(t *testing.T) {
+	f := &Factory{}
+	h, err := f.Get("mem://factory/test.txt")
+	if err != nil {
+		t.Fatalf("Get() error: %v", err)
+	}
+	if h != DefaultMemFileHelper {
+		t.Errorf("Get() should return DefaultMemFileHelper for mem:// URIs")
+	}
+}
+
+func Test_MemFileHelperStatList(t *testing.T) {
+	h := NewMemFileHelper()
+	ctx := context.Background()
+	for _, uri := range []string{"mem://b/dir/a", "mem://b/dir/sub/b", "mem://b/dir2/c"} {
+		writeFile(t, h, uri, uri)
+	}
+
+	info, err := h.Stat(ctx, "mem://b/dir/a")
+	if err != nil {
+		t.Fatalf("Stat() error: %v", err)
+	}
+	if info.Size != int64(len("mem://b/dir/a")) {
+		t.Errorf("Stat() got size %v", info.Size)
+	}
+
+	listed, err := h.List(ctx, "mem://b/dir")
+	if err != nil {
+		t.Fatalf("List() error: %v", err)
+	}
+	actual := []string{}
+	for _, i := range listed {
+		actual = append(actual, i.URI)
+	}
+	if d := cmp.Diff([]string{"mem://b/dir/a", "mem://b/dir/sub/b"}, actual); d != "" {
+		t.Errorf("List() mismatch (-want +got):\n%s", d)
+	}
+
+	if err := h.Delete(ctx, "mem://b/dir/a"); err != nil {
+		t.Fatalf("Delete() error: %v", err)
+	}
+	if _, err := h.Stat(ctx, "mem://b/dir/a"); err == nil {
+		t.Errorf("Stat() should fail after Delete()")
+	}
+}
+
+// writeFile writes contents to uri using h.
+func writeFile(t *testing.T, h FileHelper, uri string, contents string) {
+	t.Helper()
+	w, err := h.NewWriter(uri)
+	if err != nil {
+		t.Fatalf("NewWriter(%v) error: %v", uri, err)
+	}
+	if _, err := w.Write([]byte(contents)); err != nil {
+		t.Fatalf("Write(%v) error: %v", uri, err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close(%v) error: %v", uri, err)
+	}
+}
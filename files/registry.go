@@ -0,0 +1,133 @@
+package files
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// FileHelperFactory constructs a FileHelper for a URI with a particular scheme. ctx is the context passed to
+// Factory.GetContext and f is the Factory the helper is being created for; factories can use its options e.g.
+// HTTPTokenSource.
+type FileHelperFactory func(ctx context.Context, f *Factory, u *url.URL) (FileHelper, error)
+
+// DirectoryHelperFactory constructs a DirectoryHelper for a URI with a particular scheme. See FileHelperFactory.
+type DirectoryHelperFactory func(ctx context.Context, f *Factory, u *url.URL) (DirectoryHelper, error)
+
+var (
+	registryMu               sync.RWMutex
+	fileHelperFactories      map[string]FileHelperFactory
+	directoryHelperFactories map[string]DirectoryHelperFactory
+)
+
+func init() {
+	resetRegistry()
+}
+
+// resetRegistry restores the registry to just the built in schemes and codecs.
+func resetRegistry() {
+	registryMu.Lock()
+	fileHelperFactories = map[string]FileHelperFactory{}
+	directoryHelperFactories = map[string]DirectoryHelperFactory{}
+	registryMu.Unlock()
+
+	// The empty scheme corresponds to paths without a scheme e.g. /some/file which are treated as local files.
+	for _, scheme := range []string{"", FileScheme} {
+		mustRegister(RegisterDirectoryHelper(scheme, newLocalFileHelper))
+	}
+	mustRegister(RegisterDirectoryHelper(GCSScheme, newGcsHelper))
+	mustRegister(RegisterDirectoryHelper(MemScheme, newMemFileHelper))
+	mustRegister(RegisterDirectoryHelper(SecretManagerScheme, newGCPSecretManager))
+	for _, scheme := range []string{HTTPScheme, HTTPSScheme} {
+		mustRegister(RegisterFileHelper(scheme, newHTTPFileHelper))
+	}
+	resetCodecs()
+}
+
+func mustRegister(err error) {
+	if err != nil {
+		panic(err)
+	}
+}
+
+// RegisterFileHelper registers a factory for FileHelpers for URIs with the given scheme. This allows packages
+// to add support for additional storage systems to Factory.Get. Schemes are case-insensitive.
+// An error is returned if a factory is already registered for the scheme.
+//
+// RegisterDirectoryHelper should be used instead if the helper also implements DirectoryHelper.
+func RegisterFileHelper(scheme string, factory FileHelperFactory) error {
+	normalized, err := normalizeScheme(scheme)
+	if err != nil {
+		return err
+	}
+	if factory == nil {
+		return errors.Errorf("Can't register a nil factory for scheme %q", scheme)
+	}
+
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, ok := fileHelperFactories[normalized]; ok {
+		return errors.Errorf("A FileHelper is already registered for scheme %q", normalized)
+	}
+	fileHelperFactories[normalized] = factory
+	return nil
+}
+
+// RegisterDirectoryHelper registers a factory for DirectoryHelpers for URIs with the given scheme.
+// The factory is used by both Factory.GetDirHelper and Factory.Get; so it also registers a FileHelper
+// for the scheme. An error is returned if either is already registered for the scheme.
+func RegisterDirectoryHelper(scheme string, factory DirectoryHelperFactory) error {
+	normalized, err := normalizeScheme(scheme)
+	if err != nil {
+		return err
+	}
+	if factory == nil {
+		return errors.Errorf("Can't register a nil factory for scheme %q", scheme)
+	}
+
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, ok := directoryHelperFactories[normalized]; ok {
+		return errors.Errorf("A DirectoryHelper is already registered for scheme %q", normalized)
+	}
+	if _, ok := fileHelperFactories[normalized]; ok {
+		return errors.Errorf("A FileHelper is already registered for scheme %q", normalized)
+	}
+	directoryHelperFactories[normalized] = factory
+	fileHelperFactories[normalized] = func(ctx context.Context, f *Factory, u *url.URL) (FileHelper, error) {
+		return factory(ctx, f, u)
+	}
+	return nil
+}
+
+func lookupFileHelper(scheme string) (FileHelperFactory, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	f, ok := fileHelperFactories[strings.ToLower(scheme)]
+	return f, ok
+}
+
+func lookupDirectoryHelper(scheme string) (DirectoryHelperFactory, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	f, ok := directoryHelperFactories[strings.ToLower(scheme)]
+	return f, ok
+}
+
+// normalizeScheme validates the scheme according to RFC 3986 and lower cases it.
+// The empty scheme is allowed; it is used for paths that don't have a scheme.
+func normalizeScheme(scheme string) (string, error) {
+	s := strings.ToLower(scheme)
+	for i, c := range s {
+		switch {
+		case 'a' <= c && c <= 'z':
+		case i > 0 && ('0' <= c && c <= '9' || c == '+' || c == '-' || c == '.'):
+		default:
+			return "", errors.Errorf("Invalid scheme %q; schemes must start with a letter and only contain letters, digits, +, - or .", scheme)
+		}
+	}
+	return s, nil
+}
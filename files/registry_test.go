@@ -0,0 +1,117 @@
+package files
+
+import (
+	"context"
+	"net/url"
+	"testing"
+
+	"github.com/jlewi/monogo/gcp/gcs"
+)
+
+type customFileHelper struct {
+	LocalFileHelper
+	host string
+}
+
+func Test_RegisterDirectoryHelper(t *testing.T) {
+	defer resetRegistry()
+
+	err := RegisterDirectoryHelper("Custom", func(ctx context.Context, f *Factory, u *url.URL) (DirectoryHelper, error) {
+		return &customFileHelper{host: u.Host}, nil
+	})
+	if err != nil {
+		t.Fatalf("RegisterDirectoryHelper() error: %v", err)
+	}
+
+	f := &Factory{}
+	h, err := f.GetDirHelper("custom://somehost/some/path")
+	if err != nil {
+		t.Fatalf("GetDirHelper() error: %v", err)
+	}
+	c, ok := h.(*customFileHelper)
+	if !ok {
+		t.Fatalf("GetDirHelper() returned %T; want *customFileHelper", h)
+	}
+	if c.host != "somehost" {
+		t.Errorf("Factory wasn't passed the URI; got host %v", c.host)
+	}
+
+	if _, err := f.Get("custom://somehost/some/path"); err != nil {
+		t.Errorf("Get() error: %v", err)
+	}
+
+	if err := RegisterFileHelper("custom", func(ctx context.Context, f *Factory, u *url.URL) (FileHelper, error) { return nil, nil }); err == nil {
+		t.Errorf("RegisterFileHelper() should fail when the scheme is already registered")
+	}
+}
+
+func Test_RegisterInvalid(t *testing.T) {
+	defer resetRegistry()
+
+	cases := []string{"1abc", "a b", "gs"}
+	for _, scheme := range cases {
+		if err := RegisterDirectoryHelper(scheme, newGCPSecretManager); err == nil {
+			t.Errorf("RegisterDirectoryHelper(%q) should return an error", scheme)
+		}
+		if err := RegisterFileHelper(scheme, func(ctx context.Context, f *Factory, u *url.URL) (FileHelper, error) { return &LocalFileHelper{}, nil }); err == nil {
+			t.Errorf("RegisterFileHelper(%q) should return an error", scheme)
+		}
+	}
+}
+
+func Test_FactoryUnsupported(t *testing.T) {
+	defer resetRegistry()
+
+	if err := RegisterFileHelper("fileonly", func(ctx context.Context, f *Factory, u *url.URL) (FileHelper, error) { return &LocalFileHelper{}, nil }); err != nil {
+		t.Fatalf("RegisterFileHelper() error: %v", err)
+	}
+
+	f := &Factory{}
+	if _, err := f.Get("unknown://some/path"); err == nil {
+		t.Errorf("Get() should fail for unregistered schemes")
+	}
+	if _, err := f.Get("fileonly://some/path"); err != nil {
+		t.Errorf("Get() error: %v", err)
+	}
+	if _, err := f.GetDirHelper("fileonly://some/path"); err == nil {
+		t.Errorf("GetDirHelper() should fail for schemes with only a FileHelper registered")
+	}
+}
+
+func Test_FactoryGCS(t *testing.T) {
+	// Using the emulator means the client doesn't need credentials; no requests are sent.
+	t.Setenv("STORAGE_EMULATOR_HOST", "localhost:9")
+	type ctxKey struct{}
+	ctx := context.WithValue(context.Background(), ctxKey{}, "caller")
+
+	f := &Factory{}
+	h, err := f.GetContext(ctx, "gs://bucket/a.txt")
+	if err != nil {
+		t.Fatalf("GetContext() error: %v", err)
+	}
+	dh, err := f.GetDirHelperContext(ctx, "gs://bucket/dir")
+	if err != nil {
+		t.Fatalf("GetDirHelperContext() error: %v", err)
+	}
+	g, ok := h.(*gcs.GcsHelper)
+	if !ok {
+		t.Fatalf("GetContext() returned %T; want *gcs.GcsHelper", h)
+	}
+	dg, ok := dh.(*gcs.GcsHelper)
+	if !ok {
+		t.Fatalf("GetDirHelperContext() returned %T; want *gcs.GcsHelper", dh)
+	}
+	if g.Ctx != ctx {
+		t.Errorf("GcsHelper.Ctx should be the context passed to GetContext")
+	}
+	if g.Client == nil || g.Client != dg.Client {
+		t.Errorf("Helpers created by the same Factory should share the storage client")
+	}
+
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close() error: %v", err)
+	}
+	if f.storageClient != nil {
+		t.Errorf("Close() should release the storage client")
+	}
+}
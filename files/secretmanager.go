@@ -3,14 +3,19 @@ package files
 import (
 	"bytes"
 	"context"
+	"fmt"
 	"io"
-	"net/url"
+	"path"
+	"strconv"
+	"strings"
 
 	secretmanager "cloud.google.com/go/secretmanager/apiv1"
 	"cloud.google.com/go/secretmanager/apiv1/secretmanagerpb"
 	"github.com/go-logr/zapr"
+	"github.com/jlewi/monogo/util"
 	"github.com/pkg/errors"
 	"go.uber.org/zap"
+	"google.golang.org/api/iterator"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
@@ -20,48 +25,41 @@ const (
 	SecretManagerScheme = "gcpsecretmanager"
 )
 
-// GCPSecretManager implements the interface but for GCP secrets
-// URIs should look like gcpsecretmanager:///projects/${project}/secrets/${secret}/versions/${version}
+// GCPSecretManager implements the DirectoryHelper interface but for GCP secrets.
+// URIs should look like gcpsecretmanager:///projects/${project}/secrets/${secret}/versions/${version}; see SecretURI
+// for all the supported forms.
+//
+// Secrets are treated like directories containing their versions. Reading a secret reads its latest version.
 type GCPSecretManager struct {
 	Client *secretmanager.Client
 }
 
 // NewReader creates a new Reader for local file.
-func (h *GCPSecretManager) NewReader(uri string) (io.Reader, error) {
-	log := zapr.NewLogger(zap.L())
+func (h *GCPSecretManager) NewReader(uri string) (io.ReadCloser, error) {
+	return h.NewReaderContext(context.Background(), uri)
+}
 
-	if h.Client == nil {
-		log.Info("No client set attempting to create default client")
-		client, err := secretmanager.NewClient(context.Background())
+// NewReaderContext creates a new Reader for the secret.
+func (h *GCPSecretManager) NewReaderContext(ctx context.Context, uri string) (io.ReadCloser, error) {
+	log := zapr.NewLogger(zap.L())
 
-		if err != nil {
-			return nil, err
-		}
-		h.Client = client
+	if err := h.init(ctx); err != nil {
+		return nil, err
 	}
 
-	u, err := url.Parse(uri)
+	sURI, err := ParseSecretURI(uri)
 	if err != nil {
-		return nil, errors.Wrapf(err, "Couldn't parse URI %v", uri)
+		return nil, err
 	}
-	if u.Scheme != SecretManagerScheme {
-		return nil, errors.Wrapf(err, "URI %v doesn't have scheme %v", uri, u.Scheme)
-	}
-
-	secret := u.Path
-	if u.Host == "projects" {
-		secret = u.Host + u.Path
-	}
-	if secret[0:1] == "/" {
-		secret = secret[1:]
+	if sURI.Secret == "" {
+		return nil, errors.Errorf("Can't read %v; the URI doesn't specify a secret", uri)
 	}
+	secret := sURI.VersionName()
 
 	accessRequest := &secretmanagerpb.AccessSecretVersionRequest{
 		Name: secret,
 	}
 
-	ctx := context.Background()
-
 	// Call the API.
 	result, err := h.Client.AccessSecretVersion(ctx, accessRequest)
 	if err != nil {
@@ -81,15 +79,416 @@ func (h *GCPSecretManager) NewReader(uri string) (io.Reader, error) {
 		return nil, errors.Wrapf(err, "failed to access secret %v", secret)
 	}
 
-	return bytes.NewReader(result.Payload.Data), nil
+	return io.NopCloser(bytes.NewReader(result.Payload.Data)), nil
 }
 
-// NewWriter creates a new Writer
-func (h *GCPSecretManager) NewWriter(uri string) (io.Writer, error) {
-	return nil, errors.New("Exists isn't implemented for GCPSecretManager")
+// NewWriter creates a new Writer. See NewWriterContext.
+func (h *GCPSecretManager) NewWriter(uri string) (io.WriteCloser, error) {
+	return h.NewWriterContext(context.Background(), uri)
+}
+
+// NewWriterContext creates a new Writer for the secret. The URI must not include a version; when the writer is
+// closed the secret is created if it doesn't exist and the data is added as a new version.
+func (h *GCPSecretManager) NewWriterContext(ctx context.Context, uri string) (io.WriteCloser, error) {
+	if err := h.init(ctx); err != nil {
+		return nil, err
+	}
+
+	sURI, err := ParseSecretURI(uri)
+	if err != nil {
+		return nil, err
+	}
+	if sURI.Secret == "" {
+		return nil, errors.Errorf("Can't write to %v; the URI doesn't specify a secret", uri)
+	}
+	if sURI.Version != "" {
+		return nil, errors.Errorf("Can't write to %v; URIs for writing secrets can't include a version", uri)
+	}
+	return &secretWriter{
+		ctx:     ctx,
+		client:  h.Client,
+		project: sURI.Project,
+		secret:  sURI.Secret,
+	}, nil
 }
 
 // Exists checks whether the file exists.
 func (h *GCPSecretManager) Exists(uri string) (bool, error) {
-	return false, errors.New("Exists isn't implemented for GCPSecretManager")
+	return h.ExistsContext(context.Background(), uri)
+}
+
+// ExistsContext checks whether the secret exists. If the URI includes a version then it checks whether that
+// version exists and is enabled i.e. whether it can be read.
+func (h *GCPSecretManager) ExistsContext(ctx context.Context, uri string) (bool, error) {
+	if err := h.init(ctx); err != nil {
+		return false, err
+	}
+
+	sURI, err := ParseSecretURI(uri)
+	if err != nil {
+		return false, err
+	}
+	if sURI.Secret == "" {
+		return false, errors.Errorf("Can't check whether %v exists; the URI doesn't specify a secret", uri)
+	}
+
+	name := sURI.SecretName()
+	if sURI.Version == "" {
+		_, err = h.Client.GetSecret(ctx, &secretmanagerpb.GetSecretRequest{Name: name})
+	} else {
+		name = sURI.VersionName()
+		var v *secretmanagerpb.SecretVersion
+		v, err = h.Client.GetSecretVersion(ctx, &secretmanagerpb.GetSecretVersionRequest{Name: name})
+		if err == nil && v.State != secretmanagerpb.SecretVersion_ENABLED {
+			return false, nil
+		}
+	}
+
+	if err == nil {
+		return true, nil
+	}
+	if status.Code(err) == codes.NotFound {
+		return false, nil
+	}
+	return false, errors.Wrapf(err, "failed to get secret %v", name)
+}
+
+// init creates a client if one isn't set.
+func (h *GCPSecretManager) init(ctx context.Context) error {
+	if h.Client != nil {
+		return nil
+	}
+	log := zapr.NewLogger(zap.L())
+	log.Info("No client set attempting to create default client")
+	client, err := secretmanager.NewClient(ctx)
+
+	if err != nil {
+		return err
+	}
+	h.Client = client
+	return nil
+}
+
+// CreateSecretIfMissing creates the secret in the project if it doesn't already exist.
+// The secret uses automatic replication.
+func CreateSecretIfMissing(ctx context.Context, client *secretmanager.Client, project string, secret string) error {
+	log := zapr.NewLogger(zap.L())
+	// Create the request to create the secret.
+	createSecretReq := &secretmanagerpb.CreateSecretRequest{
+		Parent:   fmt.Sprintf("projects/%s", project),
+		SecretId: secret,
+		Secret: &secretmanagerpb.Secret{
+			Replication: &secretmanagerpb.Replication{
+				Replication: &secretmanagerpb.Replication_Automatic_{
+					Automatic: &secretmanagerpb.Replication_Automatic{},
+				},
+			},
+		},
+	}
+
+	_, err := client.CreateSecret(ctx, createSecretReq)
+	if err != nil {
+		status, ok := status.FromError(err)
+		if !ok {
+			log.Error(err, "Error creating secret.", "project", project, "secret", secret)
+			return err
+		}
+
+		if status.Code() == codes.AlreadyExists {
+			log.Info("Secret exists", "project", project, "secret", secret)
+		} else {
+			log.Error(err, "Error creating secret.", "project", project, "secret", secret)
+			return err
+		}
+	}
+	return nil
+}
+
+// AddSecretVersion adds a new version of the secret containing payload. The secret must already exist.
+func AddSecretVersion(ctx context.Context, client *secretmanager.Client, project string, secret string, payload []byte) (*secretmanagerpb.SecretVersion, error) {
+	// Build the request.
+	addSecretVersionReq := &secretmanagerpb.AddSecretVersionRequest{
+		Parent: fmt.Sprintf("projects/%v/secrets/%v", project, secret),
+		Payload: &secretmanagerpb.SecretPayload{
+			Data: payload,
+		},
+	}
+
+	// Call the API.
+	return client.AddSecretVersion(ctx, addSecretVersionReq)
+}
+
+// secretWriter buffers the data and adds it as a new version of the secret on Close.
+type secretWriter struct {
+	ctx     context.Context
+	client  *secretmanager.Client
+	project string
+	secret  string
+	buf     bytes.Buffer
+	closed  bool
+}
+
+func (w *secretWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, errors.Errorf("Write called on closed writer for secret %v", w.secret)
+	}
+	return w.buf.Write(p)
+}
+
+// Abort discards the data without adding a new version.
+func (w *secretWriter) Abort() error {
+	w.closed = true
+	return nil
+}
+
+func (w *secretWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	log := zapr.NewLogger(zap.L())
+	if err := CreateSecretIfMissing(w.ctx, w.client, w.project, w.secret); err != nil {
+		return errors.Wrapf(err, "failed to create secret %v in project %v", w.secret, w.project)
+	}
+	version, err := AddSecretVersion(w.ctx, w.client, w.project, w.secret, w.buf.Bytes())
+	if err != nil {
+		return errors.Wrapf(err, "failed to add a version to secret %v in project %v", w.secret, w.project)
+	}
+	log.Info("Added secret version", "version", version.GetName())
+	return nil
+}
+
+// Glob lists the secrets or versions matching the pattern. See GlobContext.
+func (h *GCPSecretManager) Glob(pattern string) ([]string, error) {
+	return h.GlobContext(context.Background(), pattern)
+}
+
+// GlobContext lists the secrets or versions matching the pattern. The syntax is described by util.Glob.
+// If the pattern includes a version then the versions of the secret matching it are returned e.g.
+// gcpsecretmanager:///projects/p/secrets/s/versions/*. Otherwise the secrets in the project matching the pattern
+// are returned e.g. gcpsecretmanager:///projects/p/secrets/api-*. The project and, when matching versions,
+// the secret can't contain wildcards.
+func (h *GCPSecretManager) GlobContext(ctx context.Context, pattern string) ([]string, error) {
+	sURI, err := ParseSecretURI(escapeGlobURI(pattern))
+	if err != nil {
+		return nil, err
+	}
+	if sURI.Secret == "" {
+		return nil, errors.Errorf("Pattern %v doesn't specify the secrets to match", pattern)
+	}
+
+	itemPattern := sURI.Secret
+	if sURI.Version != "" {
+		itemPattern = sURI.Version
+	}
+	// Compile the pattern before listing so that we return an error even when there are no candidates.
+	g, err := util.CompileGlob(itemPattern)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Invalid pattern %v", pattern)
+	}
+
+	var candidates []*SecretURI
+	if sURI.Version != "" {
+		candidates, err = h.listVersions(ctx, sURI)
+	} else {
+		candidates, err = h.listSecrets(ctx, sURI)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	matches := []string{}
+	for _, c := range candidates {
+		item := c.Secret
+		if sURI.Version != "" {
+			item = c.Version
+		}
+		if g.Match(item) {
+			matches = append(matches, c.ToURI())
+		}
+	}
+	return matches, nil
+}
+
+// escapeGlobURI escapes the ? wildcards in a glob pattern so that url.Parse doesn't treat them as the start of
+// the query. Only a trailing ?project=${project} is kept as the query.
+func escapeGlobURI(pattern string) string {
+	query := ""
+	if i := strings.LastIndex(pattern, "?project="); i >= 0 {
+		pattern, query = pattern[:i], pattern[i:]
+	}
+	pattern = strings.ReplaceAll(pattern, "%", "%25")
+	return strings.ReplaceAll(pattern, "?", "%3F") + query
+}
+
+// Join joins the elements of a secret URI.
+func (h *GCPSecretManager) Join(elem ...string) string {
+	if len(elem) == 0 {
+		return ""
+	}
+	prefix := SecretManagerScheme + ":///"
+	if !strings.HasPrefix(elem[0], prefix) {
+		return path.Join(elem...)
+	}
+	pieces := []string{strings.TrimPrefix(elem[0], prefix)}
+	pieces = append(pieces, elem[1:]...)
+	return prefix + path.Join(pieces...)
+}
+
+// Delete destroys the version of the secret. The URI must include a version; deleting a secret including all
+// its versions is almost never what callers of DirectoryHelper.Delete (e.g. Move) intend, so it requires
+// calling DeleteSecret.
+func (h *GCPSecretManager) Delete(ctx context.Context, uri string) error {
+	if err := h.init(ctx); err != nil {
+		return err
+	}
+	sURI, err := ParseSecretURI(uri)
+	if err != nil {
+		return err
+	}
+	if sURI.Secret == "" || sURI.Version == "" {
+		return errors.Errorf("Can't delete %v; the URI doesn't specify a version. Use DeleteSecret to delete the secret and all its versions", uri)
+	}
+	if _, err := h.Client.DestroySecretVersion(ctx, &secretmanagerpb.DestroySecretVersionRequest{Name: sURI.VersionName()}); err != nil {
+		return errors.Wrapf(err, "failed to destroy secret version %v", sURI.VersionName())
+	}
+	return nil
+}
+
+// DeleteSecret deletes the secret including all its versions. The URI can't include a version; use Delete to
+// destroy a single version.
+func (h *GCPSecretManager) DeleteSecret(ctx context.Context, uri string) error {
+	if err := h.init(ctx); err != nil {
+		return err
+	}
+	sURI, err := ParseSecretURI(uri)
+	if err != nil {
+		return err
+	}
+	if sURI.Secret == "" || sURI.Version != "" {
+		return errors.Errorf("Can't delete %v; the URI must refer to a secret without a version", uri)
+	}
+	if err := h.Client.DeleteSecret(ctx, &secretmanagerpb.DeleteSecretRequest{Name: sURI.SecretName()}); err != nil {
+		return errors.Wrapf(err, "failed to delete secret %v", sURI.SecretName())
+	}
+	return nil
+}
+
+// Stat returns information about the version of the secret; the latest version if the URI doesn't specify one.
+// Size isn't set because it isn't available without accessing the payload. Generation is set to the version number.
+func (h *GCPSecretManager) Stat(ctx context.Context, uri string) (*FileInfo, error) {
+	if err := h.init(ctx); err != nil {
+		return nil, err
+	}
+	sURI, err := ParseSecretURI(uri)
+	if err != nil {
+		return nil, err
+	}
+	if sURI.Secret == "" {
+		return nil, errors.Errorf("Can't stat %v; the URI doesn't specify a secret", uri)
+	}
+	v, err := h.Client.GetSecretVersion(ctx, &secretmanagerpb.GetSecretVersionRequest{Name: sURI.VersionName()})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get secret version %v", sURI.VersionName())
+	}
+	return versionInfo(sURI, v), nil
+}
+
+// List returns the versions of the secret. If the URI refers to a project, e.g. gcpsecretmanager:///projects/p,
+// the secrets in the project are returned instead.
+func (h *GCPSecretManager) List(ctx context.Context, uri string) ([]*FileInfo, error) {
+	sURI, err := ParseSecretURI(uri)
+	if err != nil {
+		return nil, err
+	}
+	if sURI.Version != "" {
+		return nil, errors.Errorf("Can't list %v; the URI refers to a version", uri)
+	}
+
+	results := []*FileInfo{}
+	if sURI.Secret == "" {
+		secrets, err := h.listSecrets(ctx, sURI)
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range secrets {
+			results = append(results, &FileInfo{URI: s.ToURI()})
+		}
+		return results, nil
+	}
+
+	versions, err := h.listVersions(ctx, sURI)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range versions {
+		results = append(results, &FileInfo{URI: v.ToURI()})
+	}
+	return results, nil
+}
+
+// listSecrets lists all the secrets in the project.
+func (h *GCPSecretManager) listSecrets(ctx context.Context, sURI *SecretURI) ([]*SecretURI, error) {
+	if err := h.init(ctx); err != nil {
+		return nil, err
+	}
+	results := []*SecretURI{}
+	it := h.Client.ListSecrets(ctx, &secretmanagerpb.ListSecretsRequest{Parent: sURI.ProjectName()})
+	for {
+		s, err := it.Next()
+		if err == iterator.Done {
+			return results, nil
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to list secrets in %v", sURI.ProjectName())
+		}
+		// N.B. The names returned by the API use the project number so we use the project from the URI and only
+		// take the ID from the name.
+		results = append(results, &SecretURI{
+			Project: sURI.Project,
+			Secret:  path.Base(s.GetName()),
+		})
+	}
+}
+
+// listVersions lists all the versions of the secret.
+func (h *GCPSecretManager) listVersions(ctx context.Context, sURI *SecretURI) ([]*SecretURI, error) {
+	if err := h.init(ctx); err != nil {
+		return nil, err
+	}
+	results := []*SecretURI{}
+	it := h.Client.ListSecretVersions(ctx, &secretmanagerpb.ListSecretVersionsRequest{Parent: sURI.SecretName()})
+	for {
+		v, err := it.Next()
+		if err == iterator.Done {
+			return results, nil
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to list versions of %v", sURI.SecretName())
+		}
+		results = append(results, &SecretURI{
+			Project: sURI.Project,
+			Secret:  sURI.Secret,
+			Version: path.Base(v.GetName()),
+		})
+	}
+}
+
+func versionInfo(sURI *SecretURI, v *secretmanagerpb.SecretVersion) *FileInfo {
+	versionURI := &SecretURI{
+		Project: sURI.Project,
+		Secret:  sURI.Secret,
+		Version: path.Base(v.GetName()),
+	}
+	info := &FileInfo{
+		URI:  versionURI.ToURI(),
+		Etag: v.Etag,
+	}
+	if v.CreateTime != nil {
+		info.ModTime = v.CreateTime.AsTime()
+	}
+	if n, err := strconv.ParseInt(versionURI.Version, 10, 64); err == nil {
+		info.Generation = n
+	}
+	return info
 }
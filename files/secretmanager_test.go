@@ -0,0 +1,211 @@
+package files
+
+import (
+	"context"
+	"io"
+	"sort"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/secretmanager/apiv1/secretmanagerpb"
+	"github.com/google/go-cmp/cmp"
+	"github.com/jlewi/monogo/gcp/smtest"
+)
+
+func newTestSecretManager(t *testing.T) (*GCPSecretManager, *smtest.Server) {
+	t.Helper()
+	srv, err := smtest.NewServer()
+	if err != nil {
+		t.Fatalf("Failed to start fake secret manager: %v", err)
+	}
+	t.Cleanup(srv.Close)
+
+	client, err := srv.Client(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return &GCPSecretManager{Client: client}, srv
+}
+
+func Test_SecretManagerReadWrite(t *testing.T) {
+	h, _ := newTestSecretManager(t)
+	ctx := context.Background()
+	uri := "gcpsecretmanager:///projects/p/secrets/s"
+
+	for _, value := range []string{"first", "second"} {
+		writeFile(t, h, uri, value)
+	}
+
+	type testCase struct {
+		uri      string
+		expected string
+	}
+
+	cases := []testCase{
+		{uri: uri, expected: "second"},
+		{uri: uri + "/versions/1", expected: "first"},
+		{uri: "gcpsecretmanager:///s/versions/2?project=p", expected: "second"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.uri, func(t *testing.T) {
+			r, err := h.NewReaderContext(ctx, c.uri)
+			if err != nil {
+				t.Fatalf("NewReader(%v) error: %v", c.uri, err)
+			}
+			defer r.Close()
+			actual, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatalf("ReadAll error: %v", err)
+			}
+			if d := cmp.Diff(c.expected, string(actual)); d != "" {
+				t.Errorf("Unexpected contents; diff:\n%v", d)
+			}
+		})
+	}
+}
+
+func Test_SecretManagerErrors(t *testing.T) {
+	h, _ := newTestSecretManager(t)
+	ctx := context.Background()
+
+	if err := CreateSecretIfMissing(ctx, h.Client, "p", "s"); err != nil {
+		t.Fatalf("CreateSecretIfMissing error: %v", err)
+	}
+	if _, err := AddSecretVersion(ctx, h.Client, "p", "s", []byte("v1")); err != nil {
+		t.Fatalf("AddSecretVersion error: %v", err)
+	}
+	if _, err := h.Client.DisableSecretVersion(ctx, &secretmanagerpb.DisableSecretVersionRequest{Name: "projects/p/secrets/s/versions/1"}); err != nil {
+		t.Fatalf("DisableSecretVersion error: %v", err)
+	}
+
+	type testCase struct {
+		name     string
+		uri      string
+		expected string
+	}
+
+	cases := []testCase{
+		{
+			name:     "not-found",
+			uri:      "gcpsecretmanager:///projects/p/secrets/missing",
+			expected: "doesn't exist",
+		},
+		{
+			name:     "disabled",
+			uri:      "gcpsecretmanager:///projects/p/secrets/s",
+			expected: "problem trying to access",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := h.NewReaderContext(ctx, c.uri)
+			if err == nil {
+				t.Fatalf("NewReader(%v) should have failed", c.uri)
+			}
+			if !strings.Contains(err.Error(), c.expected) {
+				t.Errorf("NewReader(%v) got error %v; want it to contain %q", c.uri, err, c.expected)
+			}
+
+			exists, err := h.ExistsContext(ctx, c.uri+"/versions/latest")
+			if err != nil {
+				t.Fatalf("Exists(%v) error: %v", c.uri, err)
+			}
+			if exists {
+				t.Errorf("Exists(%v) got true; want false", c.uri)
+			}
+		})
+	}
+}
+
+func Test_SecretManagerGlob(t *testing.T) {
+	h, _ := newTestSecretManager(t)
+	ctx := context.Background()
+	for _, s := range []string{"api-a", "api-b", "api-ab", "other"} {
+		writeFile(t, h, "gcpsecretmanager:///projects/p/secrets/"+s, "value")
+	}
+	for i := 0; i < 2; i++ {
+		writeFile(t, h, "gcpsecretmanager:///projects/p/secrets/api-a", "value")
+	}
+
+	type testCase struct {
+		pattern  string
+		expected []string
+	}
+
+	cases := []testCase{
+		{
+			pattern:  "gcpsecretmanager:///projects/p/secrets/api-?",
+			expected: []string{"gcpsecretmanager:///projects/p/secrets/api-a", "gcpsecretmanager:///projects/p/secrets/api-b"},
+		},
+		{
+			// The short form's query isn't confused with a ? wildcard.
+			pattern:  "gcpsecretmanager:///api-??project=p",
+			expected: []string{"gcpsecretmanager:///projects/p/secrets/api-a", "gcpsecretmanager:///projects/p/secrets/api-b"},
+		},
+		{
+			pattern:  "gcpsecretmanager:///projects/p/secrets/{api-ab,other}",
+			expected: []string{"gcpsecretmanager:///projects/p/secrets/api-ab", "gcpsecretmanager:///projects/p/secrets/other"},
+		},
+		{
+			pattern:  "gcpsecretmanager:///projects/p/secrets/api-a/versions/[12]",
+			expected: []string{"gcpsecretmanager:///projects/p/secrets/api-a/versions/1", "gcpsecretmanager:///projects/p/secrets/api-a/versions/2"},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.pattern, func(t *testing.T) {
+			actual, err := h.GlobContext(ctx, c.pattern)
+			if err != nil {
+				t.Fatalf("Glob(%v) error: %v", c.pattern, err)
+			}
+			sort.Strings(actual)
+			if d := cmp.Diff(c.expected, actual); d != "" {
+				t.Errorf("Glob(%v) mismatch (-want +got):\n%s", c.pattern, d)
+			}
+		})
+	}
+
+	if _, err := h.GlobContext(ctx, "gcpsecretmanager:///projects/p/secrets/api-[a"); err == nil {
+		t.Errorf("Glob should fail for an invalid pattern")
+	}
+}
+
+func Test_SecretManagerDelete(t *testing.T) {
+	h, _ := newTestSecretManager(t)
+	ctx := context.Background()
+	uri := "gcpsecretmanager:///projects/p/secrets/s"
+	writeFile(t, h, uri, "first")
+	writeFile(t, h, uri, "second")
+
+	// Delete requires a version so that e.g. Move doesn't delete every version of the secret.
+	if err := h.Delete(ctx, uri); err == nil {
+		t.Errorf("Delete(%v) should fail without a version", uri)
+	}
+	if err := h.Delete(ctx, uri+"/versions/1"); err != nil {
+		t.Fatalf("Delete error: %v", err)
+	}
+	v, err := h.Client.GetSecretVersion(ctx, &secretmanagerpb.GetSecretVersionRequest{Name: "projects/p/secrets/s/versions/1"})
+	if err != nil {
+		t.Fatalf("GetSecretVersion error: %v", err)
+	}
+	if v.GetState() != secretmanagerpb.SecretVersion_DESTROYED {
+		t.Errorf("Version 1 has state %v; want %v", v.GetState(), secretmanagerpb.SecretVersion_DESTROYED)
+	}
+
+	if err := h.DeleteSecret(ctx, uri+"/versions/2"); err == nil {
+		t.Errorf("DeleteSecret should fail if the URI includes a version")
+	}
+	if err := h.DeleteSecret(ctx, uri); err != nil {
+		t.Fatalf("DeleteSecret error: %v", err)
+	}
+	exists, err := h.ExistsContext(ctx, uri)
+	if err != nil {
+		t.Fatalf("Exists error: %v", err)
+	}
+	if exists {
+		t.Errorf("Secret %v still exists after DeleteSecret", uri)
+	}
+}
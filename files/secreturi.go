@@ -0,0 +1,127 @@
+package files
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// LatestVersion is the alias for the most recent version of a secret.
+	LatestVersion = "latest"
+)
+
+// SecretURI is a parsed gcpsecretmanager URI.
+//
+// The following forms are supported
+//
+//	gcpsecretmanager:///projects/${project}/secrets/${secret}
+//	gcpsecretmanager:///projects/${project}/secrets/${secret}/versions/${version}
+//	gcpsecretmanager:///${secret}?project=${project}
+//	gcpsecretmanager:///${secret}/versions/${version}?project=${project}
+//
+// If no version is specified the latest version is read. ${version} can be a version number or an alias such as
+// latest.
+//
+// For listing, gcpsecretmanager:///projects/${project} refers to all the secrets in the project. For backwards
+// compatibility the form gcpsecretmanager://projects/${project}/... (i.e. with projects as the host) is accepted.
+type SecretURI struct {
+	Project string
+	// Secret is the ID of the secret. It is empty if the URI refers to the project.
+	Secret string
+	// Version is empty if the URI didn't specify one.
+	Version string
+}
+
+// ParseSecretURI parses a gcpsecretmanager URI.
+func ParseSecretURI(uri string) (*SecretURI, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Couldn't parse URI %v", uri)
+	}
+	if u.Scheme != SecretManagerScheme {
+		return nil, errors.Errorf("URI %v doesn't have scheme %v", uri, SecretManagerScheme)
+	}
+
+	name := u.Path
+	if u.Host == "projects" {
+		name = u.Host + u.Path
+	} else if u.Host != "" {
+		return nil, errors.Errorf("URI %v is invalid; it should start with %v:/// (three slashes)", uri, SecretManagerScheme)
+	}
+	name = strings.Trim(name, "/")
+	pieces := strings.Split(name, "/")
+
+	r := &SecretURI{}
+	project := u.Query().Get("project")
+	if project != "" {
+		// Short form: ${secret}[/versions/${version}]?project=${project}
+		r.Project = project
+		switch {
+		case len(pieces) == 1 && pieces[0] != "":
+			r.Secret = pieces[0]
+		case len(pieces) == 3 && pieces[1] == "versions":
+			r.Secret = pieces[0]
+			r.Version = pieces[2]
+		default:
+			return nil, invalidSecretURI(uri)
+		}
+	} else {
+		if len(pieces) < 2 || pieces[0] != "projects" {
+			return nil, invalidSecretURI(uri)
+		}
+		r.Project = pieces[1]
+		switch {
+		case len(pieces) == 2:
+		case len(pieces) == 4 && pieces[2] == "secrets":
+			r.Secret = pieces[3]
+		case len(pieces) == 6 && pieces[2] == "secrets" && pieces[4] == "versions":
+			r.Secret = pieces[3]
+			r.Version = pieces[5]
+		default:
+			return nil, invalidSecretURI(uri)
+		}
+	}
+
+	if r.Project == "" || (r.Secret == "" && r.Version != "") {
+		return nil, invalidSecretURI(uri)
+	}
+	return r, nil
+}
+
+func invalidSecretURI(uri string) error {
+	return errors.Errorf("URI %v isn't a valid secret URI; it should be %v:///projects/${project}/secrets/${secret}[/versions/${version}]", uri, SecretManagerScheme)
+}
+
+// ProjectName returns the resource name of the project i.e. projects/${project}.
+func (s *SecretURI) ProjectName() string {
+	return fmt.Sprintf("projects/%v", s.Project)
+}
+
+// SecretName returns the resource name of the secret i.e. projects/${project}/secrets/${secret}.
+func (s *SecretURI) SecretName() string {
+	return fmt.Sprintf("projects/%v/secrets/%v", s.Project, s.Secret)
+}
+
+// VersionName returns the resource name of the version. If no version was specified the latest version is used.
+func (s *SecretURI) VersionName() string {
+	version := s.Version
+	if version == "" {
+		version = LatestVersion
+	}
+	return fmt.Sprintf("%v/versions/%v", s.SecretName(), version)
+}
+
+// ToURI returns the canonical URI.
+func (s *SecretURI) ToURI() string {
+	name := s.ProjectName()
+	if s.Secret != "" {
+		name = s.SecretName()
+	}
+	if s.Version != "" {
+		name = s.VersionName()
+	}
+	return SecretManagerScheme + ":///" + name
+}
@@ -0,0 +1,92 @@
+package files
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_ParseSecretURI(t *testing.T) {
+	type testCase struct {
+		name     string
+		input    string
+		expected *SecretURI
+		wantErr  bool
+		uri      string
+	}
+
+	cases := []testCase{
+		{
+			name:     "latest",
+			input:    "gcpsecretmanager:///projects/p/secrets/s",
+			expected: &SecretURI{Project: "p", Secret: "s"},
+			uri:      "gcpsecretmanager:///projects/p/secrets/s",
+		},
+		{
+			name:     "version",
+			input:    "gcpsecretmanager:///projects/p/secrets/s/versions/3",
+			expected: &SecretURI{Project: "p", Secret: "s", Version: "3"},
+			uri:      "gcpsecretmanager:///projects/p/secrets/s/versions/3",
+		},
+		{
+			name:     "projects-host",
+			input:    "gcpsecretmanager://projects/p/secrets/s/versions/latest",
+			expected: &SecretURI{Project: "p", Secret: "s", Version: "latest"},
+			uri:      "gcpsecretmanager:///projects/p/secrets/s/versions/latest",
+		},
+		{
+			name:     "short",
+			input:    "gcpsecretmanager:///s?project=p",
+			expected: &SecretURI{Project: "p", Secret: "s"},
+			uri:      "gcpsecretmanager:///projects/p/secrets/s",
+		},
+		{
+			name:     "short-version",
+			input:    "gcpsecretmanager:///s/versions/2?project=p",
+			expected: &SecretURI{Project: "p", Secret: "s", Version: "2"},
+			uri:      "gcpsecretmanager:///projects/p/secrets/s/versions/2",
+		},
+		{
+			name:     "project",
+			input:    "gcpsecretmanager:///projects/p",
+			expected: &SecretURI{Project: "p"},
+			uri:      "gcpsecretmanager:///projects/p",
+		},
+		{
+			name:    "wrong-scheme",
+			input:   "gs://projects/p/secrets/s",
+			wantErr: true,
+		},
+		{
+			name:    "missing-secret",
+			input:   "gcpsecretmanager:///projects/p/secrets",
+			wantErr: true,
+		},
+		{
+			name:    "bad-host",
+			input:   "gcpsecretmanager://p/secrets/s",
+			wantErr: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			actual, err := ParseSecretURI(c.input)
+			if c.wantErr {
+				if err == nil {
+					t.Fatalf("ParseSecretURI(%v) should return an error", c.input)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseSecretURI(%v) error: %v", c.input, err)
+			}
+			if d := cmp.Diff(c.expected, actual); d != "" {
+				t.Errorf("ParseSecretURI() mismatch (-want +got):\n%s", d)
+			}
+			if actual.ToURI() != c.uri {
+				t.Errorf("ToURI() got %v; want %v", actual.ToURI(), c.uri)
+			}
+		})
+	}
+}
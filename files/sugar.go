@@ -1,24 +1,33 @@
 package files
 
 import (
+	"context"
 	"io"
 
+	"github.com/jlewi/monogo/helpers"
 	"github.com/pkg/errors"
 )
 
 // Read reads the given URI
 func Read(uri string) ([]byte, error) {
+	return ReadContext(context.Background(), uri)
+}
+
+// ReadContext reads the given URI. The context can be used to cancel the read or apply a deadline.
+func ReadContext(ctx context.Context, uri string) ([]byte, error) {
 	f := &Factory{}
-	h, err := f.Get(uri)
+	defer helpers.DeferIgnoreError(f.Close)
+	h, err := f.GetContext(ctx, uri)
 	if err != nil {
 		return nil, err
 	}
-	r, err := h.NewReader(uri)
+	r, err := h.NewReaderContext(ctx, uri)
 	if err != nil {
 		return nil, err
 	}
 	if r == nil {
 		return nil, errors.Errorf("no reader was returned for %v", uri)
 	}
+	defer r.Close()
 	return io.ReadAll(r)
 }
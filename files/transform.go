@@ -0,0 +1,301 @@
+package files
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/go-cmd/cmd"
+	"github.com/go-logr/zapr"
+	"github.com/jlewi/monogo/helpers"
+	"github.com/jlewi/monogo/util"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+const defaultTransformWorkers = 8
+
+// TransformFunc produces the file output from the file input.
+type TransformFunc func(ctx context.Context, input string, output string) error
+
+// TransformStatus is the outcome of transforming a single file.
+type TransformStatus string
+
+const (
+	TransformSucceeded TransformStatus = "succeeded"
+	TransformSkipped   TransformStatus = "skipped"
+	TransformFailed    TransformStatus = "failed"
+)
+
+// TransformResult reports what happened to a single file.
+type TransformResult struct {
+	Input    string
+	Output   string
+	Status   TransformStatus
+	Err      error
+	Duration time.Duration
+}
+
+// TransformOptions control Transform. The zero value uses the defaults.
+type TransformOptions struct {
+	// Workers is the number of files transformed concurrently. Defaults to 8.
+	Workers int
+	// Overwrite, if true, transforms files whose output already exists; otherwise they are skipped.
+	Overwrite bool
+	// Factory is used to check whether outputs exist. Defaults to &Factory{}.
+	Factory *Factory
+}
+
+// BuildTransformList returns a map from the files matching the regex inputPattern to the outputs produced by
+// the template outputPattern; see util.TransformFiles. The files are found by listing the directory given by the
+// literal prefix of inputPattern so it works with any scheme with a DirectoryHelper. Matching is done against the
+// URIs returned by DirectoryHelper.List; e.g. local paths don't include file://.
+func BuildTransformList(ctx context.Context, f *Factory, inputPattern string, outputPattern string) (map[string]string, error) {
+	if f == nil {
+		f = &Factory{}
+		defer helpers.DeferIgnoreError(f.Close)
+	}
+	dir, err := regexDir(inputPattern)
+	if err != nil {
+		return nil, err
+	}
+	h, err := f.GetDirHelperContext(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+	infos, err := h.List(ctx, dir)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Could not list files matching: %v", inputPattern)
+	}
+	paths := make([]string, 0, len(infos))
+	for _, i := range infos {
+		paths = append(paths, i.URI)
+	}
+	return util.TransformFiles(paths, inputPattern, outputPattern)
+}
+
+// regexDir returns the directory containing all the files that could match the regex pattern; i.e. the literal
+// prefix of the pattern up to the last /. Escaped metacharacters are part of the literal prefix e.g. the prefix of
+// data\.v1/.* is data.v1/.
+func regexDir(pattern string) (string, error) {
+	re, err := regexp.Compile(strings.TrimPrefix(pattern, "^"))
+	if err != nil {
+		return "", errors.Wrapf(err, "Invalid regex %v", pattern)
+	}
+	prefix, _ := re.LiteralPrefix()
+	i := strings.LastIndex(prefix, "/")
+	if i < 0 {
+		return ".", nil
+	}
+	return prefix[:i+1], nil
+}
+
+// Transform calls f for each input and output in mapping using a bounded number of workers. Unless opts.Overwrite
+// is set, inputs whose output already exists are skipped; f should therefore only create the output once it has
+// succeeded, as the writers returned by FileHelpers do when they are aborted, so a failed transform is retried
+// on the next run.
+//
+// A result is returned for every file, sorted by input. The error is non-nil if any file failed or ctx was
+// cancelled; files that weren't attempted because ctx was cancelled are reported as failed.
+func Transform(ctx context.Context, mapping map[string]string, f TransformFunc, opts *TransformOptions) ([]*TransformResult, error) {
+	log := zapr.NewLogger(zap.L())
+	if opts == nil {
+		opts = &TransformOptions{}
+	}
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = defaultTransformWorkers
+	}
+	factory := opts.Factory
+	if factory == nil {
+		factory = &Factory{}
+		defer helpers.DeferIgnoreError(factory.Close)
+	}
+
+	results := make([]*TransformResult, 0, len(mapping))
+	for input, output := range mapping {
+		results = append(results, &TransformResult{Input: input, Output: output})
+	}
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Input < results[j].Input
+	})
+
+	process := func(r *TransformResult) {
+		start := time.Now()
+		defer func() {
+			r.Duration = time.Since(start)
+		}()
+		if err := ctx.Err(); err != nil {
+			r.Status, r.Err = TransformFailed, err
+			return
+		}
+		if !opts.Overwrite {
+			h, err := factory.GetContext(ctx, r.Output)
+			if err != nil {
+				r.Status, r.Err = TransformFailed, err
+				return
+			}
+			exists, err := h.ExistsContext(ctx, r.Output)
+			if err != nil {
+				r.Status, r.Err = TransformFailed, errors.Wrapf(err, "Failed to check whether %v exists", r.Output)
+				return
+			}
+			if exists {
+				log.V(1).Info("Skipping file; output already exists", "input", r.Input, "output", r.Output)
+				r.Status = TransformSkipped
+				return
+			}
+		}
+		log.Info("Transforming file", "input", r.Input, "output", r.Output)
+		if err := f(ctx, r.Input, r.Output); err != nil {
+			log.Error(err, "Failed to transform file", "input", r.Input, "output", r.Output)
+			r.Status, r.Err = TransformFailed, err
+			return
+		}
+		r.Status = TransformSucceeded
+	}
+
+	work := make(chan *TransformResult)
+	wg := sync.WaitGroup{}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for r := range work {
+				process(r)
+			}
+		}()
+	}
+	for _, r := range results {
+		work <- r
+	}
+	close(work)
+	wg.Wait()
+
+	failed := 0
+	for _, r := range results {
+		if r.Status == TransformFailed {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return results, errors.Errorf("Failed to transform %v of %v files", failed, len(results))
+	}
+	return results, nil
+}
+
+// CopyTransform returns a TransformFunc which copies the input to the output. Using a Factory with
+// TransparentCompression converts between compression formats e.g. data.jsonl to data.jsonl.gz.
+func CopyTransform(f *Factory) TransformFunc {
+	if f == nil {
+		f = &Factory{}
+	}
+	return func(ctx context.Context, input string, output string) error {
+		srcHelper, err := f.GetContext(ctx, input)
+		if err != nil {
+			return err
+		}
+		dstHelper, err := f.GetContext(ctx, output)
+		if err != nil {
+			return err
+		}
+		return copyWithHelpers(ctx, srcHelper, input, dstHelper, output)
+	}
+}
+
+// ConvertTransform returns a TransformFunc which streams the input through convert to produce the output. If
+// convert returns an error the output is aborted so it isn't created.
+func ConvertTransform(f *Factory, convert func(r io.Reader, w io.Writer) error) TransformFunc {
+	if f == nil {
+		f = &Factory{}
+	}
+	return func(ctx context.Context, input string, output string) error {
+		srcHelper, err := f.GetContext(ctx, input)
+		if err != nil {
+			return err
+		}
+		dstHelper, err := f.GetContext(ctx, output)
+		if err != nil {
+			return err
+		}
+		r, err := srcHelper.NewReaderContext(ctx, input)
+		if err != nil {
+			return err
+		}
+		defer helpers.DeferIgnoreError(r.Close)
+
+		// Cancelling the writer's context ensures a partial output isn't committed by writers that don't support
+		// Abort.
+		wCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		w, err := dstHelper.NewWriterContext(wCtx, output)
+		if err != nil {
+			return err
+		}
+		if err := convert(r, w); err != nil {
+			cancel()
+			helpers.IgnoreError(abortOrClose(w))
+			return errors.Wrapf(err, "Failed to convert %v to %v", input, output)
+		}
+		if err := w.Close(); err != nil {
+			return errors.Wrapf(err, "Failed to close %v", output)
+		}
+		return nil
+	}
+}
+
+// CommandTransform returns a TransformFunc which runs an external command for each file. The arguments are
+// templates which can refer to {{.Input}} and {{.Output}} e.g.
+//
+//	CommandTransform("convert", "{{.Input}}", "{{.Output}}")
+//
+// The command's output is streamed to the logs. The URIs are passed as is so the command must understand them;
+// e.g. use local files or a command that supports gs:// URIs.
+func CommandTransform(name string, args ...string) (TransformFunc, error) {
+	templates := make([]*template.Template, 0, len(args))
+	for _, a := range args {
+		t, err := template.New("arg").Option("missingkey=error").Parse(a)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Error parsing template: %v", a)
+		}
+		templates = append(templates, t)
+	}
+
+	return func(ctx context.Context, input string, output string) error {
+		log := zapr.NewLogger(zap.L())
+		data := map[string]string{"Input": input, "Output": output}
+		expanded := make([]string, 0, len(templates))
+		for _, t := range templates {
+			buf := &bytes.Buffer{}
+			if err := t.Execute(buf, data); err != nil {
+				return errors.Wrapf(err, "Error executing template for %v", input)
+			}
+			expanded = append(expanded, buf.String())
+		}
+
+		cmdString := strings.Join(append([]string{name}, expanded...), " ")
+		c := cmd.NewCmdOptions(cmd.Options{Streaming: true}, name, expanded...)
+		helpers.StreamCmdToLogs(c, log.WithValues("input", input))
+		statusChan := c.Start()
+		var status cmd.Status
+		select {
+		case status = <-statusChan:
+		case <-ctx.Done():
+			helpers.IgnoreError(c.Stop())
+			return errors.Wrapf(ctx.Err(), "Command for %v was cancelled", input)
+		}
+		if status.Error != nil {
+			return errors.Wrapf(status.Error, "Failed to run %v", cmdString)
+		}
+		if status.Exit != 0 {
+			return errors.Errorf("%v exited with code %v", cmdString, status.Exit)
+		}
+		return nil
+	}, nil
+}
@@ -0,0 +1,173 @@
+package files
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/pkg/errors"
+)
+
+func Test_Transform(t *testing.T) {
+	defer DefaultMemFileHelper.Reset()
+	ctx := context.Background()
+	for name, contents := range map[string]string{
+		"mem://transform/in/a.txt":     "a",
+		"mem://transform/in/b.txt":     "b",
+		"mem://transform/in/sub/c.txt": "c",
+		"mem://transform/in/d.json":    "d",
+		"mem://transform/in/bad.txt":   "bad",
+		// The output for b already exists so it is skipped.
+		"mem://transform/out/b.csv": "existing",
+	} {
+		writeMemFile(t, name, contents)
+	}
+
+	mapping, err := BuildTransformList(ctx, nil, `mem://transform/in/(?P<name>.*)\.txt`, "mem://transform/out/{{.name}}.csv")
+	if err != nil {
+		t.Fatalf("BuildTransformList failed; error: %v", err)
+	}
+	expectedMapping := map[string]string{
+		"mem://transform/in/a.txt":     "mem://transform/out/a.csv",
+		"mem://transform/in/b.txt":     "mem://transform/out/b.csv",
+		"mem://transform/in/bad.txt":   "mem://transform/out/bad.csv",
+		"mem://transform/in/sub/c.txt": "mem://transform/out/sub/c.csv",
+	}
+	if d := cmp.Diff(expectedMapping, mapping); d != "" {
+		t.Fatalf("Unexpected mapping; diff:\n%v", d)
+	}
+
+	upper := ConvertTransform(nil, func(r io.Reader, w io.Writer) error {
+		b, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		if string(b) == "bad" {
+			return errors.New("bad input")
+		}
+		_, err = io.WriteString(w, strings.ToUpper(string(b)))
+		return err
+	})
+	results, err := Transform(ctx, mapping, upper, &TransformOptions{Workers: 2})
+	if err == nil {
+		t.Errorf("Transform should return an error when a file fails")
+	}
+
+	actual := map[string]TransformStatus{}
+	for _, r := range results {
+		actual[r.Input] = r.Status
+	}
+	expected := map[string]TransformStatus{
+		"mem://transform/in/a.txt":     TransformSucceeded,
+		"mem://transform/in/b.txt":     TransformSkipped,
+		"mem://transform/in/bad.txt":   TransformFailed,
+		"mem://transform/in/sub/c.txt": TransformSucceeded,
+	}
+	if d := cmp.Diff(expected, actual); d != "" {
+		t.Errorf("Unexpected results; diff:\n%v", d)
+	}
+
+	for output, contents := range map[string]string{
+		"mem://transform/out/a.csv":     "A",
+		"mem://transform/out/b.csv":     "existing",
+		"mem://transform/out/sub/c.csv": "C",
+	} {
+		b, err := Read(output)
+		if err != nil {
+			t.Errorf("Read(%v) failed; error: %v", output, err)
+			continue
+		}
+		if string(b) != contents {
+			t.Errorf("Read(%v) got %v; want %v", output, string(b), contents)
+		}
+	}
+	if exists, _ := DefaultMemFileHelper.Exists("mem://transform/out/bad.csv"); exists {
+		t.Errorf("The output of a failed transform shouldn't be created")
+	}
+}
+
+func Test_CommandTransform(t *testing.T) {
+	tDir, err := os.MkdirTemp("", "testCommandTransform")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(tDir)
+	input := filepath.Join(tDir, "input.txt")
+	if err := os.WriteFile(input, []byte("hello"), 0644); err != nil {
+		t.Fatalf("Failed to write %v; error: %v", input, err)
+	}
+
+	f, err := CommandTransform("cp", "{{.Input}}", "{{.Output}}")
+	if err != nil {
+		t.Fatalf("CommandTransform failed; error: %v", err)
+	}
+	output := filepath.Join(tDir, "output.txt")
+	results, err := Transform(context.Background(), map[string]string{input: output}, f, nil)
+	if err != nil {
+		t.Fatalf("Transform failed; error: %v", err)
+	}
+	if results[0].Status != TransformSucceeded {
+		t.Errorf("Got status %v; want %v", results[0].Status, TransformSucceeded)
+	}
+	b, err := os.ReadFile(output)
+	if err != nil || string(b) != "hello" {
+		t.Errorf("The command didn't copy the file; got %v, %v", string(b), err)
+	}
+
+	// A command that exits with a non-zero code fails.
+	if err := f(context.Background(), filepath.Join(tDir, "missing.txt"), output); err == nil {
+		t.Errorf("The transform should fail when the command fails")
+	}
+
+	if _, err := CommandTransform("cp", "{{.Input"); err == nil {
+		t.Errorf("CommandTransform should fail for an invalid template")
+	}
+}
+
+func Test_RegexDir(t *testing.T) {
+	cases := map[string]string{
+		`gs://bucket/data/(?P<name>.*)\.pdf`: "gs://bucket/data/",
+		`gs://bucket/data\.v1/(.*)`:          "gs://bucket/data.v1/",
+		`/tmp/my\.dir/sub/(?P<name>.*)\.txt`: "/tmp/my.dir/sub/",
+		`/tmp/a.b/(.*)`:                      "/tmp/",
+		`/tmp/some/dir/file\.txt`:            "/tmp/some/dir/",
+		`^/tmp/x/.*`:                         "/tmp/x/",
+		`(.*)\.txt`:                          ".",
+	}
+	for pattern, expected := range cases {
+		actual, err := regexDir(pattern)
+		if err != nil {
+			t.Errorf("regexDir(%v) error: %v", pattern, err)
+			continue
+		}
+		if actual != expected {
+			t.Errorf("regexDir(%v) got %v; want %v", pattern, actual, expected)
+		}
+	}
+	if _, err := regexDir(`/tmp/(.*`); err == nil {
+		t.Errorf("regexDir should fail for an invalid regex")
+	}
+}
+
+func Test_BuildTransformListEscapedDot(t *testing.T) {
+	defer DefaultMemFileHelper.Reset()
+	for _, name := range []string{"mem://transform/data.v1/a.txt", "mem://transform/data.v1/sub/b.txt", "mem://transform/dataxv1/c.txt"} {
+		writeMemFile(t, name, name)
+	}
+
+	mapping, err := BuildTransformList(context.Background(), nil, `mem://transform/data\.v1/(?P<name>.*)\.txt`, "mem://transform/out/{{.name}}.csv")
+	if err != nil {
+		t.Fatalf("BuildTransformList failed; error: %v", err)
+	}
+	expected := map[string]string{
+		"mem://transform/data.v1/a.txt":     "mem://transform/out/a.csv",
+		"mem://transform/data.v1/sub/b.txt": "mem://transform/out/sub/b.csv",
+	}
+	if d := cmp.Diff(expected, mapping); d != "" {
+		t.Errorf("Unexpected mapping; diff:\n%v", d)
+	}
+}
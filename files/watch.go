@@ -0,0 +1,63 @@
+package files
+
+import (
+	"context"
+
+	"github.com/jlewi/monogo/helpers"
+)
+
+// WatchEvent reports a change to a file; see helpers.WatchEvent.
+type WatchEvent = helpers.WatchEvent
+
+// WatchOptions control how files are watched; see helpers.WatchOptions.
+type WatchOptions = helpers.WatchOptions
+
+const (
+	WatchCreate = helpers.WatchCreate
+	WatchModify = helpers.WatchModify
+	WatchDelete = helpers.WatchDelete
+)
+
+// Watcher is implemented by DirectoryHelpers which can watch for changes more efficiently than polling e.g.
+// LocalFileHelper (using inotify on linux) and gcs.GcsHelper (using generations or Pub/Sub notifications).
+type Watcher interface {
+	// Watch reports changes to the file uri or, if uri is a directory, to the files inside it including those in
+	// subdirectories. Directories themselves aren't reported. The channel is closed when ctx is done.
+	Watch(ctx context.Context, uri string, opts *WatchOptions) (<-chan WatchEvent, error)
+}
+
+// Watch reports changes to the file uri or the files inside the directory uri; see Watcher. If h doesn't implement
+// Watcher the changes are found by polling Stat and List.
+func Watch(ctx context.Context, h DirectoryHelper, uri string, opts *WatchOptions) (<-chan WatchEvent, error) {
+	if w, ok := h.(Watcher); ok {
+		return w.Watch(ctx, uri, opts)
+	}
+	return helpers.PollWatch(ctx, opts, func(ctx context.Context) (map[string]*FileInfo, error) {
+		return snapshot(ctx, h, uri)
+	})
+}
+
+// snapshot returns uri if it is a file otherwise the files inside it.
+func snapshot(ctx context.Context, h DirectoryHelper, uri string) (map[string]*FileInfo, error) {
+	results := map[string]*FileInfo{}
+	exists, err := h.ExistsContext(ctx, uri)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		info, err := h.Stat(ctx, uri)
+		if err != nil {
+			return nil, err
+		}
+		results[info.URI] = info
+		return results, nil
+	}
+	infos, err := h.List(ctx, uri)
+	if err != nil {
+		return nil, err
+	}
+	for _, info := range infos {
+		results[info.URI] = info
+	}
+	return results, nil
+}
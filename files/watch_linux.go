@@ -0,0 +1,200 @@
+//go:build linux
+
+package files
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"github.com/go-logr/zapr"
+	"github.com/jlewi/monogo/helpers"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+const inotifyMask = syscall.IN_CREATE | syscall.IN_MODIFY | syscall.IN_CLOSE_WRITE | syscall.IN_DELETE |
+	syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO
+
+// Watch reports changes to the local file or directory uri using inotify. Subdirectories of a directory are
+// watched as they are created. A file is watched via its parent directory, which must exist, so the file is
+// reported if it is created and replacing it, e.g. by renaming a new file into place as editors and
+// AtomicFileWriter do, is reported as a modification. opts.PollInterval isn't used.
+//
+// N.B. Files inside a directory that is moved out of the watched directory aren't reported as deleted.
+func (h *LocalFileHelper) Watch(ctx context.Context, uri string, opts *WatchOptions) (<-chan WatchEvent, error) {
+	root := filepath.Clean(strings.TrimPrefix(uri, FileScheme+"://"))
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to initialize inotify to watch %v", root)
+	}
+	// Since the descriptor is non-blocking the file uses the runtime's poller so closing it interrupts Read.
+	w := &inotifyWatcher{
+		root:  root,
+		f:     os.NewFile(uintptr(fd), "inotify"),
+		fd:    fd,
+		dirs:  map[int32]string{},
+		known: map[string]bool{},
+	}
+
+	info, err := os.Stat(root)
+	switch {
+	case err == nil && info.IsDir():
+		var files []*FileInfo
+		files, err = h.List(ctx, root)
+		for _, f := range files {
+			w.known[f.URI] = true
+		}
+		if err == nil {
+			err = w.addDirs(root)
+		}
+	case err == nil || os.IsNotExist(err):
+		w.file = true
+		w.known[root] = err == nil
+		err = w.add(filepath.Dir(root))
+	}
+	if err != nil {
+		helpers.IgnoreError(w.f.Close())
+		return nil, errors.Wrapf(err, "Failed to watch %v", root)
+	}
+
+	go func() {
+		<-ctx.Done()
+		helpers.IgnoreError(w.f.Close())
+	}()
+	events := make(chan WatchEvent)
+	go w.run(ctx, events)
+	return helpers.Debounce(ctx, events, opts), nil
+}
+
+// inotifyWatcher reads events from an inotify instance.
+type inotifyWatcher struct {
+	root string
+	// file is true if root is a file rather than a directory; in which case its parent directory is watched.
+	file bool
+	f    *os.File
+	fd   int
+	// dirs maps watch descriptors to the directories they watch.
+	dirs map[int32]string
+	// known are the files that exist; it is used to tell whether a file that is created, e.g. by renaming another
+	// file, replaced an existing file.
+	known map[string]bool
+}
+
+func (w *inotifyWatcher) add(dir string) error {
+	wd, err := syscall.InotifyAddWatch(w.fd, dir, inotifyMask)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to add inotify watch for %v", dir)
+	}
+	w.dirs[int32(wd)] = dir
+	return nil
+}
+
+// addDirs watches dir and all its subdirectories.
+func (w *inotifyWatcher) addDirs(dir string) error {
+	return filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		return w.add(p)
+	})
+}
+
+func (w *inotifyWatcher) run(ctx context.Context, events chan<- WatchEvent) {
+	log := zapr.NewLogger(zap.L())
+	defer close(events)
+	send := func(e WatchEvent) bool {
+		select {
+		case events <- e:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	for {
+		n, err := w.f.Read(buf)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Error(err, "Failed to read inotify events", "path", w.root)
+			}
+			return
+		}
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			raw := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			start := offset + syscall.SizeofInotifyEvent
+			offset = start + int(raw.Len)
+			name := strings.TrimRight(string(buf[start:offset]), "\x00")
+
+			if raw.Mask&syscall.IN_Q_OVERFLOW != 0 {
+				log.Info("inotify queue overflowed; some changes weren't reported", "path", w.root)
+				continue
+			}
+			if raw.Mask&syscall.IN_IGNORED != 0 {
+				delete(w.dirs, raw.Wd)
+				continue
+			}
+			dir, ok := w.dirs[raw.Wd]
+			if !ok || name == "" {
+				continue
+			}
+			p := filepath.Join(dir, name)
+			if w.file && p != w.root {
+				continue
+			}
+
+			if raw.Mask&syscall.IN_ISDIR != 0 {
+				if w.file || raw.Mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) == 0 {
+					continue
+				}
+				// Watch the new directory and report the files that were added to it before the watch was added.
+				if err := w.addDirs(p); err != nil {
+					log.Error(err, "Failed to watch new directory", "path", p)
+				}
+				files, err := (&LocalFileHelper{}).List(ctx, p)
+				if err != nil {
+					log.Error(err, "Failed to list new directory", "path", p)
+				}
+				for _, info := range files {
+					w.known[info.URI] = true
+					if !send(WatchEvent{URI: info.URI, Op: WatchCreate, Info: info}) {
+						return
+					}
+				}
+				continue
+			}
+
+			e := WatchEvent{URI: p}
+			switch {
+			case raw.Mask&(syscall.IN_DELETE|syscall.IN_MOVED_FROM) != 0:
+				e.Op = WatchDelete
+				delete(w.known, p)
+			case raw.Mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0 && !w.known[p]:
+				e.Op = WatchCreate
+				w.known[p] = true
+			default:
+				e.Op = WatchModify
+				w.known[p] = true
+			}
+			if e.Op != WatchDelete {
+				info, err := os.Stat(p)
+				if err != nil {
+					// The file was removed before we processed the event; the delete is reported separately.
+					continue
+				}
+				e.Info = localFileInfo(p, info)
+			}
+			if !send(e) {
+				return
+			}
+		}
+	}
+}
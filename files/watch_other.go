@@ -0,0 +1,39 @@
+//go:build !linux
+
+package files
+
+import (
+	"context"
+	"os"
+	"strings"
+
+	"github.com/jlewi/monogo/helpers"
+	"github.com/pkg/errors"
+)
+
+// Watch reports changes to the local file or directory uri. inotify is only available on linux so on other
+// platforms the changes are found by polling every opts.PollInterval.
+func (h *LocalFileHelper) Watch(ctx context.Context, uri string, opts *WatchOptions) (<-chan WatchEvent, error) {
+	root := strings.TrimPrefix(uri, FileScheme+"://")
+	return helpers.PollWatch(ctx, opts, func(ctx context.Context) (map[string]*FileInfo, error) {
+		results := map[string]*FileInfo{}
+		info, err := os.Stat(root)
+		switch {
+		case os.IsNotExist(err):
+			return results, nil
+		case err != nil:
+			return nil, errors.Wrapf(err, "Could not stat: %v", root)
+		case !info.IsDir():
+			results[root] = localFileInfo(root, info)
+			return results, nil
+		}
+		infos, err := h.List(ctx, root)
+		if err != nil {
+			return nil, err
+		}
+		for _, i := range infos {
+			results[i.URI] = i
+		}
+		return results, nil
+	})
+}
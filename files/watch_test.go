@@ -0,0 +1,147 @@
+package files
+
+import (
+	"context"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// nextEvents returns the next n events for uri or fails the test if they don't arrive in time. Events for other
+// files, e.g. the temporary files used by AtomicFileWriter, are ignored.
+func nextEvents(t *testing.T, events <-chan WatchEvent, uri string, n int) []string {
+	t.Helper()
+	results := []string{}
+	timeout := time.After(10 * time.Second)
+	for len(results) < n {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				t.Fatalf("The channel was closed; got %v", results)
+			}
+			if e.URI == uri {
+				results = append(results, e.Op.String()+" "+e.URI)
+			}
+		case <-timeout:
+			t.Fatalf("Timed out waiting for events; got %v", results)
+		}
+	}
+	return results
+}
+
+func Test_WatchLocal(t *testing.T) {
+	tDir, err := os.MkdirTemp("", "testWatchLocal")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(tDir)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	h := &LocalFileHelper{}
+	opts := &WatchOptions{Debounce: 50 * time.Millisecond, PollInterval: 10 * time.Millisecond}
+	dirEvents, err := Watch(ctx, h, tDir, opts)
+	if err != nil {
+		t.Fatalf("Watch failed; error: %v", err)
+	}
+	config := filepath.Join(tDir, "sub", "config.yaml")
+	if err := os.MkdirAll(filepath.Dir(config), 0755); err != nil {
+		t.Fatalf("Failed to create directory; error: %v", err)
+	}
+	// Give the watcher a chance to see the new directory before creating the file.
+	time.Sleep(100 * time.Millisecond)
+	fileEvents, err := Watch(ctx, h, config, opts)
+	if err != nil {
+		t.Fatalf("Watch failed; error: %v", err)
+	}
+
+	// Each change is checked before making the next one; otherwise changes within the debounce interval are
+	// combined.
+	check := func(expected string) {
+		t.Helper()
+		for name, events := range map[string]<-chan WatchEvent{"file": fileEvents, "directory": dirEvents} {
+			if d := cmp.Diff([]string{expected}, nextEvents(t, events, config, 1)); d != "" {
+				t.Errorf("Unexpected events for the %v; diff:\n%v", name, d)
+			}
+		}
+	}
+	writeFile(t, h, config, "a: 1")
+	check("create " + config)
+	writeFile(t, h, config, "a: 2")
+	check("modify " + config)
+	if err := os.Remove(config); err != nil {
+		t.Fatalf("Remove failed; error: %v", err)
+	}
+	check("delete " + config)
+
+	cancel()
+	for range fileEvents {
+	}
+}
+
+func Test_WatchPolling(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	h := NewMemFileHelper()
+	events, err := Watch(ctx, h, "mem://watch/dir", &WatchOptions{PollInterval: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("Watch failed; error: %v", err)
+	}
+
+	for _, contents := range []string{"1", "2"} {
+		writeFile(t, h, "mem://watch/dir/file.txt", contents)
+		time.Sleep(200 * time.Millisecond)
+	}
+	expected := []string{"create mem://watch/dir/file.txt", "modify mem://watch/dir/file.txt"}
+	if d := cmp.Diff(expected, nextEvents(t, events, "mem://watch/dir/file.txt", 2)); d != "" {
+		t.Errorf("Unexpected events; diff:\n%v", d)
+	}
+}
+
+// watchingHelper records whether Watch was called.
+type watchingHelper struct {
+	*MemFileHelper
+	watched bool
+}
+
+func (w *watchingHelper) Watch(ctx context.Context, uri string, opts *WatchOptions) (<-chan WatchEvent, error) {
+	w.watched = true
+	c := make(chan WatchEvent)
+	close(c)
+	return c, nil
+}
+
+// Test_WatchWrapped checks that the helpers returned by a Factory with a Cache and TransparentCompression still
+// use the underlying Watcher rather than polling.
+func Test_WatchWrapped(t *testing.T) {
+	defer resetRegistry()
+	tDir, err := os.MkdirTemp("", "testWatchWrapped")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(tDir)
+	cache, err := NewCache(tDir, 0)
+	if err != nil {
+		t.Fatalf("NewCache failed; error: %v", err)
+	}
+
+	w := &watchingHelper{MemFileHelper: NewMemFileHelper()}
+	if err := RegisterDirectoryHelper("watching", func(ctx context.Context, f *Factory, u *url.URL) (DirectoryHelper, error) { return w, nil }); err != nil {
+		t.Fatalf("RegisterDirectoryHelper failed; error: %v", err)
+	}
+	f := &Factory{Cache: cache, TransparentCompression: true}
+	h, err := f.GetDirHelper("watching://dir")
+	if err != nil {
+		t.Fatalf("GetDirHelper failed; error: %v", err)
+	}
+	if _, err := Watch(context.Background(), h, "watching://dir", nil); err != nil {
+		t.Fatalf("Watch failed; error: %v", err)
+	}
+	if !w.watched {
+		t.Errorf("Watch should use the Watcher wrapped by the Factory's helpers")
+	}
+}
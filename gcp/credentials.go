@@ -92,6 +92,7 @@ func NewWebFlowHelper(oAuthClientFile string, scopes []string) (*WebFlowHelper,
 		return nil, err
 
 	}
+	defer reader.Close()
 	b, err := io.ReadAll(reader)
 
 	if err != nil {
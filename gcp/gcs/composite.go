@@ -0,0 +1,259 @@
+package gcs
+
+import (
+	"context"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/go-logr/zapr"
+	"github.com/jlewi/monogo/helpers"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultCompositeChunkSize = 64 << 20
+	// maxComposeComponents is the maximum number of objects that can be composed in a single request.
+	maxComposeComponents = 32
+	// cleanupTimeout bounds how long we spend deleting temporary objects after a composite upload.
+	cleanupTimeout = time.Minute
+)
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// CompositeUploadOptions controls UploadComposite. The zero value uses the defaults.
+type CompositeUploadOptions struct {
+	// ChunkSize is the size of each part in bytes. Defaults to 64MiB.
+	ChunkSize int64
+	// Workers is the number of parts uploaded concurrently. Defaults to 8.
+	Workers int
+	// MaxAttempts is the number of times to try uploading each part. Defaults to 3.
+	MaxAttempts int
+	// InitialBackoff is how long to wait before the first retry; it doubles after each attempt. Defaults to 1s.
+	InitialBackoff time.Duration
+	// WriteOptions control the final object; see NewWriterWithOptions. Preconditions are applied when the parts
+	// are composed into the final object.
+	WriteOptions *WriteOptions
+}
+
+// UploadComposite uploads the local file to dstURI using a parallel composite upload. The file is split into
+// chunks which are uploaded concurrently as temporary objects and then combined using compose. This is much faster
+// than a single stream for large files. Since a compose request is limited to 32 components, files with more parts
+// are composed in a tree; i.e. groups of parts are composed into intermediate objects which are then composed.
+//
+// Each part is uploaded with its CRC32C so GCS rejects corrupted parts and the CRC32C of the final object is checked
+// against the local file. The temporary objects are stored next to dstURI and are deleted whether or not the upload
+// succeeds.
+//
+// N.B. Like all composite objects the final object doesn't have an MD5 hash; use the CRC32C to validate it.
+func (h *GcsHelper) UploadComposite(ctx context.Context, localFile string, dstURI string, opts *CompositeUploadOptions) (*helpers.FileInfo, error) {
+	log := zapr.NewLogger(zap.L())
+	if opts == nil {
+		opts = &CompositeUploadOptions{}
+	}
+	chunkSize := opts.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = defaultCompositeChunkSize
+	}
+	wOpts := opts.WriteOptions
+	if wOpts == nil {
+		wOpts = &WriteOptions{}
+	}
+	if wOpts.DoesNotExist && wOpts.IfGenerationMatch != 0 {
+		return nil, errors.Errorf("Invalid options for %v; DoesNotExist and IfGenerationMatch are mutually exclusive", dstURI)
+	}
+
+	dst, err := Parse(dstURI)
+	if err != nil {
+		return nil, err
+	}
+	if dst.Path == "" || dst.Generation != 0 {
+		return nil, errors.Errorf("Can't upload to %v; the URI must be an object without a generation", dstURI)
+	}
+
+	f, err := os.Open(localFile)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to open %v", localFile)
+	}
+	defer helpers.DeferIgnoreError(f.Close)
+	info, err := f.Stat()
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to stat %v", localFile)
+	}
+
+	fileCRC := crc32.New(crc32cTable)
+	if _, err := io.Copy(fileCRC, f); err != nil {
+		return nil, errors.Wrapf(err, "Failed to compute the CRC32C of %v", localFile)
+	}
+
+	suffix, err := helpers.RandString(8)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to generate a name for the temporary objects")
+	}
+	tmpPrefix := fmt.Sprintf("%v.composite-%v/", dst.Path, suffix)
+	b := h.Client.Bucket(dst.Bucket)
+
+	// temps are the temporary objects that need to be deleted.
+	temps := []string{}
+	tempsMu := sync.Mutex{}
+	addTemp := func(name string) {
+		tempsMu.Lock()
+		defer tempsMu.Unlock()
+		temps = append(temps, name)
+	}
+	defer func() {
+		// Use a new context so the temporary objects are deleted even if ctx was cancelled.
+		cCtx, cancel := context.WithTimeout(context.Background(), cleanupTimeout)
+		defer cancel()
+		for _, name := range temps {
+			if err := b.Object(name).Delete(cCtx); err != nil && !errors.Is(err, storage.ErrObjectNotExist) {
+				log.Error(err, "Failed to delete temporary object", "object", (&GcsPath{Bucket: dst.Bucket, Path: name}).ToURI())
+			}
+		}
+	}()
+
+	numParts := int((info.Size() + chunkSize - 1) / chunkSize)
+	if numParts == 0 {
+		numParts = 1
+	}
+	parts := make([]string, numParts)
+	for i := range parts {
+		parts[i] = fmt.Sprintf("%vpart-%06d", tmpPrefix, i)
+	}
+
+	retryOpts := &TransferOptions{MaxAttempts: opts.MaxAttempts, InitialBackoff: opts.InitialBackoff}
+	uploadPart := func(i int) error {
+		offset := int64(i) * chunkSize
+		size := chunkSize
+		if offset+size > info.Size() {
+			size = info.Size() - offset
+		}
+		addTemp(parts[i])
+		return retry(ctx, retryOpts, func() error {
+			return h.uploadPart(ctx, b.Object(parts[i]), io.NewSectionReader(f, offset, size))
+		})
+	}
+	if err := runParallel(ctx, numParts, opts.Workers, uploadPart); err != nil {
+		return nil, errors.Wrapf(err, "Failed to upload the parts of %v to %v", localFile, dstURI)
+	}
+
+	// Compose the parts in a tree until there are few enough to compose into the final object.
+	for level := 0; len(parts) > maxComposeComponents; level++ {
+		next := []string{}
+		for start := 0; start < len(parts); start += maxComposeComponents {
+			end := start + maxComposeComponents
+			if end > len(parts) {
+				end = len(parts)
+			}
+			name := fmt.Sprintf("%vcompose-%d-%06d", tmpPrefix, level, len(next))
+			addTemp(name)
+			if _, err := newComposer(b, b.Object(name), parts[start:end]).Run(ctx); err != nil {
+				return nil, errors.Wrapf(err, "Failed to compose the parts of %v", dstURI)
+			}
+			next = append(next, name)
+		}
+		parts = next
+	}
+
+	o := b.Object(dst.Path)
+	switch {
+	case wOpts.DoesNotExist:
+		o = o.If(storage.Conditions{DoesNotExist: true})
+	case wOpts.IfGenerationMatch != 0:
+		o = o.If(storage.Conditions{GenerationMatch: wOpts.IfGenerationMatch})
+	}
+	c := newComposer(b, o, parts)
+	c.ContentType = string(wOpts.ContentType)
+	c.CacheControl = wOpts.CacheControl
+	c.Metadata = wOpts.Metadata
+	c.CRC32C = fileCRC.Sum32()
+	c.SendCRC32C = true
+	attrs, err := c.Run(ctx)
+	if err != nil {
+		return nil, errors.Wrapf(classifyError(dstURI, err), "Failed to compose %v", dstURI)
+	}
+	if attrs.CRC32C != fileCRC.Sum32() {
+		return nil, errors.Errorf("CRC32C of %v is %08x but the CRC32C of %v is %08x", dstURI, attrs.CRC32C, localFile, fileCRC.Sum32())
+	}
+	return objectInfo(attrs), nil
+}
+
+// uploadPart uploads r to the object o sending its CRC32C so GCS verifies it.
+func (h *GcsHelper) uploadPart(ctx context.Context, o *storage.ObjectHandle, r *io.SectionReader) error {
+	c := crc32.New(crc32cTable)
+	if _, err := io.Copy(c, r); err != nil {
+		return errors.Wrapf(err, "Failed to read part %v", o.ObjectName())
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return errors.Wrapf(err, "Failed to read part %v", o.ObjectName())
+	}
+
+	// Cancelling the context aborts the upload so a partial object is never created.
+	wCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	w := o.NewWriter(wCtx)
+	w.CRC32C = c.Sum32()
+	w.SendCRC32C = true
+	if _, err := io.Copy(w, r); err != nil {
+		cancel()
+		helpers.IgnoreError(w.Close())
+		return errors.Wrapf(err, "Failed to upload part %v", o.ObjectName())
+	}
+	if err := w.Close(); err != nil {
+		return errors.Wrapf(err, "Failed to upload part %v", o.ObjectName())
+	}
+	return nil
+}
+
+// newComposer returns a Composer that composes the objects srcs into dst.
+func newComposer(b *storage.BucketHandle, dst *storage.ObjectHandle, srcs []string) *storage.Composer {
+	handles := make([]*storage.ObjectHandle, 0, len(srcs))
+	for _, s := range srcs {
+		handles = append(handles, b.Object(s))
+	}
+	return dst.ComposerFrom(handles...)
+}
+
+// runParallel calls f for 0 <= i < n using the given number of workers. It stops starting new calls after the
+// first error, which it returns.
+func runParallel(ctx context.Context, n int, workers int, f func(i int) error) error {
+	if workers <= 0 {
+		workers = defaultTransferWorkers
+	}
+	work := make(chan int)
+	errs := make(chan error, n)
+	wg := sync.WaitGroup{}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				if err := f(i); err != nil {
+					errs <- err
+				}
+			}
+		}()
+	}
+
+	var err error
+	for i := 0; i < n && err == nil; i++ {
+		select {
+		case work <- i:
+		case err = <-errs:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+	}
+	close(work)
+	wg.Wait()
+	close(errs)
+	if err != nil {
+		return err
+	}
+	return <-errs
+}
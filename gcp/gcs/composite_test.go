@@ -0,0 +1,136 @@
+package gcs
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_UploadComposite(t *testing.T) {
+	h, srv := newTestHelper(t)
+	srv.CreateBucket("bucket")
+	ctx := context.Background()
+
+	tDir, err := os.MkdirTemp("", "testUploadComposite")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(tDir)
+
+	type testCase struct {
+		name      string
+		size      int
+		chunkSize int64
+	}
+
+	cases := []testCase{
+		{
+			name:      "single-part",
+			size:      5,
+			chunkSize: 10,
+		},
+		{
+			name:      "flat",
+			size:      95,
+			chunkSize: 10,
+		},
+		{
+			// 100 parts requires two levels of composition.
+			name:      "tree",
+			size:      1000,
+			chunkSize: 10,
+		},
+		{
+			name:      "empty",
+			size:      0,
+			chunkSize: 10,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			data := []byte(strings.Repeat("0123456789abcdefghij", c.size/20+1)[:c.size])
+			local := filepath.Join(tDir, c.name)
+			if err := os.WriteFile(local, data, 0644); err != nil {
+				t.Fatalf("Failed to write %v; error: %v", local, err)
+			}
+
+			dst := "gs://bucket/" + c.name + "/big.bin"
+			opts := &CompositeUploadOptions{
+				ChunkSize:    c.chunkSize,
+				Workers:      4,
+				WriteOptions: &WriteOptions{ContentType: "application/octet-stream"},
+			}
+			info, err := h.UploadComposite(ctx, local, dst, opts)
+			if err != nil {
+				t.Fatalf("UploadComposite failed; error: %v", err)
+			}
+			if info.Size != int64(c.size) {
+				t.Errorf("Got size %v; want %v", info.Size, c.size)
+			}
+			if info.ContentType != "application/octet-stream" {
+				t.Errorf("Got ContentType %v; want application/octet-stream", info.ContentType)
+			}
+
+			actual, ok := srv.ReadObject("bucket", c.name+"/big.bin")
+			if !ok {
+				t.Fatalf("%v wasn't created", dst)
+			}
+			if d := cmp.Diff(data, actual); d != "" {
+				t.Errorf("Unexpected contents; diff:\n%v", d)
+			}
+
+			// The temporary objects should have been deleted.
+			objects, err := ListObjectsWithPrefix(ctx, h.Client, "gs://bucket/"+c.name+"/")
+			if err != nil {
+				t.Fatalf("ListObjectsWithPrefix failed; error: %v", err)
+			}
+			if d := cmp.Diff([]string{dst}, objects); d != "" {
+				t.Errorf("Unexpected objects; diff:\n%v", d)
+			}
+		})
+	}
+}
+
+func Test_UploadCompositeCleanup(t *testing.T) {
+	h, srv := newTestHelper(t)
+	srv.WriteObject("bucket", "exists/big.bin", []byte("original"))
+	ctx := context.Background()
+
+	tDir, err := os.MkdirTemp("", "testUploadCompositeCleanup")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(tDir)
+	local := filepath.Join(tDir, "big.bin")
+	if err := os.WriteFile(local, []byte(strings.Repeat("a", 100)), 0644); err != nil {
+		t.Fatalf("Failed to write %v; error: %v", local, err)
+	}
+
+	// The precondition fails when the parts are composed into the final object.
+	opts := &CompositeUploadOptions{
+		ChunkSize:    10,
+		WriteOptions: &WriteOptions{DoesNotExist: true},
+	}
+	_, err = h.UploadComposite(ctx, local, "gs://bucket/exists/big.bin", opts)
+	if !errors.Is(err, ErrPreconditionFailed) {
+		t.Fatalf("Got error %v; want ErrPreconditionFailed", err)
+	}
+
+	actual, _ := srv.ReadObject("bucket", "exists/big.bin")
+	if string(actual) != "original" {
+		t.Errorf("Object was overwritten; got %v", string(actual))
+	}
+	objects, err := ListObjectsWithPrefix(ctx, h.Client, "gs://bucket/")
+	if err != nil {
+		t.Fatalf("ListObjectsWithPrefix failed; error: %v", err)
+	}
+	if d := cmp.Diff([]string{"gs://bucket/exists/big.bin"}, objects); d != "" {
+		t.Errorf("Temporary objects weren't deleted; diff:\n%v", d)
+	}
+}
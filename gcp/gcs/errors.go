@@ -0,0 +1,78 @@
+package gcs
+
+import (
+	"net/http"
+
+	"cloud.google.com/go/storage"
+	"github.com/pkg/errors"
+	"google.golang.org/api/googleapi"
+)
+
+// ErrorKind classifies the errors returned by GcsHelper. Use errors.Is to check for a kind e.g.
+//
+//	if errors.Is(err, gcs.ErrBucketNotFound) {...}
+type ErrorKind string
+
+const (
+	// ErrBucketNotFound means the bucket doesn't exist.
+	ErrBucketNotFound ErrorKind = "bucket doesn't exist"
+	// ErrObjectNotFound means the bucket exists but the object doesn't.
+	ErrObjectNotFound ErrorKind = "object doesn't exist"
+	// ErrPermissionDenied means the caller isn't allowed to access the bucket or object.
+	ErrPermissionDenied ErrorKind = "permission denied"
+	// ErrPreconditionFailed means a precondition such as an expected generation wasn't met.
+	ErrPreconditionFailed ErrorKind = "precondition failed"
+)
+
+func (k ErrorKind) Error() string {
+	return string(k)
+}
+
+// Error is returned by GcsHelper when the underlying error could be classified.
+type Error struct {
+	Kind ErrorKind
+	// URI is the URI of the bucket or object that caused the error.
+	URI string
+	// Err is the error returned by the storage client.
+	Err error
+}
+
+func (e *Error) Error() string {
+	return string(e.Kind) + ": " + e.URI + ": " + e.Err.Error()
+}
+
+// Unwrap returns the error returned by the storage client.
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is the Kind of the error.
+func (e *Error) Is(target error) bool {
+	k, ok := target.(ErrorKind)
+	return ok && k == e.Kind
+}
+
+// classifyError converts err into an *Error if it can be classified; otherwise err is returned unchanged.
+func classifyError(uri string, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	kind := ErrorKind("")
+	gErr := &googleapi.Error{}
+	switch {
+	case errors.Is(err, storage.ErrBucketNotExist):
+		kind = ErrBucketNotFound
+	case errors.Is(err, storage.ErrObjectNotExist):
+		kind = ErrObjectNotFound
+	case errors.As(err, &gErr) && (gErr.Code == http.StatusForbidden || gErr.Code == http.StatusUnauthorized):
+		kind = ErrPermissionDenied
+	case errors.As(err, &gErr) && gErr.Code == http.StatusPreconditionFailed:
+		kind = ErrPreconditionFailed
+	case errors.As(err, &gErr) && gErr.Code == http.StatusNotFound:
+		kind = ErrObjectNotFound
+	default:
+		return err
+	}
+	return &Error{Kind: kind, URI: uri, Err: err}
+}
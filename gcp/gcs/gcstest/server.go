@@ -0,0 +1,1131 @@
+// Package gcstest provides an in-process fake of the GCS JSON API for tests.
+//
+// The fake is an HTTP server so the real storage client can be pointed at it e.g.
+//
+//	srv := gcstest.NewServer()
+//	defer srv.Close()
+//	client, err := srv.Client(ctx)
+//
+// Only the subset of the API used by the gcs package is implemented:
+//   - buckets: get
+//   - objects: get, list, delete, patch, rewrite and compose
+//   - uploads: media, multipart and resumable
+//   - downloads: JSON API (alt=media) and XML API style reads, including range reads
+//   - generation and metageneration preconditions e.g. ifGenerationMatch
+//   - object versioning; see EnableVersioning
+//
+// Objects are kept in memory. Injected errors with 5xx or 429 status codes will be retried by the storage client
+// so tests should stick to 4xx codes.
+package gcstest
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/jlewi/monogo/util"
+	"google.golang.org/api/option"
+)
+
+const (
+	// defaultMaxResults is the default page size for list requests; it matches the real API.
+	defaultMaxResults = 1000
+	// maxComposeSources is the maximum number of sources in a compose request.
+	maxComposeSources = 32
+)
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Server is a fake GCS server.
+type Server struct {
+	// URL is the base URL of the server e.g. http://127.0.0.1:1234
+	URL string
+
+	srv *httptest.Server
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	// errs maps a bucket or bucket/object to the HTTP status code to return for requests to it.
+	errs map[string]int
+	// uploads are the in progress resumable uploads keyed by upload id.
+	uploads map[string]*upload
+	// generation is used to assign increasing generation numbers to objects.
+	generation int64
+	nextUpload int
+	// listed is the number of objects returned by objects.list.
+	listed int
+}
+
+type bucket struct {
+	name    string
+	objects map[string]*object
+	// versioning is true if object versioning is enabled; replaced and deleted objects are then kept in noncurrent.
+	versioning bool
+	// noncurrent are the noncurrent versions of each object ordered by generation.
+	noncurrent map[string][]*object
+}
+
+type object struct {
+	bucket          string
+	name            string
+	data            []byte
+	contentType     string
+	contentEncoding string
+	cacheControl    string
+	metadata        map[string]string
+	md5             []byte
+	crc32c          uint32
+	componentCount  int
+	generation      int64
+	metageneration  int64
+	created         time.Time
+	updated         time.Time
+	// deleted is when the object became noncurrent; it is zero for live objects.
+	deleted time.Time
+}
+
+// upload is an in progress resumable upload.
+type upload struct {
+	bucket string
+	meta   *objectResource
+	// params are the query parameters of the request that started the upload; they hold any preconditions.
+	params url.Values
+	data   []byte
+}
+
+// objectResource is the JSON representation of an object used in requests.
+type objectResource struct {
+	Name            string            `json:"name"`
+	ContentType     string            `json:"contentType"`
+	ContentEncoding string            `json:"contentEncoding"`
+	CacheControl    string            `json:"cacheControl"`
+	Metadata        map[string]string `json:"metadata"`
+	MD5Hash         string            `json:"md5Hash"`
+	CRC32C          string            `json:"crc32c"`
+}
+
+// NewServer starts a new fake server listening on a free port on localhost.
+func NewServer() *Server {
+	s := &Server{
+		buckets: map[string]*bucket{},
+		errs:    map[string]int{},
+		uploads: map[string]*upload{},
+	}
+	s.srv = httptest.NewServer(http.HandlerFunc(s.handle))
+	s.URL = s.srv.URL
+	return s
+}
+
+// Close stops the server.
+func (s *Server) Close() {
+	s.srv.Close()
+}
+
+// Client returns a storage client that talks to the server.
+func (s *Server) Client(ctx context.Context) (*storage.Client, error) {
+	return storage.NewClient(ctx, option.WithEndpoint(s.URL+"/storage/v1/"), option.WithoutAuthentication(), storage.WithJSONReads())
+}
+
+// CreateBucket creates a bucket. It is a no-op if the bucket already exists.
+func (s *Server) CreateBucket(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.createBucket(name)
+}
+
+// createBucket creates the bucket if it doesn't exist. The caller must hold the lock.
+func (s *Server) createBucket(name string) *bucket {
+	if b, ok := s.buckets[name]; ok {
+		return b
+	}
+	b := &bucket{name: name, objects: map[string]*object{}, noncurrent: map[string][]*object{}}
+	s.buckets[name] = b
+	return b
+}
+
+// EnableVersioning enables object versioning on the bucket; the bucket is created if it doesn't exist.
+func (s *Server) EnableVersioning(bucketName string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.createBucket(bucketName).versioning = true
+}
+
+// WriteObject creates or replaces an object; the bucket is created if it doesn't exist.
+func (s *Server) WriteObject(bucketName string, name string, data []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b := s.createBucket(bucketName)
+	s.putObject(b, &objectResource{Name: name}, data)
+}
+
+// ReadObject returns the contents of an object and whether it exists.
+func (s *Server) ReadObject(bucketName string, name string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.buckets[bucketName]
+	if !ok {
+		return nil, false
+	}
+	o, ok := b.objects[name]
+	if !ok {
+		return nil, false
+	}
+	return append([]byte{}, o.data...), true
+}
+
+// InjectError causes all requests for the object to fail with the HTTP status code e.g. http.StatusForbidden.
+// If name is empty the error applies to the bucket and every object in it. A code of 0 removes the error.
+func (s *Server) InjectError(bucketName string, name string, code int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := errKey(bucketName, name)
+	if code == 0 {
+		delete(s.errs, key)
+		return
+	}
+	s.errs[key] = code
+}
+
+// ObjectsListed returns the number of objects returned by all the objects.list requests so far. Prefixes aren't
+// counted. Tests can use it to check that listings are narrowed to the objects they need.
+func (s *Server) ObjectsListed() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.listed
+}
+
+func errKey(bucketName string, name string) string {
+	if name == "" {
+		return bucketName
+	}
+	return bucketName + "/" + name
+}
+
+// putObject stores the object. The caller must hold the lock.
+func (s *Server) putObject(b *bucket, meta *objectResource, data []byte) *object {
+	s.generation++
+	sum := md5.Sum(data)
+	now := time.Now().UTC()
+	o := &object{
+		bucket:          b.name,
+		name:            meta.Name,
+		data:            data,
+		contentType:     meta.ContentType,
+		contentEncoding: meta.ContentEncoding,
+		cacheControl:    meta.CacheControl,
+		metadata:        meta.Metadata,
+		md5:             sum[:],
+		crc32c:          crc32.Checksum(data, crc32cTable),
+		generation:      s.generation,
+		metageneration:  1,
+		created:         now,
+		updated:         now,
+	}
+	if old, ok := b.objects[meta.Name]; ok && b.versioning {
+		b.archive(old, now)
+	}
+	b.objects[meta.Name] = o
+	return o
+}
+
+// archive makes the live object o noncurrent. The caller must hold the lock.
+func (b *bucket) archive(o *object, now time.Time) {
+	o.deleted = now
+	b.noncurrent[o.name] = append(b.noncurrent[o.name], o)
+	delete(b.objects, o.name)
+}
+
+// handle dispatches requests based on the path. Object names are escaped in JSON API paths so the path is split
+// before it is unescaped.
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	segments := strings.Split(strings.TrimPrefix(r.URL.EscapedPath(), "/"), "/")
+	for i, seg := range segments {
+		v, err := url.PathUnescape(seg)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid path segment %q", seg))
+			return
+		}
+		segments[i] = v
+	}
+
+	// Read the body before taking the lock so slow clients don't block other requests.
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("Failed to read body: %v", err))
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	q := r.URL.Query()
+	switch {
+	case hasPrefix(segments, "storage", "v1", "b") && len(segments) >= 4:
+		s.handleJSON(w, r, segments[3], segments[4:], q, body)
+	case hasPrefix(segments, "upload", "storage", "v1", "b") && len(segments) == 6 && segments[5] == "o":
+		s.handleUpload(w, r, segments[4], q, body)
+	case hasPrefix(segments, "download", "storage", "v1", "b") && len(segments) == 7 && segments[5] == "o":
+		s.readObject(w, r, segments[4], segments[6])
+	case len(segments) >= 2 && r.Method == http.MethodGet:
+		// XML API style read i.e. /${bucket}/${object}
+		s.readObject(w, r, segments[0], strings.Join(segments[1:], "/"))
+	default:
+		writeError(w, http.StatusNotFound, fmt.Sprintf("Unsupported path %v", r.URL.Path))
+	}
+}
+
+func hasPrefix(segments []string, prefix ...string) bool {
+	if len(segments) < len(prefix) {
+		return false
+	}
+	for i, p := range prefix {
+		if segments[i] != p {
+			return false
+		}
+	}
+	return true
+}
+
+// handleJSON handles requests to /storage/v1/b/${bucket}/... rest is the path after the bucket.
+func (s *Server) handleJSON(w http.ResponseWriter, r *http.Request, bucketName string, rest []string, q url.Values, body []byte) {
+	switch {
+	case len(rest) == 0 && r.Method == http.MethodGet:
+		s.getBucket(w, bucketName)
+	case len(rest) == 1 && rest[0] == "o" && r.Method == http.MethodGet:
+		s.listObjects(w, bucketName, q)
+	case len(rest) == 2 && rest[0] == "o" && r.Method == http.MethodGet:
+		if q.Get("alt") == "media" {
+			s.readObject(w, r, bucketName, rest[1])
+			return
+		}
+		s.getObject(w, bucketName, rest[1], q)
+	case len(rest) == 2 && rest[0] == "o" && r.Method == http.MethodDelete:
+		s.deleteObject(w, bucketName, rest[1], q)
+	case len(rest) == 2 && rest[0] == "o" && r.Method == http.MethodPatch:
+		s.patchObject(w, bucketName, rest[1], q, body)
+	case len(rest) == 3 && rest[0] == "o" && rest[2] == "compose" && r.Method == http.MethodPost:
+		s.composeObject(w, bucketName, rest[1], q, body)
+	case len(rest) == 7 && rest[0] == "o" && rest[2] == "rewriteTo" && rest[3] == "b" && rest[5] == "o" && r.Method == http.MethodPost:
+		s.rewriteObject(w, bucketName, rest[1], rest[4], rest[6], q, body)
+	default:
+		writeError(w, http.StatusNotImplemented, fmt.Sprintf("%v %v isn't supported by the fake", r.Method, r.URL.Path))
+	}
+}
+
+// injectedError writes the injected error if there is one for the bucket or object and returns true.
+// The caller must hold the lock.
+func (s *Server) injectedError(w http.ResponseWriter, bucketName string, name string) bool {
+	for _, key := range []string{errKey(bucketName, ""), errKey(bucketName, name)} {
+		if code, ok := s.errs[key]; ok {
+			writeError(w, code, fmt.Sprintf("Injected error for %v", key))
+			return true
+		}
+	}
+	return false
+}
+
+// lookupBucket returns the bucket or writes an error. The caller must hold the lock.
+func (s *Server) lookupBucket(w http.ResponseWriter, bucketName string) (*bucket, bool) {
+	if s.injectedError(w, bucketName, "") {
+		return nil, false
+	}
+	b, ok := s.buckets[bucketName]
+	if !ok {
+		writeError(w, http.StatusNotFound, "The specified bucket does not exist.")
+		return nil, false
+	}
+	return b, true
+}
+
+// lookupObject returns the object or writes an error. The caller must hold the lock.
+func (s *Server) lookupObject(w http.ResponseWriter, bucketName string, name string) (*object, bool) {
+	if s.injectedError(w, bucketName, name) {
+		return nil, false
+	}
+	b, ok := s.lookupBucket(w, bucketName)
+	if !ok {
+		return nil, false
+	}
+	o, ok := b.objects[name]
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("No such object: %v/%v", bucketName, name))
+		return nil, false
+	}
+	return o, true
+}
+
+// lookupGeneration returns the generation of the object or writes an error. If generation is empty the live
+// object is returned. The caller must hold the lock.
+func (s *Server) lookupGeneration(w http.ResponseWriter, bucketName string, name string, generation string) (*object, bool) {
+	if generation == "" {
+		return s.lookupObject(w, bucketName, name)
+	}
+	if s.injectedError(w, bucketName, name) {
+		return nil, false
+	}
+	b, ok := s.lookupBucket(w, bucketName)
+	if !ok {
+		return nil, false
+	}
+	gen, err := strconv.ParseInt(generation, 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid generation %q", generation))
+		return nil, false
+	}
+	if o, ok := b.objects[name]; ok && o.generation == gen {
+		return o, true
+	}
+	for _, o := range b.noncurrent[name] {
+		if o.generation == gen {
+			return o, true
+		}
+	}
+	writeError(w, http.StatusNotFound, fmt.Sprintf("No such object: %v/%v#%v", bucketName, name, generation))
+	return nil, false
+}
+
+func (s *Server) getBucket(w http.ResponseWriter, bucketName string) {
+	if _, ok := s.lookupBucket(w, bucketName); !ok {
+		return
+	}
+	writeJSON(w, map[string]interface{}{
+		"kind": "storage#bucket",
+		"id":   bucketName,
+		"name": bucketName,
+	})
+}
+
+func (s *Server) getObject(w http.ResponseWriter, bucketName string, name string, q url.Values) {
+	o, ok := s.lookupGeneration(w, bucketName, name, q.Get("generation"))
+	if !ok || !checkPreconditions(w, q, o) {
+		return
+	}
+	writeJSON(w, o.resource())
+}
+
+// deleteObject deletes the object. Like the real API, if versioning is enabled deleting the live object without
+// specifying a generation makes it noncurrent; deleting a specific generation deletes it permanently.
+func (s *Server) deleteObject(w http.ResponseWriter, bucketName string, name string, q url.Values) {
+	o, ok := s.lookupGeneration(w, bucketName, name, q.Get("generation"))
+	if !ok || !checkPreconditions(w, q, o) {
+		return
+	}
+	b := s.buckets[bucketName]
+	switch {
+	case b.objects[name] != o:
+		versions := []*object{}
+		for _, v := range b.noncurrent[name] {
+			if v != o {
+				versions = append(versions, v)
+			}
+		}
+		b.noncurrent[name] = versions
+	case b.versioning && q.Get("generation") == "":
+		b.archive(o, time.Now().UTC())
+	default:
+		delete(b.objects, name)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) patchObject(w http.ResponseWriter, bucketName string, name string, q url.Values, body []byte) {
+	o, ok := s.lookupObject(w, bucketName, name)
+	if !ok || !checkPreconditions(w, q, o) {
+		return
+	}
+	patch := map[string]json.RawMessage{}
+	if err := json.Unmarshal(body, &patch); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid object resource: %v", err))
+		return
+	}
+	for field, dst := range map[string]*string{"contentType": &o.contentType, "cacheControl": &o.cacheControl, "contentEncoding": &o.contentEncoding} {
+		if v, ok := patch[field]; ok {
+			*dst = ""
+			_ = json.Unmarshal(v, dst)
+		}
+	}
+	if v, ok := patch["metadata"]; ok {
+		md := map[string]*string{}
+		_ = json.Unmarshal(v, &md)
+		if o.metadata == nil {
+			o.metadata = map[string]string{}
+		}
+		for k, val := range md {
+			if val == nil {
+				delete(o.metadata, k)
+				continue
+			}
+			o.metadata[k] = *val
+		}
+	}
+	o.metageneration++
+	o.updated = time.Now().UTC()
+	writeJSON(w, o.resource())
+}
+
+// listObjects implements objects.list. Items and prefixes are returned in lexicographic order and paged together.
+func (s *Server) listObjects(w http.ResponseWriter, bucketName string, q url.Values) {
+	b, ok := s.lookupBucket(w, bucketName)
+	if !ok {
+		return
+	}
+
+	prefix := q.Get("prefix")
+	delimiter := q.Get("delimiter")
+	startOffset := q.Get("startOffset")
+	endOffset := q.Get("endOffset")
+	includeTrailing := q.Get("includeTrailingDelimiter") == "true"
+	versions := q.Get("versions") == "true"
+
+	// matchGlob has the same syntax as util.Glob.
+	var glob *util.Glob
+	if p := q.Get("matchGlob"); p != "" {
+		var err error
+		glob, err = util.CompileGlob(p)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid matchGlob %q: %v", p, err))
+			return
+		}
+	}
+
+	maxResults := defaultMaxResults
+	if v := q.Get("maxResults"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 && n < maxResults {
+			maxResults = n
+		}
+	}
+
+	// entries are object names and prefixes; they share a namespace for paging.
+	type entry struct {
+		key    string
+		object *object
+	}
+	entries := []entry{}
+	prefixes := map[string]bool{}
+	candidates := []*object{}
+	for _, o := range b.objects {
+		candidates = append(candidates, o)
+	}
+	if versions {
+		for _, noncurrent := range b.noncurrent {
+			candidates = append(candidates, noncurrent...)
+		}
+	}
+	for _, o := range candidates {
+		name := o.name
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		if startOffset != "" && name < startOffset {
+			continue
+		}
+		if endOffset != "" && name >= endOffset {
+			continue
+		}
+		if glob != nil && !glob.Match(name) {
+			continue
+		}
+		if delimiter != "" {
+			if i := strings.Index(name[len(prefix):], delimiter); i >= 0 {
+				p := name[:len(prefix)+i+len(delimiter)]
+				if !prefixes[p] {
+					prefixes[p] = true
+					entries = append(entries, entry{key: p})
+				}
+				if !(includeTrailing && name == p) {
+					continue
+				}
+			}
+		}
+		key := name
+		if versions {
+			// Versions of an object are listed in order of generation.
+			key = fmt.Sprintf("%v\x00%020d", name, o.generation)
+		}
+		entries = append(entries, entry{key: key, object: o})
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].key == entries[j].key {
+			// With includeTrailingDelimiter an object can have the same name as a prefix; list the prefix first.
+			return entries[i].object == nil
+		}
+		return entries[i].key < entries[j].key
+	})
+
+	if token := q.Get("pageToken"); token != "" {
+		last, err := base64.StdEncoding.DecodeString(token)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid pageToken %q", token))
+			return
+		}
+		i := sort.Search(len(entries), func(i int) bool { return entries[i].key > string(last) })
+		entries = entries[i:]
+	}
+
+	resp := map[string]interface{}{
+		"kind": "storage#objects",
+	}
+	if len(entries) > maxResults {
+		entries = entries[:maxResults]
+		resp["nextPageToken"] = base64.StdEncoding.EncodeToString([]byte(entries[len(entries)-1].key))
+	}
+
+	items := []interface{}{}
+	pageprefixes := []string{}
+	for _, e := range entries {
+		if e.object == nil {
+			pageprefixes = append(pageprefixes, e.key)
+			continue
+		}
+		items = append(items, e.object.resource())
+	}
+	s.listed += len(items)
+	if len(items) > 0 {
+		resp["items"] = items
+	}
+	if len(pageprefixes) > 0 {
+		resp["prefixes"] = pageprefixes
+	}
+	writeJSON(w, resp)
+}
+
+// readObject writes the object's contents. Range requests are supported.
+func (s *Server) readObject(w http.ResponseWriter, r *http.Request, bucketName string, name string) {
+	o, ok := s.lookupGeneration(w, bucketName, name, r.URL.Query().Get("generation"))
+	if !ok || !checkPreconditions(w, r.URL.Query(), o) {
+		return
+	}
+
+	size := int64(len(o.data))
+	start, end, partial, err := parseRange(r.Header.Get("Range"), size)
+	if err != nil {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+		writeError(w, http.StatusRequestedRangeNotSatisfiable, err.Error())
+		return
+	}
+
+	h := w.Header()
+	contentType := o.contentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	h.Set("Content-Type", contentType)
+	if o.contentEncoding != "" {
+		h.Set("X-Goog-Stored-Content-Encoding", o.contentEncoding)
+	} else {
+		h.Set("X-Goog-Stored-Content-Encoding", "identity")
+	}
+	if o.cacheControl != "" {
+		h.Set("Cache-Control", o.cacheControl)
+	}
+	h.Set("Last-Modified", o.updated.Format(http.TimeFormat))
+	h.Set("ETag", o.etag())
+	h.Set("X-Goog-Generation", strconv.FormatInt(o.generation, 10))
+	h.Set("X-Goog-Metageneration", strconv.FormatInt(o.metageneration, 10))
+	h.Set("X-Goog-Stored-Content-Length", strconv.FormatInt(size, 10))
+	h.Set("X-Goog-Hash", o.hashHeader())
+	h.Set("Accept-Ranges", "bytes")
+	h.Set("Content-Length", strconv.FormatInt(end-start, 10))
+
+	if partial {
+		h.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end-1, size))
+		w.WriteHeader(http.StatusPartialContent)
+	} else {
+		w.WriteHeader(http.StatusOK)
+	}
+	_, _ = w.Write(o.data[start:end])
+}
+
+// parseRange parses a single range in the Range header. It returns the half open interval [start, end) to
+// return and whether the response is partial.
+func parseRange(header string, size int64) (int64, int64, bool, error) {
+	if header == "" {
+		return 0, size, false, nil
+	}
+	spec := strings.TrimPrefix(header, "bytes=")
+	if spec == header || strings.Contains(spec, ",") {
+		return 0, 0, false, fmt.Errorf("unsupported range %q", header)
+	}
+	first, last, ok := strings.Cut(spec, "-")
+	if !ok {
+		return 0, 0, false, fmt.Errorf("invalid range %q", header)
+	}
+
+	if first == "" {
+		// Suffix range i.e. the last n bytes.
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil {
+			return 0, 0, false, fmt.Errorf("invalid range %q", header)
+		}
+		if n > size {
+			n = size
+		}
+		return size - n, size, true, nil
+	}
+
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil {
+		return 0, 0, false, fmt.Errorf("invalid range %q", header)
+	}
+	end := size
+	if last != "" {
+		l, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || l < start {
+			return 0, 0, false, fmt.Errorf("invalid range %q", header)
+		}
+		if l+1 < size {
+			end = l + 1
+		}
+	}
+	if start >= size {
+		if size == 0 && start == 0 {
+			return 0, 0, false, nil
+		}
+		return 0, 0, false, fmt.Errorf("range %q not satisfiable for object of size %d", header, size)
+	}
+	return start, end, true, nil
+}
+
+// handleUpload implements media, multipart and resumable uploads to /upload/storage/v1/b/${bucket}/o.
+func (s *Server) handleUpload(w http.ResponseWriter, r *http.Request, bucketName string, q url.Values, body []byte) {
+	b, ok := s.lookupBucket(w, bucketName)
+	if !ok {
+		return
+	}
+
+	switch q.Get("uploadType") {
+	case "media":
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "media uploads must use POST")
+			return
+		}
+		meta := &objectResource{
+			Name:        q.Get("name"),
+			ContentType: r.Header.Get("Content-Type"),
+		}
+		s.finishUpload(w, b, meta, q, body)
+	case "multipart":
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "multipart uploads must use POST")
+			return
+		}
+		meta, data, err := parseMultipart(r.Header.Get("Content-Type"), body)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if meta.Name == "" {
+			meta.Name = q.Get("name")
+		}
+		s.finishUpload(w, b, meta, q, data)
+	case "resumable":
+		s.handleResumable(w, r, b, q, body)
+	default:
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("Unsupported uploadType %q", q.Get("uploadType")))
+	}
+}
+
+// handleResumable implements the resumable upload protocol. The initial POST creates a session and subsequent
+// PUTs upload chunks identified by Content-Range.
+func (s *Server) handleResumable(w http.ResponseWriter, r *http.Request, b *bucket, q url.Values, body []byte) {
+	if r.Method == http.MethodPost {
+		meta := &objectResource{}
+		if len(body) > 0 {
+			if err := json.Unmarshal(body, meta); err != nil {
+				writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid object resource: %v", err))
+				return
+			}
+		}
+		if meta.Name == "" {
+			meta.Name = q.Get("name")
+		}
+		if meta.ContentType == "" {
+			meta.ContentType = r.Header.Get("X-Upload-Content-Type")
+		}
+		s.nextUpload++
+		id := strconv.Itoa(s.nextUpload)
+		s.uploads[id] = &upload{bucket: b.name, meta: meta, params: q}
+		loc := url.Values{}
+		loc.Set("uploadType", "resumable")
+		loc.Set("upload_id", id)
+		w.Header().Set("Location", fmt.Sprintf("%v/upload/storage/v1/b/%v/o?%v", s.URL, url.PathEscape(b.name), loc.Encode()))
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	id := q.Get("upload_id")
+	u, ok := s.uploads[id]
+	if !ok || r.Method != http.MethodPut {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("No such upload %q", id))
+		return
+	}
+
+	// Content-Range is one of "bytes start-end/total", "bytes start-end/*" or "bytes */total".
+	total := int64(-1)
+	if cr := r.Header.Get("Content-Range"); cr != "" {
+		spec := strings.TrimPrefix(cr, "bytes ")
+		rng, t, _ := strings.Cut(spec, "/")
+		if t != "*" {
+			n, err := strconv.ParseInt(t, 10, 64)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid Content-Range %q", cr))
+				return
+			}
+			total = n
+		}
+		if rng != "*" {
+			first, _, _ := strings.Cut(rng, "-")
+			start, err := strconv.ParseInt(first, 10, 64)
+			if err != nil || start > int64(len(u.data)) {
+				writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid Content-Range %q", cr))
+				return
+			}
+			// Chunks may be resent; drop any data that is being overwritten.
+			u.data = append(u.data[:start], body...)
+		}
+	} else {
+		u.data = append(u.data, body...)
+		total = int64(len(u.data))
+	}
+
+	if total < 0 || int64(len(u.data)) < total {
+		if len(u.data) > 0 {
+			w.Header().Set("Range", fmt.Sprintf("bytes=0-%d", len(u.data)-1))
+		}
+		w.WriteHeader(http.StatusPermanentRedirect)
+		return
+	}
+
+	delete(s.uploads, id)
+	s.finishUpload(w, s.buckets[u.bucket], u.meta, u.params, u.data)
+}
+
+// finishUpload checks the preconditions, verifies any hashes supplied by the client and stores the object.
+func (s *Server) finishUpload(w http.ResponseWriter, b *bucket, meta *objectResource, q url.Values, data []byte) {
+	if meta.Name == "" {
+		writeError(w, http.StatusBadRequest, "Required object name is missing")
+		return
+	}
+	if s.injectedError(w, b.name, meta.Name) {
+		return
+	}
+	if !checkPreconditions(w, q, b.objects[meta.Name]) {
+		return
+	}
+	if meta.MD5Hash != "" {
+		sum := md5.Sum(data)
+		if meta.MD5Hash != base64.StdEncoding.EncodeToString(sum[:]) {
+			writeError(w, http.StatusBadRequest, "Provided MD5 hash doesn't match calculated MD5 hash.")
+			return
+		}
+	}
+	if meta.CRC32C != "" {
+		if meta.CRC32C != encodeCRC32C(crc32.Checksum(data, crc32cTable)) {
+			writeError(w, http.StatusBadRequest, "Provided CRC32C doesn't match calculated CRC32C.")
+			return
+		}
+	}
+	o := s.putObject(b, meta, data)
+	writeJSON(w, o.resource())
+}
+
+// parseMultipart parses a multipart/related upload; the first part is the metadata and the second the data.
+func parseMultipart(contentType string, body []byte) (*objectResource, []byte, error) {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil || !strings.HasPrefix(mediaType, "multipart/") {
+		return nil, nil, fmt.Errorf("multipart upload has Content-Type %q", contentType)
+	}
+	mr := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+
+	metaPart, err := mr.NextPart()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read metadata part: %v", err)
+	}
+	meta := &objectResource{}
+	if err := json.NewDecoder(metaPart).Decode(meta); err != nil {
+		return nil, nil, fmt.Errorf("invalid object resource: %v", err)
+	}
+
+	dataPart, err := mr.NextPart()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read data part: %v", err)
+	}
+	data, err := io.ReadAll(dataPart)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read data part: %v", err)
+	}
+	if meta.ContentType == "" {
+		meta.ContentType = dataPart.Header.Get("Content-Type")
+	}
+	return meta, data, nil
+}
+
+// rewriteObject implements objects.rewrite. The rewrite always completes in a single call.
+func (s *Server) rewriteObject(w http.ResponseWriter, srcBucket string, srcName string, dstBucket string, dstName string, q url.Values, body []byte) {
+	src, ok := s.lookupGeneration(w, srcBucket, srcName, q.Get("sourceGeneration"))
+	if !ok {
+		return
+	}
+	b, ok := s.lookupBucket(w, dstBucket)
+	if !ok {
+		return
+	}
+	if s.injectedError(w, dstBucket, dstName) {
+		return
+	}
+	if !checkPreconditions(w, q, b.objects[dstName]) {
+		return
+	}
+
+	meta := &objectResource{}
+	if len(body) > 0 {
+		if err := json.Unmarshal(body, meta); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid object resource: %v", err))
+			return
+		}
+	}
+	meta.Name = dstName
+	// Like the real API, metadata that isn't supplied is copied from the source.
+	if meta.ContentType == "" {
+		meta.ContentType = src.contentType
+	}
+	if meta.ContentEncoding == "" {
+		meta.ContentEncoding = src.contentEncoding
+	}
+	if meta.CacheControl == "" {
+		meta.CacheControl = src.cacheControl
+	}
+	if meta.Metadata == nil {
+		meta.Metadata = src.metadata
+	}
+
+	o := s.putObject(b, meta, append([]byte{}, src.data...))
+	size := strconv.Itoa(len(o.data))
+	writeJSON(w, map[string]interface{}{
+		"kind":                "storage#rewriteResponse",
+		"totalBytesRewritten": size,
+		"objectSize":          size,
+		"done":                true,
+		"resource":            o.resource(),
+	})
+}
+
+// composeObject implements objects.compose.
+func (s *Server) composeObject(w http.ResponseWriter, bucketName string, dstName string, q url.Values, body []byte) {
+	b, ok := s.lookupBucket(w, bucketName)
+	if !ok {
+		return
+	}
+	if s.injectedError(w, bucketName, dstName) {
+		return
+	}
+	if !checkPreconditions(w, q, b.objects[dstName]) {
+		return
+	}
+
+	req := struct {
+		SourceObjects []struct {
+			Name       string `json:"name"`
+			Generation string `json:"generation"`
+		} `json:"sourceObjects"`
+		Destination *objectResource `json:"destination"`
+	}{}
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid compose request: %v", err))
+		return
+	}
+	if len(req.SourceObjects) == 0 || len(req.SourceObjects) > maxComposeSources {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("The number of source components provided (%d) must be between 1 and %d", len(req.SourceObjects), maxComposeSources))
+		return
+	}
+
+	data := []byte{}
+	components := 0
+	for _, src := range req.SourceObjects {
+		o, ok := s.lookupGeneration(w, bucketName, src.Name, src.Generation)
+		if !ok {
+			return
+		}
+		data = append(data, o.data...)
+		if o.componentCount > 0 {
+			components += o.componentCount
+		} else {
+			components++
+		}
+	}
+
+	meta := req.Destination
+	if meta == nil {
+		meta = &objectResource{}
+	}
+	meta.Name = dstName
+	if meta.CRC32C != "" && meta.CRC32C != encodeCRC32C(crc32.Checksum(data, crc32cTable)) {
+		writeError(w, http.StatusBadRequest, "Provided CRC32C doesn't match calculated CRC32C.")
+		return
+	}
+	meta.MD5Hash = ""
+	o := s.putObject(b, meta, data)
+	// Like the real API composite objects don't have an MD5 hash.
+	o.md5 = nil
+	o.componentCount = components
+	writeJSON(w, o.resource())
+}
+
+// checkPreconditions writes an error and returns false if the generation preconditions in q aren't met. o is nil
+// if the object doesn't exist; its generation is then treated as 0 so ifGenerationMatch=0 means "doesn't exist".
+func checkPreconditions(w http.ResponseWriter, q url.Values, o *object) bool {
+	generation, metageneration := int64(0), int64(0)
+	if o != nil {
+		generation = o.generation
+		metageneration = o.metageneration
+	}
+
+	checks := []struct {
+		param  string
+		actual int64
+		match  bool
+	}{
+		{param: "ifGenerationMatch", actual: generation, match: true},
+		{param: "ifGenerationNotMatch", actual: generation, match: false},
+		{param: "ifMetagenerationMatch", actual: metageneration, match: true},
+		{param: "ifMetagenerationNotMatch", actual: metageneration, match: false},
+	}
+	for _, c := range checks {
+		v := q.Get(c.param)
+		if v == "" {
+			continue
+		}
+		expected, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid value for %v: %q", c.param, v))
+			return false
+		}
+		if (expected == c.actual) != c.match {
+			writeError(w, http.StatusPreconditionFailed, "At least one of the pre-conditions you specified did not hold.")
+			return false
+		}
+	}
+	return true
+}
+
+func (o *object) etag() string {
+	return base64.StdEncoding.EncodeToString([]byte(strconv.FormatInt(o.generation, 10)))
+}
+
+// hashHeader returns the value of the X-Goog-Hash header.
+func (o *object) hashHeader() string {
+	h := "crc32c=" + encodeCRC32C(o.crc32c)
+	if o.md5 != nil {
+		h += ",md5=" + base64.StdEncoding.EncodeToString(o.md5)
+	}
+	return h
+}
+
+// resource returns the JSON representation of the object. int64 values are encoded as strings like the real API.
+func (o *object) resource() map[string]interface{} {
+	r := map[string]interface{}{
+		"kind":           "storage#object",
+		"id":             fmt.Sprintf("%v/%v/%d", o.bucket, o.name, o.generation),
+		"bucket":         o.bucket,
+		"name":           o.name,
+		"size":           strconv.Itoa(len(o.data)),
+		"crc32c":         encodeCRC32C(o.crc32c),
+		"generation":     strconv.FormatInt(o.generation, 10),
+		"metageneration": strconv.FormatInt(o.metageneration, 10),
+		"etag":           o.etag(),
+		"timeCreated":    o.created.Format(time.RFC3339Nano),
+		"updated":        o.updated.Format(time.RFC3339Nano),
+		"storageClass":   "STANDARD",
+	}
+	if !o.deleted.IsZero() {
+		r["timeDeleted"] = o.deleted.Format(time.RFC3339Nano)
+	}
+	if o.md5 != nil {
+		r["md5Hash"] = base64.StdEncoding.EncodeToString(o.md5)
+	}
+	if o.componentCount > 0 {
+		r["componentCount"] = o.componentCount
+	}
+	if o.contentType != "" {
+		r["contentType"] = o.contentType
+	}
+	if o.contentEncoding != "" {
+		r["contentEncoding"] = o.contentEncoding
+	}
+	if o.cacheControl != "" {
+		r["cacheControl"] = o.cacheControl
+	}
+	if len(o.metadata) > 0 {
+		r["metadata"] = o.metadata
+	}
+	return r
+}
+
+// encodeCRC32C encodes the checksum the way the API does i.e. base64 of the big-endian bytes.
+func encodeCRC32C(c uint32) string {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, c)
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// writeError writes an error in the format used by the JSON API.
+func writeError(w http.ResponseWriter, code int, message string) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(code)
+	body := map[string]interface{}{
+		"error": map[string]interface{}{
+			"code":    code,
+			"message": message,
+			"errors": []map[string]interface{}{
+				{
+					"message": message,
+					"domain":  "global",
+					"reason":  reason(code),
+				},
+			},
+		},
+	}
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func reason(code int) string {
+	switch code {
+	case http.StatusBadRequest:
+		return "invalid"
+	case http.StatusNotFound:
+		return "notFound"
+	case http.StatusForbidden:
+		return "forbidden"
+	case http.StatusUnauthorized:
+		return "required"
+	case http.StatusPreconditionFailed:
+		return "conditionNotMet"
+	default:
+		return "backendError"
+	}
+}
@@ -0,0 +1,153 @@
+package gcstest
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_RangeRead(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+	srv.WriteObject("bucket", "dir/file.txt", []byte("0123456789"))
+
+	type testCase struct {
+		name     string
+		path     string
+		rng      string
+		expected string
+		code     int
+	}
+
+	cases := []testCase{
+		{name: "xml-full", path: "/bucket/dir/file.txt", expected: "0123456789", code: http.StatusOK},
+		{name: "xml-range", path: "/bucket/dir/file.txt", rng: "bytes=2-4", expected: "234", code: http.StatusPartialContent},
+		{name: "json-open-range", path: "/storage/v1/b/bucket/o/dir%2Ffile.txt?alt=media", rng: "bytes=7-", expected: "789", code: http.StatusPartialContent},
+		{name: "suffix-range", path: "/download/storage/v1/b/bucket/o/dir%2Ffile.txt", rng: "bytes=-2", expected: "89", code: http.StatusPartialContent},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, srv.URL+c.path, nil)
+			if err != nil {
+				t.Fatalf("NewRequest error: %v", err)
+			}
+			if c.rng != "" {
+				req.Header.Set("Range", c.rng)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("Do error: %v", err)
+			}
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(resp.Body)
+			if resp.StatusCode != c.code {
+				t.Fatalf("Got status %v; want %v", resp.StatusCode, c.code)
+			}
+			if d := cmp.Diff(c.expected, string(body)); d != "" {
+				t.Errorf("Unexpected body (-want +got):\n%s", d)
+			}
+		})
+	}
+}
+
+func Test_ResumableUpload(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+	srv.CreateBucket("bucket")
+
+	meta, _ := json.Marshal(map[string]string{"name": "big.bin"})
+	resp, err := http.Post(srv.URL+"/upload/storage/v1/b/bucket/o?uploadType=resumable", "application/json", bytes.NewReader(meta))
+	if err != nil {
+		t.Fatalf("Post error: %v", err)
+	}
+	resp.Body.Close()
+	loc := resp.Header.Get("Location")
+	if loc == "" {
+		t.Fatalf("Initiating the upload didn't return a Location")
+	}
+
+	chunks := []struct {
+		contentRange string
+		data         string
+		code         int
+	}{
+		{contentRange: "bytes 0-4/*", data: "hello", code: http.StatusPermanentRedirect},
+		{contentRange: "bytes 5-10/11", data: " world", code: http.StatusOK},
+	}
+	for _, c := range chunks {
+		req, _ := http.NewRequest(http.MethodPut, loc, bytes.NewReader([]byte(c.data)))
+		req.Header.Set("Content-Range", c.contentRange)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Put error: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != c.code {
+			t.Fatalf("Chunk %v got status %v; want %v", c.contentRange, resp.StatusCode, c.code)
+		}
+	}
+
+	data, ok := srv.ReadObject("bucket", "big.bin")
+	if !ok {
+		t.Fatalf("Object wasn't created")
+	}
+	if d := cmp.Diff("hello world", string(data)); d != "" {
+		t.Errorf("Unexpected contents (-want +got):\n%s", d)
+	}
+}
+
+func Test_ListPaging(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+	for _, name := range []string{"a/1.txt", "a/2.txt", "b.txt", "c/3.txt", "d.txt"} {
+		srv.WriteObject("bucket", name, []byte(name))
+	}
+
+	type page struct {
+		Items []struct {
+			Name string `json:"name"`
+		} `json:"items"`
+		Prefixes      []string `json:"prefixes"`
+		NextPageToken string   `json:"nextPageToken"`
+	}
+
+	actual := []string{}
+	token := ""
+	for {
+		q := url.Values{}
+		q.Set("delimiter", "/")
+		q.Set("maxResults", "2")
+		if token != "" {
+			q.Set("pageToken", token)
+		}
+		resp, err := http.Get(srv.URL + "/storage/v1/b/bucket/o?" + q.Encode())
+		if err != nil {
+			t.Fatalf("Get error: %v", err)
+		}
+		p := &page{}
+		err = json.NewDecoder(resp.Body).Decode(p)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("Decode error: %v", err)
+		}
+		actual = append(actual, p.Prefixes...)
+		for _, i := range p.Items {
+			actual = append(actual, i.Name)
+		}
+		if p.NextPageToken == "" {
+			break
+		}
+		token = p.NextPageToken
+	}
+
+	expected := []string{"a/", "b.txt", "c/", "d.txt"}
+	if d := cmp.Diff(expected, actual); d != "" {
+		t.Errorf("Unexpected listing (-want +got):\n%s", d)
+	}
+}
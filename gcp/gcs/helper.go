@@ -4,13 +4,15 @@ import (
 	"context"
 	"fmt"
 	"io"
-	"math/rand"
 	"path"
 	"path/filepath"
 	"regexp"
+	"strconv"
+	"strings"
 
 	"cloud.google.com/go/storage"
 	"github.com/go-logr/zapr"
+	"github.com/jlewi/monogo/helpers"
 	"github.com/jlewi/monogo/util"
 	"github.com/pkg/errors"
 	"go.uber.org/zap"
@@ -24,6 +26,9 @@ var (
 type GcsPath struct {
 	Bucket string
 	Path   string
+	// Generation identifies a specific version of the object; 0 means the live version. In URIs the generation
+	// is appended to the object name e.g. gs://bucket/object#123.
+	Generation int64
 }
 
 func (p *GcsPath) ToURI() string {
@@ -31,6 +36,9 @@ func (p *GcsPath) ToURI() string {
 	if p.Path != "" {
 		r = r + "/" + p.Path
 	}
+	if p.Generation != 0 {
+		r = r + "#" + strconv.FormatInt(p.Generation, 10)
+	}
 	return r
 }
 
@@ -50,27 +58,61 @@ func Parse(path string) (*GcsPath, error) {
 	if len(m) >= 3 {
 		r.Path = m[2]
 	}
+
+	// Object names can contain # so it only denotes a generation if it is followed by a number.
+	if i := strings.LastIndex(r.Path, "#"); i >= 0 {
+		if gen, err := strconv.ParseInt(r.Path[i+1:], 10, 64); err == nil && gen > 0 {
+			r.Path = r.Path[:i]
+			r.Generation = gen
+		}
+	}
 	return r, nil
 }
 
+// object returns the handle for the object; if p has a generation the handle refers to that version.
+func (p *GcsPath) object(client *storage.Client) *storage.ObjectHandle {
+	o := client.Bucket(p.Bucket).Object(p.Path)
+	if p.Generation != 0 {
+		o = o.Generation(p.Generation)
+	}
+	return o
+}
+
+// GcsHelper implements the files.DirectoryHelper interface for GCS.
 type GcsHelper struct {
+	// Ctx is the context used by the methods that don't take a context.
+	// It is retained for backwards compatibility; callers should prefer the Context variants of each method.
 	Ctx    context.Context
 	Client *storage.Client
+	// Notifications, if set, is used by Watch to learn about changes instead of polling.
+	Notifications NotificationSource
+}
+
+// defaultCtx returns the context to be used by the methods that don't take a context.
+func (h *GcsHelper) defaultCtx() context.Context {
+	if h.Ctx == nil {
+		return context.Background()
+	}
+	return h.Ctx
 }
 
 // NewReader creates a new Reader for GCS path or local file.
-func (h *GcsHelper) NewReader(uri string) (io.Reader, error) {
+func (h *GcsHelper) NewReader(uri string) (io.ReadCloser, error) {
+	return h.NewReaderContext(h.defaultCtx(), uri)
+}
+
+// NewReaderContext creates a new Reader for the GCS path. The context applies to the lifetime of the reader.
+// If the path includes a generation e.g. gs://bucket/object#123 that version of the object is read.
+func (h *GcsHelper) NewReaderContext(ctx context.Context, uri string) (io.ReadCloser, error) {
 	p, err := Parse(uri)
 	if err != nil {
 		return nil, err
 	}
-	b := h.Client.Bucket(p.Bucket)
-	o := b.Object(p.Path)
 
-	reader, err := o.NewReader(h.Ctx)
+	reader, err := p.object(h.Client).NewReader(ctx)
 
 	if err != nil {
-		return nil, errors.WithStack(errors.Wrapf(err, "Clould not read: %v", uri))
+		return nil, errors.WithStack(errors.Wrapf(classifyError(uri, err), "Clould not read: %v", uri))
 	}
 
 	return reader, nil
@@ -79,89 +121,119 @@ func (h *GcsHelper) NewReader(uri string) (io.Reader, error) {
 // NewWriter creates a new Writer for GCS path or local file.
 //
 // TODO(jlewi): Can we add options to control filemode?
-func (h *GcsHelper) NewWriter(uri string) (io.Writer, error) {
-	p, err := Parse(uri)
-	if err != nil {
-		return nil, err
-	}
-	b := h.Client.Bucket(p.Bucket)
-
-	_, err = b.Attrs(h.Ctx)
-	if err != nil {
-		return nil, errors.WithStack(errors.Wrapf(err, "Can't access bucket %v; It may not exist", p.Bucket))
-	}
+func (h *GcsHelper) NewWriter(uri string) (io.WriteCloser, error) {
+	return h.NewWriterContext(h.defaultCtx(), uri)
+}
 
-	o := b.Object(p.Path)
+// NewWriterContext creates a new Writer for the GCS path. The context applies to the lifetime of the writer;
+// cancelling it aborts the upload.
+//
+// The object isn't created until Close is called. Errors uploading the data may only be reported by Close so
+// callers must check the error returned by Close.
+func (h *GcsHelper) NewWriterContext(ctx context.Context, uri string) (io.WriteCloser, error) {
+	return h.NewWriterWithOptions(ctx, uri, nil)
+}
 
-	return o.NewWriter(h.Ctx), nil
+// WriteOptions control the object created by NewWriterWithOptions.
+type WriteOptions struct {
+	// ContentType of the object. If it is empty GCS infers it from the data.
+	ContentType helpers.ContentType
+	// CacheControl is the Cache-Control header served with the object e.g. "no-cache".
+	CacheControl string
+	// Metadata is custom metadata to attach to the object.
+	Metadata map[string]string
+
+	// IfGenerationMatch makes the write conditional on the object's current generation; Stat returns the
+	// generation. Use it to implement read-modify-write without clobbering concurrent writers.
+	IfGenerationMatch int64
+	// DoesNotExist makes the write conditional on the object not existing yet.
+	DoesNotExist bool
 }
 
-// Exists checks whether the URI exists.
+// NewWriterWithOptions creates a new Writer for the GCS path; see NewWriterContext.
 //
-// If error is not nil the boolean value will be random.
-func (h *GcsHelper) Exists(uri string) (bool, error) {
-	// In the event of an error we return a random value for the boolean.
-	// This is meant to discourage callers from trusting the value in the event an error occured.
-	randVal := rand.Float32() > .5
-
+// If opts contains a precondition and it isn't met when the object is written, Close returns an *Error with
+// Kind ErrPreconditionFailed and the object is left unchanged.
+func (h *GcsHelper) NewWriterWithOptions(ctx context.Context, uri string, opts *WriteOptions) (io.WriteCloser, error) {
 	p, err := Parse(uri)
 	if err != nil {
-		return randVal, err
+		return nil, err
+	}
+	if opts == nil {
+		opts = &WriteOptions{}
+	}
+	if p.Generation != 0 {
+		return nil, errors.Errorf("Can't write %v; writes always create a new generation so the URI can't include one", uri)
+	}
+	if opts.DoesNotExist && opts.IfGenerationMatch != 0 {
+		return nil, errors.Errorf("Invalid options for %v; DoesNotExist and IfGenerationMatch are mutually exclusive", uri)
 	}
 	b := h.Client.Bucket(p.Bucket)
 
-	_, err = b.Attrs(h.Ctx)
-
+	_, err = b.Attrs(ctx)
 	if err != nil {
-		isMatch, _ := regexp.MatchString(".*doesn't.*exist.*", err.Error())
-
-		if isMatch {
-			return true, nil
-		}
-
-		return randVal, err
+		return nil, errors.WithStack(errors.Wrapf(classifyError("gs://"+p.Bucket, err), "Can't access bucket %v; It may not exist", p.Bucket))
 	}
 
 	o := b.Object(p.Path)
+	switch {
+	case opts.DoesNotExist:
+		o = o.If(storage.Conditions{DoesNotExist: true})
+	case opts.IfGenerationMatch != 0:
+		o = o.If(storage.Conditions{GenerationMatch: opts.IfGenerationMatch})
+	}
 
-	return ObjectExists(h.Ctx, o), nil
+	w := o.NewWriter(ctx)
+	w.ContentType = string(opts.ContentType)
+	w.CacheControl = opts.CacheControl
+	w.Metadata = opts.Metadata
+	return &writer{Writer: w, uri: uri}, nil
 }
 
-// Glob lists all objects matching some glob expression.
+// writer wraps storage.Writer so errors returned by Close are classified.
+type writer struct {
+	*storage.Writer
+	uri string
+}
 
-func (h *GcsHelper) Glob(uri string) ([]string, error) {
-	paths := []string{}
-	p, err := Parse(uri)
-	if err != nil {
-		return paths, errors.WithStack(errors.Wrapf(err, "Could not glob objects matching %v", uri))
+// Close completes the upload.
+func (w *writer) Close() error {
+	if err := w.Writer.Close(); err != nil {
+		return errors.Wrapf(classifyError(w.uri, err), "Failed to write %v", w.uri)
 	}
+	return nil
+}
 
-	b := h.Client.Bucket(p.Bucket)
+// Exists checks whether the URI exists.
+//
+// A missing object isn't an error. If the bucket doesn't exist or the caller isn't allowed to access the object
+// the returned error is an *Error with Kind ErrBucketNotFound or ErrPermissionDenied respectively.
+func (h *GcsHelper) Exists(uri string) (bool, error) {
+	return h.ExistsContext(h.defaultCtx(), uri)
+}
 
-	q := &storage.Query{
-		MatchGlob: p.Path,
+// ExistsContext checks whether the URI exists. See Exists.
+func (h *GcsHelper) ExistsContext(ctx context.Context, uri string) (bool, error) {
+	exists, err := ObjectExistsWithError(ctx, h.Client, uri)
+	if err != nil {
+		return false, errors.Wrapf(err, "Failed to check if %v exists", uri)
 	}
+	return exists, nil
+}
 
-	objs := b.Objects(h.Ctx, q)
-
-	for {
-		i, err := objs.Next()
-
-		if err == iterator.Done {
-			return paths, nil
-		}
-
-		if err != nil {
-			return paths, errors.WithStack(errors.Wrapf(err, "Error getting next object matching %v", uri))
-		}
-
-		iPath := GcsPath{
-			Bucket: i.Bucket,
-			Path:   i.Name,
-		}
+// Glob lists all objects matching some glob expression. The syntax is described by util.Glob.
+func (h *GcsHelper) Glob(uri string) ([]string, error) {
+	return h.GlobContext(h.defaultCtx(), uri)
+}
 
-		paths = append(paths, iPath.ToURI())
+// GlobContext lists all objects matching some glob expression. Use GlobIterator to avoid loading every match
+// into memory.
+func (h *GcsHelper) GlobContext(ctx context.Context, uri string) ([]string, error) {
+	it, err := h.GlobIterator(ctx, uri)
+	if err != nil {
+		return []string{}, err
 	}
+	return it.uris()
 }
 
 // BuildInputOutputList builds a map from input files to the files that they
@@ -170,7 +242,7 @@ func (h *GcsHelper) Glob(uri string) ([]string, error) {
 // input is a regex as specified by TransformFiles. This is used to find existing files and generate
 // the corresponding output files.
 func (h *GcsHelper) BuildInputOutputList(input string, output string) (map[string]string, error) {
-	paths, err := ListObjects(h.Ctx, h.Client, input)
+	paths, err := ListObjects(h.defaultCtx(), h.Client, input)
 
 	if err != nil {
 		return map[string]string{}, errors.Wrapf(err, "Could not list files matching: %v", input)
@@ -178,9 +250,17 @@ func (h *GcsHelper) BuildInputOutputList(input string, output string) (map[strin
 	return util.TransformFiles(paths, input, output)
 }
 
+// Join joins the elements of a GCS URI; see GcsPath.Join.
+//
+// The DirectoryHelper interface doesn't allow Join to return an error. So if elem[0] isn't a GCS URI the elements
+// are joined with filepath.Join and if the result isn't a valid object name the error is logged and the cleaned,
+// unvalidated URI is returned; using it will then fail. Use JoinURI to handle the error.
 func (h *GcsHelper) Join(elem ...string) string {
-	uri, err := Parse(elem[0])
 	log := zapr.NewLogger(zap.L())
+	if len(elem) == 0 {
+		return ""
+	}
+	uri, err := Parse(elem[0])
 	if err != nil {
 		log.Error(err, "Failed to parse URI", "uri", elem[0])
 		// Just fallback to using filepath.Join
@@ -188,140 +268,212 @@ func (h *GcsHelper) Join(elem ...string) string {
 		return filepath.Join(elem...)
 	}
 
-	pieces := []string{uri.Path}
-	pieces = append(pieces, elem[1:]...)
-	uri.Path = path.Join(pieces...)
-	return uri.ToURI()
+	joined, err := uri.Join(elem[1:]...)
+	if err != nil {
+		log.Error(err, "Failed to join URI", "elem", elem)
+		invalid := &GcsPath{
+			Bucket: uri.Bucket,
+			Path:   path.Join(append([]string{uri.Path}, elem[1:]...)...),
+		}
+		return invalid.ToURI()
+	}
+	return joined.ToURI()
 }
 
-func ObjectExists(ctx context.Context, o *storage.ObjectHandle) bool {
-	log := zapr.NewLogger(zap.L())
-	_, err := o.Attrs(ctx)
-
-	if err == nil {
-		return true
+// JoinURI joins the elements of a GCS URI; see GcsPath.Join. An error is returned if elem[0] isn't a GCS URI or
+// the result isn't a valid object name.
+func (h *GcsHelper) JoinURI(elem ...string) (string, error) {
+	if len(elem) == 0 {
+		return "", errors.New("Can't join an empty list of elements")
 	}
-
-	isMatch, mErr := regexp.MatchString(".*doesn't.*exist.*", err.Error())
-
-	if mErr != nil {
-		log.Error(err, "There was a problem matching the regex; %v")
+	uri, err := Parse(elem[0])
+	if err != nil {
+		return "", err
 	}
-
-	if err != nil && isMatch {
-		return false
+	joined, err := uri.Join(elem[1:]...)
+	if err != nil {
+		return "", errors.Wrapf(err, "Failed to join %v", elem)
 	}
-
-	return true
+	return joined.ToURI(), nil
 }
 
-// ListObjects lists all objects matching some regex.
-//
-// This is listing all files in the parent directory.
-func ListObjects(ctx context.Context, client *storage.Client, uri string) ([]string, error) {
-	paths := []string{}
+// Delete deletes the object. If the bucket has object versioning enabled the live version becomes noncurrent;
+// include a generation in the URI e.g. gs://bucket/object#123 to permanently delete a version.
+func (h *GcsHelper) Delete(ctx context.Context, uri string) error {
 	p, err := Parse(uri)
 	if err != nil {
-		return paths, errors.WithStack(errors.Wrapf(err, "Could not list objects matching %v", uri))
+		return err
 	}
-
-	b := client.Bucket(p.Bucket)
-
-	prefix := path.Dir(p.Path)
-
-	q := &storage.Query{
-		Delimiter: "/",
-		Prefix:    prefix + "/",
-		Versions:  false,
+	if err := p.object(h.Client).Delete(ctx); err != nil {
+		return errors.WithStack(errors.Wrapf(classifyError(uri, err), "Could not delete: %v", uri))
 	}
+	return nil
+}
 
-	objs := b.Objects(ctx, q)
-	return findMatches(p, objs)
+// Stat returns information about the object.
+func (h *GcsHelper) Stat(ctx context.Context, uri string) (*helpers.FileInfo, error) {
+	p, err := Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+	attrs, err := p.object(h.Client).Attrs(ctx)
+	if err != nil {
+		return nil, errors.WithStack(errors.Wrapf(classifyError(uri, err), "Could not stat: %v", uri))
+	}
+	return objectInfo(attrs), nil
 }
 
-// ListObjectsWithPrefix returns a list of all GCS objects within the given prefix.
-func ListObjectsWithPrefix(ctx context.Context, client *storage.Client, prefix string) ([]string, error) {
-	paths := []string{}
-	p, err := Parse(prefix)
+// List returns all the objects inside the directory uri; including objects in subdirectories.
+// uri is treated as a directory so gs://bucket/dir won't match gs://bucket/dir2/object.
+func (h *GcsHelper) List(ctx context.Context, uri string) ([]*helpers.FileInfo, error) {
+	results := []*helpers.FileInfo{}
+	p, err := Parse(uri)
 	if err != nil {
-		return paths, errors.WithStack(errors.Wrapf(err, "Could not list objects matching %v", prefix))
+		return results, errors.WithStack(errors.Wrapf(err, "Could not list objects in %v", uri))
 	}
 
-	b := client.Bucket(p.Bucket)
+	prefix := p.Path
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix = prefix + "/"
+	}
 
 	q := &storage.Query{
-		Prefix:   p.Path,
+		Prefix:   prefix,
 		Versions: false,
 	}
 
-	objs := b.Objects(ctx, q)
-
+	objs := h.Client.Bucket(p.Bucket).Objects(ctx, q)
 	for {
-		i, err := objs.Next()
+		attrs, err := objs.Next()
 
 		if err == iterator.Done {
-			return paths, nil
+			return results, nil
 		}
 
 		if err != nil {
-			return paths, errors.WithStack(errors.Wrapf(err, "Error getting next object with prefix %v", prefix))
-		}
-
-		// Skip it is just a prefix
-		if i.Prefix != "" {
-			continue
+			return results, errors.WithStack(errors.Wrapf(err, "Error getting next object in %v", uri))
 		}
 
-		iPath := GcsPath{
-			Bucket: i.Bucket,
-			Path:   i.Name,
-		}
-
-		paths = append(paths, iPath.ToURI())
+		results = append(results, objectInfo(attrs))
+	}
+}
 
+// Copy copies the object src to dst. The copy is done server side so the data doesn't pass through the client.
+// src can include a generation to copy an old version of the object.
+func (h *GcsHelper) Copy(ctx context.Context, src string, dst string) error {
+	srcPath, err := Parse(src)
+	if err != nil {
+		return err
 	}
+	dstPath, err := Parse(dst)
+	if err != nil {
+		return err
+	}
+	if dstPath.Generation != 0 {
+		return errors.Errorf("Can't copy to %v; the destination can't include a generation", dst)
+	}
+	srcObj := srcPath.object(h.Client)
+	dstObj := h.Client.Bucket(dstPath.Bucket).Object(dstPath.Path)
+	if _, err := dstObj.CopierFrom(srcObj).Run(ctx); err != nil {
+		return errors.WithStack(errors.Wrapf(err, "Could not copy %v to %v", src, dst))
+	}
+	return nil
 }
 
-type objectAttrsIterator interface {
-	Next() (*storage.ObjectAttrs, error)
+// objectInfo converts the object's attributes to a FileInfo.
+func objectInfo(attrs *storage.ObjectAttrs) *helpers.FileInfo {
+	p := GcsPath{
+		Bucket: attrs.Bucket,
+		Path:   attrs.Name,
+	}
+	return &helpers.FileInfo{
+		URI:         p.ToURI(),
+		Size:        attrs.Size,
+		ModTime:     attrs.Updated,
+		ContentType: helpers.ContentType(attrs.ContentType),
+		Generation:  attrs.Generation,
+		Noncurrent:  !attrs.Deleted.IsZero(),
+		Etag:        attrs.Etag,
+		MD5:         attrs.MD5,
+	}
 }
 
-func findMatches(pattern *GcsPath, objs objectAttrsIterator) ([]string, error) {
+// ObjectExists returns true if the object exists. Errors other than the object not existing are logged and
+// treated as the object existing.
+//
+// Deprecated: Use ObjectExistsWithError which reports errors and a missing bucket.
+func ObjectExists(ctx context.Context, o *storage.ObjectHandle) bool {
 	log := zapr.NewLogger(zap.L())
-	paths := []string{}
-	for {
-		i, err := objs.Next()
+	_, err := o.Attrs(ctx)
+	if err == nil {
+		return true
+	}
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return false
+	}
+	log.Error(err, "Failed to check whether object exists", "bucket", o.BucketName(), "object", o.ObjectName())
+	return true
+}
 
-		if err == iterator.Done {
-			return paths, nil
-		}
+// ObjectExistsWithError checks whether the object uri exists. A missing object isn't an error.
+//
+// The storage API reports a missing bucket the same way as a missing object so when the object doesn't exist
+// the bucket is checked as well; if the bucket doesn't exist an *Error with Kind ErrBucketNotFound is returned.
+// Other errors, e.g. permission denied, are returned as an *Error when they can be classified.
+func ObjectExistsWithError(ctx context.Context, client *storage.Client, uri string) (bool, error) {
+	p, err := Parse(uri)
+	if err != nil {
+		return false, err
+	}
+	b := client.Bucket(p.Bucket)
+	_, err = p.object(client).Attrs(ctx)
 
-		if err != nil {
-			return paths, errors.WithStack(errors.Wrapf(err, "Error getting next object matching %v", pattern.ToURI()))
-		}
+	if err == nil {
+		return true, nil
+	}
 
-		// Skip it is just a prefix
-		if i.Prefix != "" {
-			continue
-		}
+	if !errors.Is(err, storage.ErrObjectNotExist) {
+		return false, classifyError(uri, err)
+	}
 
-		iPath := GcsPath{
-			Bucket: i.Bucket,
-			Path:   i.Name,
-		}
+	// Attrs requires storage.buckets.get which callers with only object permissions may not have. So only a
+	// definitive ErrBucketNotExist is reported; any other error just means the object doesn't exist.
+	if _, err := b.Attrs(ctx); errors.Is(err, storage.ErrBucketNotExist) {
+		return false, classifyError((&GcsPath{Bucket: p.Bucket}).ToURI(), err)
+	}
+	return false, nil
+}
 
-		log.V(1).Info("path.Match", "pattern", pattern.ToURI(), "path", iPath.ToURI())
-		isMatch, err := regexp.MatchString(pattern.ToURI(), iPath.ToURI())
+// ListObjects lists all objects matching some regex.
+//
+// Unlike Glob the uri is a regular expression not a glob; it is used by BuildInputOutputList to capture named
+// groups which can be referenced in the output pattern.
+//
+// This is listing all files in the parent directory. Use IterateObjects to avoid loading every match into memory.
+func ListObjects(ctx context.Context, client *storage.Client, uri string) ([]string, error) {
+	it, err := IterateObjects(ctx, client, uri)
+	if err != nil {
+		return []string{}, err
+	}
+	return it.uris()
+}
 
-		if err != nil {
-			log.Error(err, "path.Match", "pattern", pattern.ToURI(), "path", iPath.ToURI())
-			continue
-		}
+// ListObjectsWithPrefix returns a list of all GCS objects within the given prefix. Use IterateObjectsWithPrefix to
+// avoid loading every object into memory.
+func ListObjectsWithPrefix(ctx context.Context, client *storage.Client, prefix string) ([]string, error) {
+	it, err := IterateObjectsWithPrefix(ctx, client, prefix)
+	if err != nil {
+		return []string{}, err
+	}
+	return it.uris()
+}
 
-		if isMatch {
-			paths = append(paths, iPath.ToURI())
-		}
+func findMatches(pattern *GcsPath, objs objectAttrsIterator) ([]string, error) {
+	it, err := newRegexIterator(pattern, objs)
+	if err != nil {
+		return []string{}, err
 	}
+	return it.uris()
 }
 
 func init() {
@@ -2,14 +2,17 @@ package gcs
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
-	"os"
+	"net/http"
 	"regexp"
 	"testing"
 
 	"cloud.google.com/go/storage"
 	"github.com/google/go-cmp/cmp"
+	"github.com/jlewi/monogo/gcp/gcs/gcstest"
+	"github.com/jlewi/monogo/helpers"
 	"google.golang.org/api/iterator"
 )
 
@@ -45,6 +48,24 @@ func TestParse(t *testing.T) {
 				Path:   "",
 			},
 		},
+		{
+			Input:         "gs://bucket/folder1/file.csv#1234",
+			ExpectedErrRe: "",
+			Expected: &GcsPath{
+				Bucket:     "bucket",
+				Path:       "folder1/file.csv",
+				Generation: 1234,
+			},
+		},
+		{
+			// # is only a generation if it's followed by a number.
+			Input:         "gs://bucket/folder1/file#1.csv",
+			ExpectedErrRe: "",
+			Expected: &GcsPath{
+				Bucket: "bucket",
+				Path:   "folder1/file#1.csv",
+			},
+		},
 		{
 			Input:         "/some/path",
 			ExpectedErrRe: ".*path.*doesn't.*match",
@@ -74,6 +95,10 @@ func TestParse(t *testing.T) {
 			t.Errorf("Case %v: Parse() mismatch (-want +got):\n%s", i, d)
 			continue
 		}
+
+		if actual != nil && c.Expected.Path != "" && actual.ToURI() != c.Input {
+			t.Errorf("Case %v: ToURI() = %v; want %v", i, actual.ToURI(), c.Input)
+		}
 	}
 }
 
@@ -155,6 +180,7 @@ func Test_Join(t *testing.T) {
 	type testCase struct {
 		Input    []string
 		Expected string
+		WantErr  bool
 	}
 
 	cases := []testCase{
@@ -170,64 +196,417 @@ func Test_Join(t *testing.T) {
 			Input:    []string{"gs://bucket/folder1/", "file.csv"},
 			Expected: "gs://bucket/folder1/file.csv",
 		},
+		{
+			Input:    []string{"gs://bucket/folder1", "a//../file.csv"},
+			Expected: "gs://bucket/folder1/file.csv",
+		},
+		{
+			// Join falls back to filepath.Join if the first element isn't a GCS URI.
+			Input:    []string{"/local/folder1", "file.csv"},
+			Expected: "/local/folder1/file.csv",
+			WantErr:  true,
+		},
+		{
+			// Join returns the URI without validating it if the result isn't a valid object name.
+			Input:    []string{"gs://bucket/folder1", "../../file.csv"},
+			Expected: "gs://bucket/../file.csv",
+			WantErr:  true,
+		},
+		{
+			Input:    []string{"gs://bucket/folder1", "bad\nname"},
+			Expected: "gs://bucket/folder1/bad\nname",
+			WantErr:  true,
+		},
 	}
 
 	h := &GcsHelper{}
 	for i, c := range cases {
 		t.Run(fmt.Sprintf("case %d", i), func(t *testing.T) {
-			actual := h.Join(c.Input...)
-			if d := cmp.Diff(c.Expected, actual); d != "" {
+			if d := cmp.Diff(c.Expected, h.Join(c.Input...)); d != "" {
 				t.Errorf("Join() mismatch (-want +got):\n%s", d)
 			}
+
+			actual, err := h.JoinURI(c.Input...)
+			if c.WantErr {
+				if err == nil {
+					t.Errorf("JoinURI(%v) should have failed", c.Input)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("JoinURI(%v) failed; error: %v", c.Input, err)
+			}
+			if d := cmp.Diff(c.Expected, actual); d != "" {
+				t.Errorf("JoinURI() mismatch (-want +got):\n%s", d)
+			}
 		})
 	}
 }
 
-func Test_Glob(t *testing.T) {
-	if os.Getenv("GITHUB_ACTIONS") != "" {
-		t.Skip("Skipping test in GitHub Actions")
+// newTestHelper returns a GcsHelper backed by a fake GCS server.
+func newTestHelper(t *testing.T) (*GcsHelper, *gcstest.Server) {
+	t.Helper()
+	srv := gcstest.NewServer()
+	t.Cleanup(srv.Close)
+
+	ctx := context.Background()
+	client, err := srv.Client(ctx)
+	if err != nil {
+		t.Fatalf("Failed to create client; error: %v", err)
 	}
+	return &GcsHelper{Ctx: ctx, Client: client}, srv
+}
+
+func Test_Glob(t *testing.T) {
+	h, srv := newTestHelper(t)
 
 	files := []string{
-		"gs://foyle-dev-mongo-testing/somefile/file-1.txt",
-		"gs://foyle-dev-mongo-testing/somefile/file-2.txt",
-		"gs://foyle-dev-mongo-testing/somefile/file-22.txt",
-		"gs://foyle-dev-mongo-testing/somefile/otherfile.txt",
+		"somefile/file-1.txt",
+		"somefile/file-2.txt",
+		"somefile/file-22.txt",
+		"somefile/otherfile.txt",
+	}
+
+	for _, f := range files {
+		srv.WriteObject("bucket", f, []byte(f))
 	}
 
+	expected := []string{
+		"gs://bucket/somefile/file-1.txt",
+		"gs://bucket/somefile/file-2.txt",
+	}
+
+	actual, err := h.Glob("gs://bucket/somefile/file-?.txt")
+	if err != nil {
+		t.Fatalf("Glob returned error %v", err)
+	}
+	if d := cmp.Diff(expected, actual); d != "" {
+		t.Errorf("Glob() mismatch (-want +got):\n%s", d)
+	}
+}
+
+// Test_GlobListing checks that globs only list the objects that could match rather than the whole bucket.
+func Test_GlobListing(t *testing.T) {
+	h, srv := newTestHelper(t)
+
+	for _, f := range []string{"a.txt", "b.json", "sub/c.txt", "sub/d.txt", "sub/deep/e.txt", "sub/deep/f.txt"} {
+		srv.WriteObject("bucket", f, []byte(f))
+	}
+
+	type testCase struct {
+		pattern  string
+		expected []string
+		listed   int
+	}
+
+	cases := []testCase{
+		{
+			pattern:  "gs://bucket/*.txt",
+			expected: []string{"gs://bucket/a.txt"},
+			listed:   2,
+		},
+		{
+			pattern:  "gs://bucket/sub/*.txt",
+			expected: []string{"gs://bucket/sub/c.txt", "gs://bucket/sub/d.txt"},
+			listed:   2,
+		},
+		{
+			pattern:  "gs://bucket/sub/**/*.txt",
+			expected: []string{"gs://bucket/sub/c.txt", "gs://bucket/sub/d.txt", "gs://bucket/sub/deep/e.txt", "gs://bucket/sub/deep/f.txt"},
+			listed:   4,
+		},
+		{
+			pattern:  "gs://bucket/*/deep/e.txt",
+			expected: []string{"gs://bucket/sub/deep/e.txt"},
+			listed:   6,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.pattern, func(t *testing.T) {
+			before := srv.ObjectsListed()
+			actual, err := h.Glob(c.pattern)
+			if err != nil {
+				t.Fatalf("Glob returned error %v", err)
+			}
+			if d := cmp.Diff(c.expected, actual); d != "" {
+				t.Errorf("Glob() mismatch (-want +got):\n%s", d)
+			}
+			if listed := srv.ObjectsListed() - before; listed != c.listed {
+				t.Errorf("Glob(%v) listed %v objects; want %v", c.pattern, listed, c.listed)
+			}
+		})
+	}
+}
+
+func Test_ReadWrite(t *testing.T) {
+	h, srv := newTestHelper(t)
+	srv.CreateBucket("bucket")
+
+	uri := "gs://bucket/dir/file.txt"
+	w, err := h.NewWriter(uri)
+	if err != nil {
+		t.Fatalf("NewWriter(%v) returned error: %v", uri, err)
+	}
+	if _, err := w.Write([]byte("hello world")); err != nil {
+		t.Fatalf("Write returned error: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close returned error: %v", err)
+	}
+
+	r, err := h.NewReader(uri)
+	if err != nil {
+		t.Fatalf("NewReader(%v) returned error: %v", uri, err)
+	}
+	defer r.Close()
+	actual, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll returned error: %v", err)
+	}
+	if d := cmp.Diff("hello world", string(actual)); d != "" {
+		t.Errorf("Unexpected contents (-want +got):\n%s", d)
+	}
+
+	if _, err := h.NewReader("gs://bucket/dir/missing.txt"); !errors.Is(err, ErrObjectNotFound) {
+		t.Errorf("NewReader of missing object got error %v; want %v", err, ErrObjectNotFound)
+	}
+
+	if _, err := h.NewWriter("gs://missing/file.txt"); !errors.Is(err, ErrBucketNotFound) {
+		t.Errorf("NewWriter in missing bucket got error %v; want %v", err, ErrBucketNotFound)
+	}
+}
+
+func Test_StatListDeleteCopy(t *testing.T) {
+	h, srv := newTestHelper(t)
 	ctx := context.Background()
-	client, err := storage.NewClient(ctx)
+
+	srv.WriteObject("bucket", "dir/a.txt", []byte("a"))
+	srv.WriteObject("bucket", "dir/sub/b.txt", []byte("bb"))
+	srv.WriteObject("bucket", "dir2/c.txt", []byte("ccc"))
+
+	info, err := h.Stat(ctx, "gs://bucket/dir/sub/b.txt")
 	if err != nil {
-		t.Fatalf("Failed to create GCS storage client; error: %+v", err)
+		t.Fatalf("Stat returned error: %v", err)
+	}
+	if info.Size != 2 {
+		t.Errorf("Stat got size %v; want 2", info.Size)
+	}
+	if len(info.MD5) == 0 {
+		t.Errorf("Stat didn't return the MD5 hash")
 	}
 
-	h := &GcsHelper{
-		Ctx:    ctx,
-		Client: client,
+	if err := h.Copy(ctx, "gs://bucket/dir/a.txt", "gs://bucket/dir/copy.txt"); err != nil {
+		t.Fatalf("Copy returned error: %v", err)
+	}
+	if data, _ := srv.ReadObject("bucket", "dir/copy.txt"); string(data) != "a" {
+		t.Errorf("Copy got contents %q; want %q", data, "a")
 	}
 
-	// Create the files
-	for _, f := range files {
-		w, err := h.NewWriter(f)
+	if err := h.Delete(ctx, "gs://bucket/dir/a.txt"); err != nil {
+		t.Fatalf("Delete returned error: %v", err)
+	}
+	if err := h.Delete(ctx, "gs://bucket/dir/a.txt"); !errors.Is(err, ErrObjectNotFound) {
+		t.Errorf("Delete of missing object got error %v; want %v", err, ErrObjectNotFound)
+	}
+
+	infos, err := h.List(ctx, "gs://bucket/dir")
+	if err != nil {
+		t.Fatalf("List returned error: %v", err)
+	}
+	actual := []string{}
+	for _, i := range infos {
+		actual = append(actual, i.URI)
+	}
+	expected := []string{"gs://bucket/dir/copy.txt", "gs://bucket/dir/sub/b.txt"}
+	if d := cmp.Diff(expected, actual); d != "" {
+		t.Errorf("List() mismatch (-want +got):\n%s", d)
+	}
+}
+
+func Test_ListObjects(t *testing.T) {
+	h, srv := newTestHelper(t)
+	ctx := context.Background()
+
+	for _, f := range []string{"in/a.pdf", "in/b.pdf", "in/c.csv", "in/sub/d.pdf", "other/e.pdf"} {
+		srv.WriteObject("bucket", f, []byte(f))
+	}
+
+	t.Run("ListObjects", func(t *testing.T) {
+		actual, err := ListObjects(ctx, h.Client, `gs://bucket/in/.*\.pdf`)
+		if err != nil {
+			t.Fatalf("ListObjects returned error: %v", err)
+		}
+		expected := []string{"gs://bucket/in/a.pdf", "gs://bucket/in/b.pdf"}
+		if d := cmp.Diff(expected, actual); d != "" {
+			t.Errorf("ListObjects() mismatch (-want +got):\n%s", d)
+		}
+	})
+
+	t.Run("ListObjectsWithPrefix", func(t *testing.T) {
+		actual, err := ListObjectsWithPrefix(ctx, h.Client, "gs://bucket/in/")
 		if err != nil {
-			t.Fatalf("Could not create writer for %v; error %v", f, err)
+			t.Fatalf("ListObjectsWithPrefix returned error: %v", err)
 		}
-		closer := w.(io.WriteCloser)
-		if err := closer.Close(); err != nil {
-			t.Fatalf("Could not close writer for %v; error %v", f, err)
+		expected := []string{"gs://bucket/in/a.pdf", "gs://bucket/in/b.pdf", "gs://bucket/in/c.csv", "gs://bucket/in/sub/d.pdf"}
+		if d := cmp.Diff(expected, actual); d != "" {
+			t.Errorf("ListObjectsWithPrefix() mismatch (-want +got):\n%s", d)
+		}
+	})
+
+	t.Run("BuildInputOutputList", func(t *testing.T) {
+		actual, err := h.BuildInputOutputList(`gs://bucket/in/(?P<name>[a-z]+)\.pdf`, "gs://bucket/out/{{.name}}.csv")
+		if err != nil {
+			t.Fatalf("BuildInputOutputList returned error: %v", err)
 		}
+		expected := map[string]string{
+			"gs://bucket/in/a.pdf": "gs://bucket/out/a.csv",
+			"gs://bucket/in/b.pdf": "gs://bucket/out/b.csv",
+		}
+		if d := cmp.Diff(expected, actual); d != "" {
+			t.Errorf("BuildInputOutputList() mismatch (-want +got):\n%s", d)
+		}
+	})
+}
+
+func Test_Exists(t *testing.T) {
+	h, srv := newTestHelper(t)
+
+	srv.WriteObject("bucket", "dir/file.txt", []byte("hello"))
+	srv.WriteObject("denied", "file.txt", []byte("hello"))
+	srv.InjectError("denied", "", http.StatusForbidden)
+
+	type testCase struct {
+		uri      string
+		expected bool
+		kind     ErrorKind
 	}
 
-	expected := []string{
-		"gs://foyle-dev-mongo-testing/somefile/file-1.txt",
-		"gs://foyle-dev-mongo-testing/somefile/file-2.txt",
+	cases := []testCase{
+		{
+			uri:      "gs://bucket/dir/file.txt",
+			expected: true,
+		},
+		{
+			uri:      "gs://bucket/dir/missing.txt",
+			expected: false,
+		},
+		{
+			uri:      "gs://missing/file.txt",
+			expected: false,
+			kind:     ErrBucketNotFound,
+		},
+		{
+			uri:      "gs://denied/file.txt",
+			expected: false,
+			kind:     ErrPermissionDenied,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.uri, func(t *testing.T) {
+			actual, err := h.Exists(c.uri)
+			if c.kind == "" && err != nil {
+				t.Fatalf("Exists(%v) returned error: %v", c.uri, err)
+			}
+			if c.kind != "" && !errors.Is(err, c.kind) {
+				t.Fatalf("Exists(%v) got error %v; want %v", c.uri, err, c.kind)
+			}
+			if actual != c.expected {
+				t.Errorf("Exists(%v) got %v; want %v", c.uri, actual, c.expected)
+			}
+		})
+	}
+
+	// The deprecated ObjectExists is kept for existing callers.
+	ctx := context.Background()
+	if !ObjectExists(ctx, h.Client.Bucket("bucket").Object("dir/file.txt")) {
+		t.Errorf("ObjectExists should return true for an existing object")
+	}
+	if ObjectExists(ctx, h.Client.Bucket("bucket").Object("dir/missing.txt")) {
+		t.Errorf("ObjectExists should return false for a missing object")
+	}
+}
+
+func Test_ClassifyError(t *testing.T) {
+	h, srv := newTestHelper(t)
+	ctx := context.Background()
+	srv.CreateBucket("bucket")
+
+	_, err := h.Stat(ctx, "gs://bucket/missing.txt")
+	if !errors.Is(err, ErrObjectNotFound) {
+		t.Errorf("Stat got error %v; want %v", err, ErrObjectNotFound)
+	}
+
+	gcsErr := &Error{}
+	if !errors.As(err, &gcsErr) {
+		t.Fatalf("Stat got error of type %T; want *Error", err)
+	}
+	if d := cmp.Diff("gs://bucket/missing.txt", gcsErr.URI); d != "" {
+		t.Errorf("Unexpected URI; diff:\n%v", d)
+	}
+}
+
+func Test_WriteOptions(t *testing.T) {
+	h, srv := newTestHelper(t)
+	ctx := context.Background()
+	srv.CreateBucket("bucket")
+
+	write := func(uri string, contents string, opts *WriteOptions) error {
+		w, err := h.NewWriterWithOptions(ctx, uri, opts)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write([]byte(contents)); err != nil {
+			return err
+		}
+		return w.Close()
 	}
 
-	actual, err := h.Glob("gs://foyle-dev-mongo-testing/somefile/file-?.txt")
+	uri := "gs://bucket/config.json"
+	opts := &WriteOptions{
+		ContentType:  helpers.ContentTypeJSON,
+		CacheControl: "no-cache",
+		Metadata:     map[string]string{"owner": "test"},
+		DoesNotExist: true,
+	}
+	if err := write(uri, `{"v": 1}`, opts); err != nil {
+		t.Fatalf("Failed to write %v: %v", uri, err)
+	}
+
+	attrs, err := h.Client.Bucket("bucket").Object("config.json").Attrs(ctx)
 	if err != nil {
-		t.Fatalf("Glob returned error %v", err)
+		t.Fatalf("Attrs returned error: %v", err)
 	}
-	if d := cmp.Diff(expected, actual); d != "" {
-		t.Errorf("Glob() mismatch (-want +got):\n%s", d)
+	if d := cmp.Diff(string(helpers.ContentTypeJSON), attrs.ContentType); d != "" {
+		t.Errorf("Unexpected content type (-want +got):\n%s", d)
+	}
+	if d := cmp.Diff("no-cache", attrs.CacheControl); d != "" {
+		t.Errorf("Unexpected cache control (-want +got):\n%s", d)
+	}
+	if d := cmp.Diff(opts.Metadata, attrs.Metadata); d != "" {
+		t.Errorf("Unexpected metadata (-want +got):\n%s", d)
+	}
+
+	// The object now exists so a second create should fail.
+	if err := write(uri, `{"v": 2}`, &WriteOptions{DoesNotExist: true}); !errors.Is(err, ErrPreconditionFailed) {
+		t.Errorf("Create of existing object got error %v; want %v", err, ErrPreconditionFailed)
+	}
+
+	// Simulate two writers doing read-modify-write based on the same generation; only the first should succeed.
+	info, err := h.Stat(ctx, uri)
+	if err != nil {
+		t.Fatalf("Stat returned error: %v", err)
+	}
+	if err := write(uri, `{"v": 3}`, &WriteOptions{IfGenerationMatch: info.Generation}); err != nil {
+		t.Fatalf("First conditional write failed: %v", err)
+	}
+	if err := write(uri, `{"v": 4}`, &WriteOptions{IfGenerationMatch: info.Generation}); !errors.Is(err, ErrPreconditionFailed) {
+		t.Errorf("Second conditional write got error %v; want %v", err, ErrPreconditionFailed)
+	}
+
+	data, _ := srv.ReadObject("bucket", "config.json")
+	if d := cmp.Diff(`{"v": 3}`, string(data)); d != "" {
+		t.Errorf("Unexpected contents (-want +got):\n%s", d)
 	}
 }
@@ -0,0 +1,188 @@
+package gcs
+
+import (
+	"context"
+	"path"
+	"regexp"
+
+	"cloud.google.com/go/storage"
+	"github.com/jlewi/monogo/util"
+	"github.com/pkg/errors"
+	"google.golang.org/api/iterator"
+)
+
+// ErrStop can be returned by a WalkFunc to stop iterating without an error.
+var ErrStop = errors.New("stop iterating")
+
+// WalkFunc is called by Walk for each object. Returning an error stops the iteration; return ErrStop to stop
+// early without causing Walk to return an error.
+type WalkFunc func(p *GcsPath, attrs *storage.ObjectAttrs) error
+
+// objectAttrsIterator is the subset of storage.ObjectIterator used by Iterator; it allows tests to supply
+// results without a server.
+type objectAttrsIterator interface {
+	Next() (*storage.ObjectAttrs, error)
+}
+
+// Iterator streams the objects matching a query. Objects are fetched from GCS a page at a time as Next is called
+// so memory usage doesn't grow with the number of objects. Use PageInfo to control the page size.
+type Iterator struct {
+	objs objectAttrsIterator
+	// desc describes the query for error messages.
+	desc string
+	// match, if set, filters the objects.
+	match func(p *GcsPath) bool
+	// versions is true if the query lists every version of the objects; the paths then include the generation.
+	versions bool
+}
+
+// Next returns the next object. It returns iterator.Done when there are no more objects.
+// Prefixes, returned when listing with a delimiter, are skipped.
+func (i *Iterator) Next() (*GcsPath, *storage.ObjectAttrs, error) {
+	for {
+		attrs, err := i.objs.Next()
+		if err == iterator.Done {
+			return nil, nil, err
+		}
+		if err != nil {
+			return nil, nil, errors.WithStack(errors.Wrapf(err, "Error getting next object matching %v", i.desc))
+		}
+
+		if attrs.Prefix != "" {
+			continue
+		}
+
+		p := &GcsPath{
+			Bucket: attrs.Bucket,
+			Path:   attrs.Name,
+		}
+		if i.versions {
+			p.Generation = attrs.Generation
+		}
+		if i.match != nil && !i.match(p) {
+			continue
+		}
+		return p, attrs, nil
+	}
+}
+
+// PageInfo supports pagination; see the google.golang.org/api/iterator package. It is nil if the underlying
+// iterator doesn't support pagination.
+func (i *Iterator) PageInfo() *iterator.PageInfo {
+	if p, ok := i.objs.(iterator.Pageable); ok {
+		return p.PageInfo()
+	}
+	return nil
+}
+
+// Walk calls fn for each remaining object.
+func (i *Iterator) Walk(fn WalkFunc) error {
+	for {
+		p, attrs, err := i.Next()
+		if err == iterator.Done {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(p, attrs); err != nil {
+			if errors.Is(err, ErrStop) {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+// uris returns the URIs of all the remaining objects.
+func (i *Iterator) uris() ([]string, error) {
+	paths := []string{}
+	err := i.Walk(func(p *GcsPath, _ *storage.ObjectAttrs) error {
+		paths = append(paths, p.ToURI())
+		return nil
+	})
+	return paths, err
+}
+
+// IterateObjects returns an iterator over the objects matching the regex uri; see ListObjects.
+func IterateObjects(ctx context.Context, client *storage.Client, uri string) (*Iterator, error) {
+	p, err := Parse(uri)
+	if err != nil {
+		return nil, errors.WithStack(errors.Wrapf(err, "Could not list objects matching %v", uri))
+	}
+
+	q := &storage.Query{
+		Delimiter: "/",
+		Prefix:    path.Dir(p.Path) + "/",
+		Versions:  false,
+	}
+
+	return newRegexIterator(p, client.Bucket(p.Bucket).Objects(ctx, q))
+}
+
+// IterateObjectsWithPrefix returns an iterator over all objects within the given prefix.
+func IterateObjectsWithPrefix(ctx context.Context, client *storage.Client, prefix string) (*Iterator, error) {
+	p, err := Parse(prefix)
+	if err != nil {
+		return nil, errors.WithStack(errors.Wrapf(err, "Could not list objects matching %v", prefix))
+	}
+
+	q := &storage.Query{
+		Prefix:   p.Path,
+		Versions: false,
+	}
+
+	return &Iterator{
+		objs: client.Bucket(p.Bucket).Objects(ctx, q),
+		desc: prefix,
+	}, nil
+}
+
+// GlobIterator returns an iterator over all objects matching some glob expression. The syntax is described by
+// util.Glob.
+//
+// The objects are listed using the literal prefix of the pattern and matched client side rather than using the
+// matchGlob parameter of the list API; this guarantees the pattern matches the same files as it would for the
+// other DirectoryHelpers. If matches can't contain a / after the prefix, e.g. gs://bucket/dir/*.txt, / is used
+// as the delimiter so objects in subdirectories aren't listed.
+func (h *GcsHelper) GlobIterator(ctx context.Context, uri string) (*Iterator, error) {
+	p, err := Parse(uri)
+	if err != nil {
+		return nil, errors.WithStack(errors.Wrapf(err, "Could not glob objects matching %v", uri))
+	}
+
+	g, err := util.CompileGlob(p.Path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Could not glob objects matching %v", uri)
+	}
+
+	q := &storage.Query{
+		Prefix: g.Prefix(),
+	}
+	if !g.IsNested() {
+		q.Delimiter = "/"
+	}
+
+	return &Iterator{
+		objs: h.Client.Bucket(p.Bucket).Objects(ctx, q),
+		desc: uri,
+		match: func(o *GcsPath) bool {
+			return g.Match(o.Path)
+		},
+	}, nil
+}
+
+// newRegexIterator returns an iterator over the objects in objs whose URI matches the regex pattern.
+func newRegexIterator(pattern *GcsPath, objs objectAttrsIterator) (*Iterator, error) {
+	re, err := regexp.Compile(pattern.ToURI())
+	if err != nil {
+		return nil, errors.Wrapf(err, "Could not compile regex %v", pattern.ToURI())
+	}
+	return &Iterator{
+		objs: objs,
+		desc: pattern.ToURI(),
+		match: func(p *GcsPath) bool {
+			return re.MatchString(p.ToURI())
+		},
+	}, nil
+}
@@ -0,0 +1,105 @@
+package gcs
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"cloud.google.com/go/storage"
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/api/iterator"
+)
+
+func Test_Iterator(t *testing.T) {
+	h, srv := newTestHelper(t)
+	ctx := context.Background()
+
+	for _, f := range []string{"dir/a.txt", "dir/b.txt", "dir/c.txt", "dir/d.txt", "dir/e.csv", "dir/sub/f.txt"} {
+		srv.WriteObject("bucket", f, []byte(f))
+	}
+
+	t.Run("Next", func(t *testing.T) {
+		it, err := IterateObjectsWithPrefix(ctx, h.Client, "gs://bucket/dir/sub")
+		if err != nil {
+			t.Fatalf("IterateObjectsWithPrefix failed; error: %v", err)
+		}
+		p, attrs, err := it.Next()
+		if err != nil {
+			t.Fatalf("Next failed; error: %v", err)
+		}
+		if d := cmp.Diff(&GcsPath{Bucket: "bucket", Path: "dir/sub/f.txt"}, p); d != "" {
+			t.Errorf("Unexpected path; diff:\n%v", d)
+		}
+		if attrs.Size != int64(len("dir/sub/f.txt")) {
+			t.Errorf("Got size %v; want %v", attrs.Size, len("dir/sub/f.txt"))
+		}
+		if _, _, err := it.Next(); err != iterator.Done {
+			t.Errorf("Got %v; want iterator.Done", err)
+		}
+	})
+
+	t.Run("StopEarly", func(t *testing.T) {
+		it, err := h.GlobIterator(ctx, "gs://bucket/dir/*.txt")
+		if err != nil {
+			t.Fatalf("GlobIterator failed; error: %v", err)
+		}
+		it.PageInfo().MaxSize = 2
+
+		actual := []string{}
+		err = it.Walk(func(p *GcsPath, _ *storage.ObjectAttrs) error {
+			actual = append(actual, p.ToURI())
+			if len(actual) == 3 {
+				return ErrStop
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Walk failed; error: %v", err)
+		}
+		expected := []string{"gs://bucket/dir/a.txt", "gs://bucket/dir/b.txt", "gs://bucket/dir/c.txt"}
+		if d := cmp.Diff(expected, actual); d != "" {
+			t.Errorf("Unexpected objects; diff:\n%v", d)
+		}
+	})
+
+	t.Run("Regex", func(t *testing.T) {
+		it, err := IterateObjects(ctx, h.Client, `gs://bucket/dir/[a-c]\.txt`)
+		if err != nil {
+			t.Fatalf("IterateObjects failed; error: %v", err)
+		}
+		actual, err := it.uris()
+		if err != nil {
+			t.Fatalf("uris failed; error: %v", err)
+		}
+		expected := []string{"gs://bucket/dir/a.txt", "gs://bucket/dir/b.txt", "gs://bucket/dir/c.txt"}
+		if d := cmp.Diff(expected, actual); d != "" {
+			t.Errorf("Unexpected objects; diff:\n%v", d)
+		}
+	})
+
+	t.Run("WalkError", func(t *testing.T) {
+		it, err := IterateObjectsWithPrefix(ctx, h.Client, "gs://bucket/dir/")
+		if err != nil {
+			t.Fatalf("IterateObjectsWithPrefix failed; error: %v", err)
+		}
+		want := errors.New("some error")
+		err = it.Walk(func(_ *GcsPath, _ *storage.ObjectAttrs) error {
+			return want
+		})
+		if !errors.Is(err, want) {
+			t.Errorf("Got %v; want %v", err, want)
+		}
+	})
+
+	t.Run("Canceled", func(t *testing.T) {
+		cctx, cancel := context.WithCancel(ctx)
+		cancel()
+		it, err := IterateObjectsWithPrefix(cctx, h.Client, "gs://bucket/dir/")
+		if err != nil {
+			t.Fatalf("IterateObjectsWithPrefix failed; error: %v", err)
+		}
+		if _, _, err := it.Next(); err == nil || err == iterator.Done {
+			t.Errorf("Got %v; want an error because the context was canceled", err)
+		}
+	})
+}
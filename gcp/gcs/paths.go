@@ -1,7 +1,12 @@
 package gcs
 
 import (
+	"net"
 	"path"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
 )
 
 // Dir should have the same semantics as Path.Dir except it should work with URIs
@@ -13,12 +18,7 @@ func Dir(uri string) string {
 		return path.Dir(uri)
 	}
 
-	objDir := path.Dir(gcsPath.Path)
-
-	p := &GcsPath{Path: objDir,
-		Bucket: gcsPath.Bucket,
-	}
-	return p.ToURI()
+	return gcsPath.Parent().ToURI()
 }
 
 // Base should have the same semantics as Path.Base except it should work with URIs
@@ -30,5 +30,170 @@ func Base(uri string) string {
 		return path.Base(uri)
 	}
 
-	return path.Base(gcsPath.Path)
+	return gcsPath.Base()
+}
+
+// Join returns a new path with elem appended to the object name; like path.Join the result is cleaned.
+// An error is returned if the result isn't a valid object name, e.g. because ".." elements would take it outside
+// the bucket. The result never has a generation since it names a different object.
+func (p *GcsPath) Join(elem ...string) (*GcsPath, error) {
+	joined := path.Join(append([]string{p.Path}, elem...)...)
+	if joined == ".." || strings.HasPrefix(joined, "../") {
+		return nil, errors.Errorf("Can't join %v to %v; the result is outside the bucket", elem, p.ToURI())
+	}
+	if joined == "." {
+		joined = ""
+	}
+
+	r := &GcsPath{
+		Bucket: p.Bucket,
+		Path:   joined,
+	}
+	if r.Path != "" {
+		if err := ValidateObjectName(r.Path); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// Parent returns the path with the last element removed; like path.Dir. The parent of an object at the root of
+// the bucket is the bucket i.e. a path with an empty object name.
+func (p *GcsPath) Parent() *GcsPath {
+	dir := path.Dir(p.Path)
+	if dir == "." || dir == "/" {
+		dir = ""
+	}
+	return &GcsPath{
+		Bucket: p.Bucket,
+		Path:   dir,
+	}
+}
+
+// Base returns the last element of the object name; like path.Base.
+func (p *GcsPath) Base() string {
+	return path.Base(p.Path)
+}
+
+// Ext returns the file name extension of the object name; like path.Ext.
+func (p *GcsPath) Ext() string {
+	return path.Ext(p.Path)
+}
+
+// IsPrefixOf reports whether other is p or is inside p when p is treated as a directory; i.e. gs://b/dir is a
+// prefix of gs://b/dir/object but not gs://b/dir2/object. Generations are ignored.
+func (p *GcsPath) IsPrefixOf(other *GcsPath) bool {
+	if p.Bucket != other.Bucket {
+		return false
+	}
+	if p.Path == "" || p.Path == other.Path {
+		return true
+	}
+	dir := p.Path
+	if !strings.HasSuffix(dir, "/") {
+		dir = dir + "/"
+	}
+	return strings.HasPrefix(other.Path, dir)
+}
+
+// Rel returns a relative path that is lexically equivalent to target when joined to p; like filepath.Rel.
+// An error is returned if target is in a different bucket.
+func (p *GcsPath) Rel(target *GcsPath) (string, error) {
+	if p.Bucket != target.Bucket {
+		return "", errors.Errorf("Can't make %v relative to %v; they are in different buckets", target.ToURI(), p.ToURI())
+	}
+	base := splitPath(p.Path)
+	targ := splitPath(target.Path)
+
+	i := 0
+	for i < len(base) && i < len(targ) && base[i] == targ[i] {
+		i++
+	}
+	rel := []string{}
+	for j := i; j < len(base); j++ {
+		rel = append(rel, "..")
+	}
+	rel = append(rel, targ[i:]...)
+	if len(rel) == 0 {
+		return ".", nil
+	}
+	return strings.Join(rel, "/"), nil
+}
+
+// splitPath splits a cleaned object name into its elements.
+func splitPath(name string) []string {
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	if name == "" {
+		return nil
+	}
+	return strings.Split(name, "/")
+}
+
+// Validate checks that the bucket and object names follow the GCS naming rules; see ValidateBucketName and
+// ValidateObjectName. An empty object name is allowed since the path can refer to the bucket.
+func (p *GcsPath) Validate() error {
+	if err := ValidateBucketName(p.Bucket); err != nil {
+		return err
+	}
+	if p.Path == "" {
+		return nil
+	}
+	return ValidateObjectName(p.Path)
+}
+
+// ValidateBucketName checks name against the bucket naming rules
+// https://cloud.google.com/storage/docs/buckets#naming
+func ValidateBucketName(name string) error {
+	maxLen := 63
+	if strings.Contains(name, ".") {
+		maxLen = 222
+	}
+	if len(name) < 3 || len(name) > maxLen {
+		return errors.Errorf("Invalid bucket name %q; it must contain 3-63 characters or up to 222 if it contains dots", name)
+	}
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return errors.Errorf("Invalid bucket name %q; it can only contain lowercase letters, numbers, dashes, underscores and dots", name)
+		}
+	}
+	if !isAlphaNum(name[0]) || !isAlphaNum(name[len(name)-1]) {
+		return errors.Errorf("Invalid bucket name %q; it must start and end with a letter or number", name)
+	}
+	for _, component := range strings.Split(name, ".") {
+		if len(component) > 63 {
+			return errors.Errorf("Invalid bucket name %q; each dot separated component can contain at most 63 characters", name)
+		}
+	}
+	if net.ParseIP(name) != nil {
+		return errors.Errorf("Invalid bucket name %q; it can't be an IP address", name)
+	}
+	if strings.HasPrefix(name, "goog") || strings.Contains(name, "google") {
+		return errors.Errorf("Invalid bucket name %q; it can't begin with goog or contain google", name)
+	}
+	return nil
+}
+
+// ValidateObjectName checks name against the object naming rules
+// https://cloud.google.com/storage/docs/objects#naming
+func ValidateObjectName(name string) error {
+	if len(name) == 0 || len(name) > 1024 {
+		return errors.Errorf("Invalid object name %q; it must contain 1-1024 bytes", name)
+	}
+	if !utf8.ValidString(name) {
+		return errors.Errorf("Invalid object name %q; it must be valid UTF-8", name)
+	}
+	if strings.ContainsAny(name, "\r\n") {
+		return errors.Errorf("Invalid object name %q; it can't contain carriage return or line feed characters", name)
+	}
+	if name == "." || name == ".." {
+		return errors.Errorf("Invalid object name %q", name)
+	}
+	if strings.HasPrefix(name, ".well-known/acme-challenge/") {
+		return errors.Errorf("Invalid object name %q; it can't begin with .well-known/acme-challenge/", name)
+	}
+	return nil
+}
+
+func isAlphaNum(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= '0' && c <= '9'
 }
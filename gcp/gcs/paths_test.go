@@ -55,3 +55,154 @@ func Test_Base(t *testing.T) {
 		}
 	}
 }
+
+func Test_GcsPathJoin(t *testing.T) {
+	type testCase struct {
+		input    string
+		elem     []string
+		expected string
+		wantErr  bool
+	}
+
+	testCases := []testCase{
+		{input: "gs://bucket", elem: []string{"dirA", "file.txt"}, expected: "gs://bucket/dirA/file.txt"},
+		{input: "gs://bucket/dirA/", elem: []string{"file.txt"}, expected: "gs://bucket/dirA/file.txt"},
+		{input: "gs://bucket/dirA#123", elem: []string{"file.txt"}, expected: "gs://bucket/dirA/file.txt"},
+		{input: "gs://bucket/dirA/dirB", elem: []string{"..", "file.txt"}, expected: "gs://bucket/dirA/file.txt"},
+		{input: "gs://bucket/dirA", elem: []string{"..", ".."}, wantErr: true},
+		{input: "gs://bucket/dirA", elem: []string{".."}, expected: "gs://bucket"},
+		{input: "gs://bucket/dirA", elem: []string{"/file.txt"}, expected: "gs://bucket/dirA/file.txt"},
+		{input: "gs://bucket/dirA", elem: []string{"bad\nname"}, wantErr: true},
+	}
+
+	for _, c := range testCases {
+		p, err := Parse(c.input)
+		if err != nil {
+			t.Fatalf("Failed to parse %v; error: %v", c.input, err)
+		}
+		actual, err := p.Join(c.elem...)
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("Input: %v %v; Join should have failed", c.input, c.elem)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Input: %v %v; Join failed; error: %v", c.input, c.elem, err)
+			continue
+		}
+		if actual.ToURI() != c.expected {
+			t.Errorf("Input: %v %v; Got %v; Want %v", c.input, c.elem, actual.ToURI(), c.expected)
+		}
+	}
+}
+
+func Test_GcsPathMethods(t *testing.T) {
+	type testCase struct {
+		input  string
+		parent string
+		base   string
+		ext    string
+	}
+
+	testCases := []testCase{
+		{input: "gs://bucket/dirA/file.tar.gz", parent: "gs://bucket/dirA", base: "file.tar.gz", ext: ".gz"},
+		{input: "gs://bucket/file.txt", parent: "gs://bucket", base: "file.txt", ext: ".txt"},
+		{input: "gs://bucket/dirA/dirB", parent: "gs://bucket/dirA", base: "dirB", ext: ""},
+		{input: "gs://bucket", parent: "gs://bucket", base: ".", ext: ""},
+	}
+
+	for _, c := range testCases {
+		p, err := Parse(c.input)
+		if err != nil {
+			t.Fatalf("Failed to parse %v; error: %v", c.input, err)
+		}
+		if actual := p.Parent().ToURI(); actual != c.parent {
+			t.Errorf("Input: %v; Parent() = %v; Want %v", c.input, actual, c.parent)
+		}
+		if actual := p.Base(); actual != c.base {
+			t.Errorf("Input: %v; Base() = %v; Want %v", c.input, actual, c.base)
+		}
+		if actual := p.Ext(); actual != c.ext {
+			t.Errorf("Input: %v; Ext() = %v; Want %v", c.input, actual, c.ext)
+		}
+	}
+}
+
+func Test_GcsPathIsPrefixOfAndRel(t *testing.T) {
+	type testCase struct {
+		base     string
+		target   string
+		isPrefix bool
+		rel      string
+		wantErr  bool
+	}
+
+	testCases := []testCase{
+		{base: "gs://bucket/dirA", target: "gs://bucket/dirA/dirB/file.txt", isPrefix: true, rel: "dirB/file.txt"},
+		{base: "gs://bucket/dirA/", target: "gs://bucket/dirA/file.txt", isPrefix: true, rel: "file.txt"},
+		{base: "gs://bucket/dirA", target: "gs://bucket/dirA", isPrefix: true, rel: "."},
+		{base: "gs://bucket", target: "gs://bucket/dirA/file.txt", isPrefix: true, rel: "dirA/file.txt"},
+		{base: "gs://bucket/dirA", target: "gs://bucket/dirA2/file.txt", isPrefix: false, rel: "../dirA2/file.txt"},
+		{base: "gs://bucket/dirA", target: "gs://other/dirA/file.txt", isPrefix: false, wantErr: true},
+	}
+
+	for _, c := range testCases {
+		base, err := Parse(c.base)
+		if err != nil {
+			t.Fatalf("Failed to parse %v; error: %v", c.base, err)
+		}
+		target, err := Parse(c.target)
+		if err != nil {
+			t.Fatalf("Failed to parse %v; error: %v", c.target, err)
+		}
+		if actual := base.IsPrefixOf(target); actual != c.isPrefix {
+			t.Errorf("%v.IsPrefixOf(%v) = %v; Want %v", c.base, c.target, actual, c.isPrefix)
+		}
+		rel, err := base.Rel(target)
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("%v.Rel(%v) should have failed", c.base, c.target)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v.Rel(%v) failed; error: %v", c.base, c.target, err)
+			continue
+		}
+		if rel != c.rel {
+			t.Errorf("%v.Rel(%v) = %v; Want %v", c.base, c.target, rel, c.rel)
+		}
+	}
+}
+
+func Test_Validate(t *testing.T) {
+	type testCase struct {
+		input   string
+		wantErr bool
+	}
+
+	testCases := []testCase{
+		{input: "gs://my-bucket_1/some/object.txt", wantErr: false},
+		{input: "gs://my.bucket.example.com", wantErr: false},
+		{input: "gs://ab/object", wantErr: true},
+		{input: "gs://My-Bucket/object", wantErr: true},
+		{input: "gs://-bucket/object", wantErr: true},
+		{input: "gs://192.168.5.4/object", wantErr: true},
+		{input: "gs://goog-bucket/object", wantErr: true},
+		{input: "gs://my-google-bucket/object", wantErr: true},
+		{input: "gs://bucket/.well-known/acme-challenge/token", wantErr: true},
+		{input: "gs://bucket/..", wantErr: true},
+	}
+
+	for _, c := range testCases {
+		p, err := Parse(c.input)
+		if err != nil {
+			t.Fatalf("Failed to parse %v; error: %v", c.input, err)
+		}
+		err = p.Validate()
+		if (err != nil) != c.wantErr {
+			t.Errorf("Input: %v; Validate() = %v; want error: %v", c.input, err, c.wantErr)
+		}
+	}
+}
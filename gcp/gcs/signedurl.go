@@ -0,0 +1,119 @@
+package gcs
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/pkg/errors"
+	iamcredentials "google.golang.org/api/iamcredentials/v1"
+	"google.golang.org/api/option"
+)
+
+const (
+	// MaxSignedURLExpiration is the longest a V4 signed URL can be valid for.
+	MaxSignedURLExpiration = 7 * 24 * time.Hour
+)
+
+// URLSigner is the identity used to sign URLs. Use NewKeyFileSigner or NewIAMSigner to create one.
+type URLSigner struct {
+	// GoogleAccessID is the email of the service account that signs the URL.
+	GoogleAccessID string
+
+	// Exactly one of privateKey and signBytes is set.
+	privateKey []byte
+	signBytes  func([]byte) ([]byte, error)
+}
+
+// NewKeyFileSigner creates a signer from a service account's JSON key file.
+func NewKeyFileSigner(keyFile string) (*URLSigner, error) {
+	b, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to read key file %v", keyFile)
+	}
+
+	key := struct {
+		Type        string `json:"type"`
+		ClientEmail string `json:"client_email"`
+		PrivateKey  string `json:"private_key"`
+	}{}
+	if err := json.Unmarshal(b, &key); err != nil {
+		return nil, errors.Wrapf(err, "Failed to parse key file %v", keyFile)
+	}
+	if key.Type != "service_account" || key.ClientEmail == "" || key.PrivateKey == "" {
+		return nil, errors.Errorf("Key file %v isn't a service account key; it must contain client_email and private_key", keyFile)
+	}
+	return &URLSigner{
+		GoogleAccessID: key.ClientEmail,
+		privateKey:     []byte(key.PrivateKey),
+	}, nil
+}
+
+// NewIAMSigner creates a signer that signs URLs as serviceAccount using the IAM Credentials signBlob API.
+// This doesn't require a key file; the caller's credentials need the iam.serviceAccounts.signBlob permission
+// on the service account e.g. via roles/iam.serviceAccountTokenCreator.
+//
+// opts are passed to the IAM Credentials client e.g. to supply credentials.
+func NewIAMSigner(ctx context.Context, serviceAccount string, opts ...option.ClientOption) (*URLSigner, error) {
+	if serviceAccount == "" {
+		return nil, errors.New("A service account is required to sign URLs using IAM")
+	}
+	svc, err := iamcredentials.NewService(ctx, opts...)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to create IAM credentials client")
+	}
+
+	name := "projects/-/serviceAccounts/" + serviceAccount
+	return &URLSigner{
+		GoogleAccessID: serviceAccount,
+		signBytes: func(b []byte) ([]byte, error) {
+			req := &iamcredentials.SignBlobRequest{
+				Payload: base64.StdEncoding.EncodeToString(b),
+			}
+			resp, err := svc.Projects.ServiceAccounts.SignBlob(name, req).Context(ctx).Do()
+			if err != nil {
+				return nil, errors.Wrapf(err, "Failed to sign blob as %v", serviceAccount)
+			}
+			return base64.StdEncoding.DecodeString(resp.SignedBlob)
+		},
+	}, nil
+}
+
+// SignedURL returns a V4 signed URL that allows anyone holding it to use method e.g. GET or PUT on the object
+// uri for the duration expires.
+func SignedURL(uri string, method string, expires time.Duration, signer *URLSigner) (string, error) {
+	p, err := Parse(uri)
+	if err != nil {
+		return "", err
+	}
+	if p.Path == "" {
+		return "", errors.Errorf("Can't sign %v; URI must be an object not a bucket", uri)
+	}
+	if expires <= 0 || expires > MaxSignedURLExpiration {
+		return "", errors.Errorf("Invalid expiration %v; V4 signed URLs must expire within %v", expires, MaxSignedURLExpiration)
+	}
+	if signer == nil {
+		return "", errors.New("A signer is required to sign URLs")
+	}
+	if method == "" {
+		method = "GET"
+	}
+
+	opts := &storage.SignedURLOptions{
+		GoogleAccessID: signer.GoogleAccessID,
+		PrivateKey:     signer.privateKey,
+		SignBytes:      signer.signBytes,
+		Method:         strings.ToUpper(method),
+		Expires:        time.Now().Add(expires),
+		Scheme:         storage.SigningSchemeV4,
+	}
+	u, err := storage.SignedURL(p.Bucket, p.Path, opts)
+	if err != nil {
+		return "", errors.Wrapf(err, "Failed to sign URL for %v", uri)
+	}
+	return u, nil
+}
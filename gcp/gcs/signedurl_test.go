@@ -0,0 +1,122 @@
+package gcs
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/api/option"
+)
+
+func Test_SignedURLKeyFile(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("Failed to marshal key: %v", err)
+	}
+	keyFile := map[string]string{
+		"type":         "service_account",
+		"client_email": "signer@project.iam.gserviceaccount.com",
+		"private_key":  string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+	}
+	b, err := json.Marshal(keyFile)
+	if err != nil {
+		t.Fatalf("Failed to marshal key file: %v", err)
+	}
+	keyPath := filepath.Join(t.TempDir(), "key.json")
+	if err := os.WriteFile(keyPath, b, 0600); err != nil {
+		t.Fatalf("Failed to write key file: %v", err)
+	}
+
+	signer, err := NewKeyFileSigner(keyPath)
+	if err != nil {
+		t.Fatalf("NewKeyFileSigner returned error: %v", err)
+	}
+
+	type testCase struct {
+		name    string
+		uri     string
+		expires time.Duration
+		wantErr bool
+	}
+
+	cases := []testCase{
+		{name: "object", uri: "gs://bucket/dir/file.txt", expires: time.Hour},
+		{name: "bucket", uri: "gs://bucket", expires: time.Hour, wantErr: true},
+		{name: "too-long", uri: "gs://bucket/file.txt", expires: 8 * 24 * time.Hour, wantErr: true},
+		{name: "not-gcs", uri: "/tmp/file.txt", expires: time.Hour, wantErr: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			actual, err := SignedURL(c.uri, "get", c.expires, signer)
+			if c.wantErr {
+				if err == nil {
+					t.Fatalf("SignedURL(%v) should have failed", c.uri)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("SignedURL(%v) returned error: %v", c.uri, err)
+			}
+			u, err := url.Parse(actual)
+			if err != nil {
+				t.Fatalf("Failed to parse signed URL %v: %v", actual, err)
+			}
+			if !strings.HasSuffix(u.Path, "/bucket/dir/file.txt") {
+				t.Errorf("Signed URL %v doesn't point at the object", actual)
+			}
+			if cred := u.Query().Get("X-Goog-Credential"); !strings.HasPrefix(cred, keyFile["client_email"]) {
+				t.Errorf("Signed URL has credential %q; want it to start with %v", cred, keyFile["client_email"])
+			}
+		})
+	}
+}
+
+func Test_SignedURLIAM(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if !strings.HasSuffix(r.URL.Path, "/serviceAccounts/signer@project.iam.gserviceaccount.com:signBlob") {
+			http.Error(w, "unexpected path "+r.URL.Path, http.StatusNotFound)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"keyId":      "key",
+			"signedBlob": base64.StdEncoding.EncodeToString([]byte("signature")),
+		})
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	signer, err := NewIAMSigner(ctx, "signer@project.iam.gserviceaccount.com", option.WithEndpoint(srv.URL+"/"), option.WithoutAuthentication())
+	if err != nil {
+		t.Fatalf("NewIAMSigner returned error: %v", err)
+	}
+
+	actual, err := SignedURL("gs://bucket/file.txt", "PUT", time.Minute, signer)
+	if err != nil {
+		t.Fatalf("SignedURL returned error: %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("signBlob was called %v times; want 1", calls)
+	}
+	if !strings.Contains(actual, "X-Goog-Signature=") {
+		t.Errorf("Signed URL %v is missing the signature", actual)
+	}
+}
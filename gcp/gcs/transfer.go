@@ -0,0 +1,465 @@
+package gcs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-logr/zapr"
+	"github.com/jlewi/monogo/helpers"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"google.golang.org/api/googleapi"
+)
+
+const (
+	defaultTransferWorkers     = 8
+	defaultTransferMaxAttempts = 3
+	defaultTransferBackoff     = time.Second
+	maxTransferBackoff         = time.Minute
+)
+
+// TransferOptions controls UploadDir and DownloadPrefix. The zero value uses the defaults.
+type TransferOptions struct {
+	// Workers is the number of objects transferred concurrently. Defaults to 8.
+	Workers int
+	// MaxAttempts is the number of times to try transferring each object. Defaults to 3.
+	MaxAttempts int
+	// InitialBackoff is how long to wait before the first retry; it doubles after each attempt. Defaults to 1s.
+	InitialBackoff time.Duration
+	// ManifestPath is the path of a local file recording completed transfers. If it is set, objects the manifest
+	// shows were already transferred and haven't changed since are skipped. This allows an interrupted transfer
+	// to be resumed by rerunning it with the same manifest.
+	ManifestPath string
+	// Progress, if set, is called each time an object is transferred, skipped or fails. Calls are serialized.
+	Progress func(p TransferProgress)
+}
+
+// TransferProgress reports the outcome of transferring a single object.
+type TransferProgress struct {
+	Src string
+	Dst string
+	// Bytes is the size of the object.
+	Bytes int64
+	// Skipped is true if the object was skipped because the manifest shows it was already transferred.
+	Skipped bool
+	// Err is the error if the transfer failed after all attempts.
+	Err error
+	// Done is the number of objects processed so far, including this one, out of Total.
+	Done  int
+	Total int
+}
+
+// TransferResult summarizes a bulk transfer.
+type TransferResult struct {
+	// Transferred are the destinations that were copied.
+	Transferred []string
+	// Skipped are the destinations that were skipped because they were already up to date.
+	Skipped []string
+	// Bytes is the number of bytes transferred.
+	Bytes int64
+}
+
+// transferItem is a single object to transfer.
+type transferItem struct {
+	src        string
+	dst        string
+	size       int64
+	modTime    time.Time
+	generation int64
+}
+
+// manifestEntry records a completed transfer. The manifest is a file with one JSON entry per line so entries can be
+// appended as transfers complete and a partially written last line is simply ignored.
+type manifestEntry struct {
+	Src  string `json:"src"`
+	Dst  string `json:"dst"`
+	Size int64  `json:"size"`
+	// ModTime is the modification time of the local file for uploads.
+	ModTime time.Time `json:"modTime,omitempty"`
+	// Generation is the generation of the source object for downloads.
+	Generation int64 `json:"generation,omitempty"`
+}
+
+// UploadDir uploads all the files in localDir to the directory dstURI preserving their relative paths.
+//
+// Failures of individual files don't stop the transfer; if any files fail the error is a *helpers.ListOfErrors
+// with one cause per failed file.
+func (h *GcsHelper) UploadDir(ctx context.Context, localDir string, dstURI string, opts *TransferOptions) (*TransferResult, error) {
+	dst, err := Parse(dstURI)
+	if err != nil {
+		return nil, err
+	}
+
+	items := []transferItem{}
+	err = filepath.WalkDir(localDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(localDir, p)
+		if err != nil {
+			return err
+		}
+		o, err := dst.Join(filepath.ToSlash(rel))
+		if err != nil {
+			return err
+		}
+		items = append(items, transferItem{
+			src:     p,
+			dst:     o.ToURI(),
+			size:    info.Size(),
+			modTime: info.ModTime(),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to list files in %v", localDir)
+	}
+
+	isDone := func(i transferItem, e manifestEntry) bool {
+		return e.Size == i.size && e.ModTime.Equal(i.modTime)
+	}
+	return h.transfer(ctx, items, opts, isDone, h.uploadFile)
+}
+
+// DownloadPrefix downloads all the objects in the directory srcURI to localDir preserving their relative paths.
+//
+// Failures of individual objects don't stop the transfer; if any objects fail the error is a *helpers.ListOfErrors
+// with one cause per failed object.
+func (h *GcsHelper) DownloadPrefix(ctx context.Context, srcURI string, localDir string, opts *TransferOptions) (*TransferResult, error) {
+	src, err := Parse(srcURI)
+	if err != nil {
+		return nil, err
+	}
+
+	infos, err := h.List(ctx, srcURI)
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]transferItem, 0, len(infos))
+	for _, info := range infos {
+		p, err := Parse(info.URI)
+		if err != nil {
+			return nil, err
+		}
+		// Skip placeholder objects used by some tools to represent directories.
+		if strings.HasSuffix(p.Path, "/") {
+			continue
+		}
+		rel, err := src.Rel(p)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, transferItem{
+			src:        info.URI,
+			dst:        filepath.Join(localDir, filepath.FromSlash(rel)),
+			size:       info.Size,
+			generation: info.Generation,
+		})
+	}
+
+	isDone := func(i transferItem, e manifestEntry) bool {
+		if e.Generation != i.generation {
+			return false
+		}
+		// Make sure the file wasn't deleted or modified locally since it was downloaded.
+		info, err := os.Stat(i.dst)
+		return err == nil && info.Size() == e.Size
+	}
+	return h.transfer(ctx, items, opts, isDone, h.downloadObject)
+}
+
+// transfer runs copyItem for each item using a pool of workers.
+func (h *GcsHelper) transfer(ctx context.Context, items []transferItem, opts *TransferOptions, isDone func(transferItem, manifestEntry) bool, copyItem func(context.Context, transferItem) error) (*TransferResult, error) {
+	log := zapr.NewLogger(zap.L())
+	if opts == nil {
+		opts = &TransferOptions{}
+	}
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = defaultTransferWorkers
+	}
+
+	m, err := openManifest(opts.ManifestPath)
+	if err != nil {
+		return nil, err
+	}
+	defer helpers.DeferIgnoreError(m.Close)
+
+	result := &TransferResult{
+		Transferred: []string{},
+		Skipped:     []string{},
+	}
+	failures := &helpers.ListOfErrors{}
+	done := 0
+
+	// mu serializes updates to the result and calls to Progress.
+	mu := sync.Mutex{}
+	report := func(i transferItem, skipped bool, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		done++
+		switch {
+		case err != nil:
+			failures.AddCause(errors.Wrapf(err, "Failed to copy %v to %v", i.src, i.dst))
+		case skipped:
+			result.Skipped = append(result.Skipped, i.dst)
+		default:
+			result.Transferred = append(result.Transferred, i.dst)
+			result.Bytes += i.size
+		}
+		if opts.Progress != nil {
+			opts.Progress(TransferProgress{
+				Src:     i.src,
+				Dst:     i.dst,
+				Bytes:   i.size,
+				Skipped: skipped,
+				Err:     err,
+				Done:    done,
+				Total:   len(items),
+			})
+		}
+	}
+
+	work := make(chan transferItem)
+	wg := sync.WaitGroup{}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				if e, ok := m.lookup(i.src); ok && e.Dst == i.dst && isDone(i, e) {
+					report(i, true, nil)
+					continue
+				}
+				err := retry(ctx, opts, func() error {
+					return copyItem(ctx, i)
+				})
+				if err == nil {
+					if mErr := m.record(i); mErr != nil {
+						log.Error(mErr, "Failed to update the transfer manifest", "src", i.src)
+					}
+				}
+				report(i, false, err)
+			}
+		}()
+	}
+
+	for _, i := range items {
+		if ctx.Err() != nil {
+			break
+		}
+		work <- i
+	}
+	close(work)
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return result, errors.Wrapf(err, "Transfer was cancelled after %v of %v objects", done, len(items))
+	}
+	if len(failures.Causes) > 0 {
+		failures.Final = errors.Errorf("Failed to transfer %v of %v objects", len(failures.Causes), len(items))
+		return result, failures
+	}
+	return result, nil
+}
+
+func (h *GcsHelper) uploadFile(ctx context.Context, i transferItem) error {
+	f, err := os.Open(i.src)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to open %v", i.src)
+	}
+	defer helpers.DeferIgnoreError(f.Close)
+
+	// Cancelling the context aborts the upload so a partial object is never created.
+	wCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	w, err := h.NewWriterContext(wCtx, i.dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, f); err != nil {
+		cancel()
+		helpers.IgnoreError(w.Close())
+		return errors.Wrapf(err, "Failed to upload %v", i.src)
+	}
+	return w.Close()
+}
+
+func (h *GcsHelper) downloadObject(ctx context.Context, i transferItem) error {
+	r, err := h.NewReaderContext(ctx, i.src)
+	if err != nil {
+		return err
+	}
+	defer helpers.DeferIgnoreError(r.Close)
+
+	dir := filepath.Dir(i.dst)
+	if err := os.MkdirAll(dir, helpers.UserGroupAllPerm); err != nil {
+		return errors.Wrapf(err, "Failed to create directory %v", dir)
+	}
+
+	// Download to a temporary file and rename it so a partial download is never mistaken for a complete one.
+	f, err := os.CreateTemp(dir, "."+filepath.Base(i.dst)+".tmp-*")
+	if err != nil {
+		return errors.Wrapf(err, "Failed to create temporary file for %v", i.dst)
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		helpers.IgnoreError(f.Close())
+		helpers.IgnoreError(os.Remove(f.Name()))
+		return errors.Wrapf(err, "Failed to download %v", i.src)
+	}
+	if err := f.Close(); err != nil {
+		helpers.IgnoreError(os.Remove(f.Name()))
+		return errors.Wrapf(err, "Failed to write %v", f.Name())
+	}
+	if err := os.Rename(f.Name(), i.dst); err != nil {
+		helpers.IgnoreError(os.Remove(f.Name()))
+		return errors.Wrapf(err, "Failed to rename %v to %v", f.Name(), i.dst)
+	}
+	return nil
+}
+
+// retry calls f until it succeeds, fails with an error that isn't retryable or runs out of attempts.
+func retry(ctx context.Context, opts *TransferOptions, f func() error) error {
+	attempts := opts.MaxAttempts
+	if attempts <= 0 {
+		attempts = defaultTransferMaxAttempts
+	}
+	backoff := opts.InitialBackoff
+	if backoff <= 0 {
+		backoff = defaultTransferBackoff
+	}
+
+	var err error
+	for a := 1; ; a++ {
+		err = f()
+		if err == nil || a >= attempts || !isRetryable(err) {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > maxTransferBackoff {
+			backoff = maxTransferBackoff
+		}
+	}
+}
+
+// isRetryable returns false for errors that won't go away by retrying e.g. a missing object.
+func isRetryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if errors.Is(err, ErrBucketNotFound) || errors.Is(err, ErrObjectNotFound) || errors.Is(err, ErrPermissionDenied) {
+		return false
+	}
+	if errors.Is(err, fs.ErrNotExist) || errors.Is(err, fs.ErrPermission) {
+		return false
+	}
+	gErr := &googleapi.Error{}
+	if errors.As(err, &gErr) {
+		return gErr.Code >= http.StatusInternalServerError || gErr.Code == http.StatusTooManyRequests || gErr.Code == http.StatusRequestTimeout
+	}
+	return true
+}
+
+// manifest tracks completed transfers. A manifest without a path doesn't record anything.
+type manifest struct {
+	mu      sync.Mutex
+	f       *os.File
+	entries map[string]manifestEntry
+}
+
+func openManifest(p string) (*manifest, error) {
+	m := &manifest{entries: map[string]manifestEntry{}}
+	if p == "" {
+		return m, nil
+	}
+
+	f, err := os.OpenFile(p, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to open manifest %v", p)
+	}
+
+	data, err := io.ReadAll(f)
+	if err != nil {
+		helpers.IgnoreError(f.Close())
+		return nil, errors.Wrapf(err, "Failed to read manifest %v", p)
+	}
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		e := manifestEntry{}
+		if err := json.Unmarshal(line, &e); err != nil {
+			// Most likely the line was only partially written because the transfer was interrupted.
+			continue
+		}
+		m.entries[e.Src] = e
+	}
+
+	// If the last line was only partially written terminate it so new entries start on their own line.
+	if len(data) > 0 && data[len(data)-1] != '\n' {
+		if _, err := f.Write([]byte("\n")); err != nil {
+			helpers.IgnoreError(f.Close())
+			return nil, errors.Wrapf(err, "Failed to write manifest %v", p)
+		}
+	}
+	m.f = f
+	return m, nil
+}
+
+func (m *manifest) lookup(src string) (manifestEntry, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.entries[src]
+	return e, ok
+}
+
+func (m *manifest) record(i transferItem) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e := manifestEntry{
+		Src:        i.src,
+		Dst:        i.dst,
+		Size:       i.size,
+		ModTime:    i.modTime,
+		Generation: i.generation,
+	}
+	m.entries[e.Src] = e
+	if m.f == nil {
+		return nil
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = m.f.Write(append(b, '\n'))
+	return err
+}
+
+func (m *manifest) Close() error {
+	if m.f == nil {
+		return nil
+	}
+	return m.f.Close()
+}
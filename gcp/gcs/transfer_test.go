@@ -0,0 +1,151 @@
+package gcs
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_UploadDownload(t *testing.T) {
+	h, srv := newTestHelper(t)
+	srv.CreateBucket("bucket")
+	ctx := context.Background()
+
+	tDir, err := os.MkdirTemp("", "testTransfer")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(tDir)
+
+	srcDir := filepath.Join(tDir, "src")
+	files := map[string]string{
+		"a.txt":       "a",
+		"sub/b.txt":   "bb",
+		"sub/c/d.txt": "ddd",
+	}
+	for name, contents := range files {
+		p := filepath.Join(srcDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatalf("MkdirAll error: %v", err)
+		}
+		if err := os.WriteFile(p, []byte(contents), 0644); err != nil {
+			t.Fatalf("WriteFile error: %v", err)
+		}
+	}
+
+	manifest := filepath.Join(tDir, "upload.manifest")
+	progress := []string{}
+	opts := &TransferOptions{
+		Workers:      2,
+		ManifestPath: manifest,
+		Progress: func(p TransferProgress) {
+			progress = append(progress, p.Dst)
+		},
+	}
+
+	result, err := h.UploadDir(ctx, srcDir, "gs://bucket/dst", opts)
+	if err != nil {
+		t.Fatalf("UploadDir error: %v", err)
+	}
+	sort.Strings(result.Transferred)
+	expected := []string{"gs://bucket/dst/a.txt", "gs://bucket/dst/sub/b.txt", "gs://bucket/dst/sub/c/d.txt"}
+	if d := cmp.Diff(expected, result.Transferred); d != "" {
+		t.Errorf("Unexpected uploads (-want +got):\n%s", d)
+	}
+	if len(progress) != len(files) {
+		t.Errorf("Progress was called %v times; want %v", len(progress), len(files))
+	}
+	if data, _ := srv.ReadObject("bucket", "dst/sub/c/d.txt"); string(data) != "ddd" {
+		t.Errorf("Uploaded object has contents %q; want %q", data, "ddd")
+	}
+
+	// Rerunning the upload with the manifest should skip everything except modified files.
+	bPath := filepath.Join(srcDir, "sub", "b.txt")
+	if err := os.WriteFile(bPath, []byte("changed"), 0644); err != nil {
+		t.Fatalf("WriteFile error: %v", err)
+	}
+	result, err = h.UploadDir(ctx, srcDir, "gs://bucket/dst", &TransferOptions{ManifestPath: manifest})
+	if err != nil {
+		t.Fatalf("UploadDir error: %v", err)
+	}
+	if d := cmp.Diff([]string{"gs://bucket/dst/sub/b.txt"}, result.Transferred); d != "" {
+		t.Errorf("Unexpected uploads on resume (-want +got):\n%s", d)
+	}
+	if len(result.Skipped) != 2 {
+		t.Errorf("Got %v skipped files; want 2", len(result.Skipped))
+	}
+
+	dstDir := filepath.Join(tDir, "download")
+	dManifest := filepath.Join(tDir, "download.manifest")
+	result, err = h.DownloadPrefix(ctx, "gs://bucket/dst", dstDir, &TransferOptions{ManifestPath: dManifest})
+	if err != nil {
+		t.Fatalf("DownloadPrefix error: %v", err)
+	}
+	if len(result.Transferred) != len(files) {
+		t.Errorf("Got %v downloads; want %v", len(result.Transferred), len(files))
+	}
+	actual, err := os.ReadFile(filepath.Join(dstDir, "sub", "b.txt"))
+	if err != nil {
+		t.Fatalf("ReadFile error: %v", err)
+	}
+	if d := cmp.Diff("changed", string(actual)); d != "" {
+		t.Errorf("Unexpected downloaded contents (-want +got):\n%s", d)
+	}
+
+	// Simulate an interrupted write of the manifest; the resumed download should still skip everything.
+	f, err := os.OpenFile(dManifest, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatalf("OpenFile error: %v", err)
+	}
+	if _, err := f.Write([]byte(`{"src":"gs://bucket/dst/a.t`)); err != nil {
+		t.Fatalf("Write error: %v", err)
+	}
+	f.Close()
+
+	result, err = h.DownloadPrefix(ctx, "gs://bucket/dst", dstDir, &TransferOptions{ManifestPath: dManifest})
+	if err != nil {
+		t.Fatalf("DownloadPrefix error: %v", err)
+	}
+	if len(result.Transferred) != 0 || len(result.Skipped) != len(files) {
+		t.Errorf("Resumed download transferred %v and skipped %v; want 0 and %v", result.Transferred, result.Skipped, len(files))
+	}
+}
+
+func Test_Retry(t *testing.T) {
+	type testCase struct {
+		name     string
+		errs     []error
+		attempts int
+		wantErr  bool
+	}
+
+	transient := errors.New("connection reset")
+	cases := []testCase{
+		{name: "succeeds-after-retry", errs: []error{transient, nil}, attempts: 2},
+		{name: "gives-up", errs: []error{transient, transient, transient}, attempts: 3, wantErr: true},
+		{name: "not-retryable", errs: []error{&Error{Kind: ErrObjectNotFound, Err: transient}}, attempts: 1, wantErr: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			attempts := 0
+			err := retry(context.Background(), &TransferOptions{MaxAttempts: 3, InitialBackoff: time.Millisecond}, func() error {
+				err := c.errs[attempts]
+				attempts++
+				return err
+			})
+			if (err != nil) != c.wantErr {
+				t.Errorf("retry got error %v; want error %v", err, c.wantErr)
+			}
+			if attempts != c.attempts {
+				t.Errorf("retry made %v attempts; want %v", attempts, c.attempts)
+			}
+		})
+	}
+}
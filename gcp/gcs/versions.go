@@ -0,0 +1,90 @@
+package gcs
+
+import (
+	"context"
+	"sort"
+
+	"cloud.google.com/go/storage"
+	"github.com/jlewi/monogo/helpers"
+	"github.com/pkg/errors"
+)
+
+// IterateVersions returns an iterator over every version, live and noncurrent, of the objects within the given
+// prefix. The paths returned by the iterator include the generation. Versions of an object are returned in order
+// of generation i.e. oldest first.
+//
+// Noncurrent versions only exist if object versioning is enabled on the bucket.
+func IterateVersions(ctx context.Context, client *storage.Client, prefix string) (*Iterator, error) {
+	p, err := Parse(prefix)
+	if err != nil {
+		return nil, errors.WithStack(errors.Wrapf(err, "Could not list versions matching %v", prefix))
+	}
+
+	q := &storage.Query{
+		Prefix:   p.Path,
+		Versions: true,
+	}
+
+	return &Iterator{
+		objs:     client.Bucket(p.Bucket).Objects(ctx, q),
+		desc:     prefix,
+		versions: true,
+	}, nil
+}
+
+// ListVersions returns every version of the object uri, newest first. The URI of each version includes its
+// generation e.g. gs://bucket/object#123 so it can be passed to NewReader or RestoreVersion. Noncurrent versions
+// have Noncurrent set; if the object was deleted none of the versions are live.
+func (h *GcsHelper) ListVersions(ctx context.Context, uri string) ([]*helpers.FileInfo, error) {
+	p, err := Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+	if p.Path == "" {
+		return nil, errors.Errorf("Can't list versions of %v; URI must be an object not a bucket", uri)
+	}
+	p.Generation = 0
+
+	it, err := IterateVersions(ctx, h.Client, p.ToURI())
+	if err != nil {
+		return nil, err
+	}
+	it.match = func(o *GcsPath) bool {
+		return o.Path == p.Path
+	}
+
+	results := []*helpers.FileInfo{}
+	err = it.Walk(func(o *GcsPath, attrs *storage.ObjectAttrs) error {
+		info := objectInfo(attrs)
+		info.URI = o.ToURI()
+		results = append(results, info)
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrapf(classifyError(uri, err), "Could not list versions of %v", uri)
+	}
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Generation > results[j].Generation
+	})
+	return results, nil
+}
+
+// RestoreVersion makes the version uri, e.g. gs://bucket/object#123, the live version of the object. The version
+// is copied so the restored object has a new generation; if versioning is enabled the current live version
+// becomes noncurrent rather than being lost.
+func (h *GcsHelper) RestoreVersion(ctx context.Context, uri string) (*helpers.FileInfo, error) {
+	p, err := Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+	if p.Generation == 0 {
+		return nil, errors.Errorf("Can't restore %v; the URI must include the generation to restore e.g. gs://bucket/object#123", uri)
+	}
+
+	dst := h.Client.Bucket(p.Bucket).Object(p.Path)
+	attrs, err := dst.CopierFrom(p.object(h.Client)).Run(ctx)
+	if err != nil {
+		return nil, errors.WithStack(errors.Wrapf(classifyError(uri, err), "Could not restore %v", uri))
+	}
+	return objectInfo(attrs), nil
+}
@@ -0,0 +1,105 @@
+package gcs
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_Versions(t *testing.T) {
+	h, srv := newTestHelper(t)
+	srv.EnableVersioning("bucket")
+	ctx := context.Background()
+
+	uri := "gs://bucket/config.yaml"
+	for _, contents := range []string{"v1", "v2", "v3"} {
+		srv.WriteObject("bucket", "config.yaml", []byte(contents))
+	}
+	srv.WriteObject("bucket", "config.yaml.bak", []byte("other"))
+
+	versions, err := h.ListVersions(ctx, uri)
+	if err != nil {
+		t.Fatalf("ListVersions failed; error: %v", err)
+	}
+	if len(versions) != 3 {
+		t.Fatalf("Got %v versions; want 3", len(versions))
+	}
+	actual := []bool{}
+	for _, v := range versions {
+		actual = append(actual, v.Noncurrent)
+	}
+	if d := cmp.Diff([]bool{false, true, true}, actual); d != "" {
+		t.Errorf("Unexpected Noncurrent; diff:\n%v", d)
+	}
+
+	oldest := versions[2].URI
+	p, err := Parse(oldest)
+	if err != nil {
+		t.Fatalf("Failed to parse %v; error: %v", oldest, err)
+	}
+	if p.Generation != versions[2].Generation {
+		t.Errorf("URI %v doesn't include the generation %v", oldest, versions[2].Generation)
+	}
+
+	readString := func(uri string) string {
+		t.Helper()
+		r, err := h.NewReaderContext(ctx, uri)
+		if err != nil {
+			t.Fatalf("NewReaderContext(%v) failed; error: %v", uri, err)
+		}
+		defer r.Close()
+		b, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("Failed to read %v; error: %v", uri, err)
+		}
+		return string(b)
+	}
+
+	if actual := readString(oldest); actual != "v1" {
+		t.Errorf("Read %v; got %v; want v1", oldest, actual)
+	}
+
+	info, err := h.Stat(ctx, oldest)
+	if err != nil {
+		t.Fatalf("Stat(%v) failed; error: %v", oldest, err)
+	}
+	if !info.Noncurrent {
+		t.Errorf("Stat(%v) should report a noncurrent version", oldest)
+	}
+
+	if _, err := h.RestoreVersion(ctx, oldest); err != nil {
+		t.Fatalf("RestoreVersion failed; error: %v", err)
+	}
+	if actual := readString(uri); actual != "v1" {
+		t.Errorf("Read %v after restore; got %v; want v1", uri, actual)
+	}
+
+	// Deleting the live object keeps it as a noncurrent version.
+	if err := h.Delete(ctx, uri); err != nil {
+		t.Fatalf("Delete failed; error: %v", err)
+	}
+	versions, err = h.ListVersions(ctx, uri)
+	if err != nil {
+		t.Fatalf("ListVersions failed; error: %v", err)
+	}
+	if len(versions) != 4 || !versions[0].Noncurrent {
+		t.Errorf("Got %v versions; want 4 noncurrent versions", len(versions))
+	}
+
+	// Deleting a specific generation removes it permanently.
+	if err := h.Delete(ctx, oldest); err != nil {
+		t.Fatalf("Delete(%v) failed; error: %v", oldest, err)
+	}
+	if exists, err := h.ExistsContext(ctx, oldest); err != nil || exists {
+		t.Errorf("Exists(%v) = %v, %v; want false, nil", oldest, exists, err)
+	}
+
+	if _, err := h.RestoreVersion(ctx, uri); err == nil {
+		t.Errorf("RestoreVersion should fail if the URI doesn't include a generation")
+	}
+	if _, err := h.NewWriterContext(ctx, oldest); err == nil {
+		t.Errorf("NewWriterContext should fail if the URI includes a generation")
+	}
+}
@@ -0,0 +1,157 @@
+package gcs
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/go-logr/zapr"
+	"github.com/jlewi/monogo/helpers"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// Event types of GCS Pub/Sub notifications.
+// See https://cloud.google.com/storage/docs/pubsub-notifications#events
+const (
+	NotificationFinalize       = "OBJECT_FINALIZE"
+	NotificationMetadataUpdate = "OBJECT_METADATA_UPDATE"
+	NotificationDelete         = "OBJECT_DELETE"
+	NotificationArchive        = "OBJECT_ARCHIVE"
+)
+
+// Notification is a change notification for an object; i.e. the attributes of a GCS Pub/Sub notification.
+type Notification struct {
+	EventType  string
+	Bucket     string
+	Object     string
+	Generation int64
+	// OverwroteGeneration is the generation of the object that was replaced; only set for OBJECT_FINALIZE.
+	OverwroteGeneration int64
+	// OverwrittenByGeneration is the generation of the object that replaced this one; only set for OBJECT_DELETE
+	// and OBJECT_ARCHIVE.
+	OverwrittenByGeneration int64
+}
+
+// ParseNotification parses the attributes of a GCS Pub/Sub notification; e.g. pubsub.Message.Attributes.
+func ParseNotification(attrs map[string]string) (*Notification, error) {
+	n := &Notification{
+		EventType: attrs["eventType"],
+		Bucket:    attrs["bucketId"],
+		Object:    attrs["objectId"],
+	}
+	if n.EventType == "" || n.Bucket == "" || n.Object == "" {
+		return nil, errors.Errorf("Invalid notification; eventType, bucketId and objectId are required; got %v", attrs)
+	}
+	for key, dst := range map[string]*int64{
+		"objectGeneration":        &n.Generation,
+		"overwroteGeneration":     &n.OverwroteGeneration,
+		"overwrittenByGeneration": &n.OverwrittenByGeneration,
+	} {
+		v, ok := attrs[key]
+		if !ok {
+			continue
+		}
+		i, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Invalid notification; %v isn't a generation: %v", key, v)
+		}
+		*dst = i
+	}
+	return n, nil
+}
+
+// NotificationSource delivers notifications about changes to objects. It allows Watch to consume GCS Pub/Sub
+// notifications without this package depending on a Pub/Sub client; e.g. an implementation can call Receive
+// on a pubsub.Subscription and use ParseNotification to convert each message.
+type NotificationSource interface {
+	// Receive calls f for each notification until ctx is done or an unrecoverable error occurs.
+	Receive(ctx context.Context, f func(ctx context.Context, n *Notification)) error
+}
+
+// Watch reports changes to the object uri or, if uri is a directory, to the objects inside it. If h.Notifications
+// is set the changes are taken from it; otherwise the generations of the objects are polled every
+// opts.PollInterval. Metadata only updates aren't reported. The channel is closed when ctx is done or, when
+// using notifications, if the source fails.
+func (h *GcsHelper) Watch(ctx context.Context, uri string, opts *helpers.WatchOptions) (<-chan helpers.WatchEvent, error) {
+	p, err := Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+	if p.Generation != 0 {
+		return nil, errors.Errorf("Can't watch %v; the URI includes a generation", uri)
+	}
+	if h.Notifications == nil {
+		return helpers.PollWatch(ctx, opts, func(ctx context.Context) (map[string]*helpers.FileInfo, error) {
+			return h.snapshot(ctx, p)
+		})
+	}
+
+	log := zapr.NewLogger(zap.L())
+	events := make(chan helpers.WatchEvent)
+	go func() {
+		defer close(events)
+		err := h.Notifications.Receive(ctx, func(ctx context.Context, n *Notification) {
+			e, ok := notificationEvent(p, n)
+			if !ok {
+				return
+			}
+			select {
+			case events <- e:
+			case <-ctx.Done():
+			}
+		})
+		if err != nil && ctx.Err() == nil {
+			log.Error(err, "Failed to receive notifications", "uri", uri)
+		}
+	}()
+	return helpers.Debounce(ctx, events, opts), nil
+}
+
+// notificationEvent converts n to an event; it returns false if n isn't for an object in p or doesn't change the
+// live version of the object.
+func notificationEvent(p *GcsPath, n *Notification) (helpers.WatchEvent, bool) {
+	obj := &GcsPath{Bucket: n.Bucket, Path: n.Object}
+	if !p.IsPrefixOf(obj) {
+		return helpers.WatchEvent{}, false
+	}
+	e := helpers.WatchEvent{URI: obj.ToURI()}
+	switch n.EventType {
+	case NotificationFinalize:
+		e.Op = helpers.WatchCreate
+		if n.OverwroteGeneration != 0 {
+			e.Op = helpers.WatchModify
+		}
+		e.Info = &helpers.FileInfo{URI: e.URI, Generation: n.Generation}
+	case NotificationDelete, NotificationArchive:
+		// If the object was replaced the OBJECT_FINALIZE for the new version reports the change.
+		if n.OverwrittenByGeneration != 0 {
+			return helpers.WatchEvent{}, false
+		}
+		e.Op = helpers.WatchDelete
+	default:
+		return helpers.WatchEvent{}, false
+	}
+	return e, true
+}
+
+// snapshot returns the object p, if it exists, and the objects inside p treated as a directory.
+func (h *GcsHelper) snapshot(ctx context.Context, p *GcsPath) (map[string]*helpers.FileInfo, error) {
+	results := map[string]*helpers.FileInfo{}
+	if p.Path != "" {
+		info, err := h.Stat(ctx, p.ToURI())
+		switch {
+		case err == nil:
+			results[info.URI] = info
+		case !errors.Is(err, ErrObjectNotFound):
+			return nil, err
+		}
+	}
+	infos, err := h.List(ctx, p.ToURI())
+	if err != nil {
+		return nil, err
+	}
+	for _, info := range infos {
+		results[info.URI] = info
+	}
+	return results, nil
+}
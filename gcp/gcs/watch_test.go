@@ -0,0 +1,124 @@
+package gcs
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/jlewi/monogo/helpers"
+)
+
+// nextEvents returns the next n events or fails the test if they don't arrive in time.
+func nextEvents(t *testing.T, events <-chan helpers.WatchEvent, n int) []string {
+	t.Helper()
+	results := []string{}
+	timeout := time.After(10 * time.Second)
+	for len(results) < n {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				t.Fatalf("The channel was closed; got %v", results)
+			}
+			results = append(results, e.Op.String()+" "+e.URI)
+		case <-timeout:
+			t.Fatalf("Timed out waiting for events; got %v", results)
+		}
+	}
+	return results
+}
+
+func Test_WatchPolling(t *testing.T) {
+	h, srv := newTestHelper(t)
+	srv.WriteObject("bucket", "config/a.yaml", []byte("a: 1"))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events, err := h.Watch(ctx, "gs://bucket/config", &helpers.WatchOptions{PollInterval: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("Watch failed; error: %v", err)
+	}
+
+	srv.WriteObject("bucket", "config/a.yaml", []byte("a: 2"))
+	if d := cmp.Diff([]string{"modify gs://bucket/config/a.yaml"}, nextEvents(t, events, 1)); d != "" {
+		t.Errorf("Unexpected events; diff:\n%v", d)
+	}
+	srv.WriteObject("bucket", "config/b.yaml", []byte("b: 1"))
+	// Objects outside the directory aren't reported.
+	srv.WriteObject("bucket", "config2/c.yaml", []byte("c: 1"))
+	if d := cmp.Diff([]string{"create gs://bucket/config/b.yaml"}, nextEvents(t, events, 1)); d != "" {
+		t.Errorf("Unexpected events; diff:\n%v", d)
+	}
+	if err := h.Delete(ctx, "gs://bucket/config/a.yaml"); err != nil {
+		t.Fatalf("Delete failed; error: %v", err)
+	}
+	if d := cmp.Diff([]string{"delete gs://bucket/config/a.yaml"}, nextEvents(t, events, 1)); d != "" {
+		t.Errorf("Unexpected events; diff:\n%v", d)
+	}
+}
+
+// fakeNotifications is a NotificationSource that delivers the notifications sent on a channel.
+type fakeNotifications struct {
+	c chan *Notification
+}
+
+func (f *fakeNotifications) Receive(ctx context.Context, fn func(ctx context.Context, n *Notification)) error {
+	for {
+		select {
+		case n := <-f.c:
+			fn(ctx, n)
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func Test_WatchNotifications(t *testing.T) {
+	source := &fakeNotifications{c: make(chan *Notification)}
+	h := &GcsHelper{Notifications: source}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events, err := h.Watch(ctx, "gs://bucket/config/a.yaml", &helpers.WatchOptions{Debounce: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("Watch failed; error: %v", err)
+	}
+
+	attrs := []map[string]string{
+		{"eventType": NotificationFinalize, "bucketId": "bucket", "objectId": "config/a.yaml", "objectGeneration": "1"},
+		{"eventType": NotificationFinalize, "bucketId": "bucket", "objectId": "config/b.yaml", "objectGeneration": "2"},
+		{"eventType": NotificationMetadataUpdate, "bucketId": "bucket", "objectId": "config/a.yaml", "objectGeneration": "1"},
+	}
+	// Replacing an object generates a finalize for the new object and an archive for the old one.
+	replace := []map[string]string{
+		{"eventType": NotificationFinalize, "bucketId": "bucket", "objectId": "config/a.yaml", "objectGeneration": "3", "overwroteGeneration": "1"},
+		{"eventType": NotificationArchive, "bucketId": "bucket", "objectId": "config/a.yaml", "objectGeneration": "1", "overwrittenByGeneration": "3"},
+	}
+	remove := []map[string]string{
+		{"eventType": NotificationDelete, "bucketId": "bucket", "objectId": "config/a.yaml", "objectGeneration": "3"},
+	}
+
+	for _, c := range []struct {
+		attrs    []map[string]string
+		expected string
+	}{
+		{attrs: attrs, expected: "create gs://bucket/config/a.yaml"},
+		{attrs: replace, expected: "modify gs://bucket/config/a.yaml"},
+		{attrs: remove, expected: "delete gs://bucket/config/a.yaml"},
+	} {
+		for _, a := range c.attrs {
+			n, err := ParseNotification(a)
+			if err != nil {
+				t.Fatalf("ParseNotification failed; error: %v", err)
+			}
+			source.c <- n
+		}
+		if d := cmp.Diff([]string{c.expected}, nextEvents(t, events, 1)); d != "" {
+			t.Errorf("Unexpected events; diff:\n%v", d)
+		}
+	}
+
+	if _, err := ParseNotification(map[string]string{"eventType": NotificationFinalize}); err == nil {
+		t.Errorf("ParseNotification should fail if bucketId and objectId are missing")
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"cloud.google.com/go/secretmanager/apiv1/secretmanagerpb"
 	"github.com/go-logr/logr"
 	"github.com/go-logr/zapr"
+	"github.com/jlewi/monogo/files"
 	"github.com/pkg/errors"
 	"go.uber.org/zap"
 	"golang.org/x/oauth2"
@@ -26,21 +27,25 @@ type SecretCache struct {
 }
 
 func NewSecretCache(project string, secret string, version string) (*SecretCache, error) {
-	c := &SecretCache{
-		Project: project,
-		Secret:  secret,
-		Version: version,
-		Log:     zapr.NewLogger(zap.L()),
-	}
-
 	client, err := secretmanager.NewClient(context.Background())
 
 	if err != nil {
 		return nil, err
 	}
 
-	c.client = client
-	return c, nil
+	return NewSecretCacheWithClient(client, project, secret, version), nil
+}
+
+// NewSecretCacheWithClient creates a SecretCache that uses the supplied client. This is useful for pointing the
+// cache at a fake server in tests; see the smtest package.
+func NewSecretCacheWithClient(client *secretmanager.Client, project string, secret string, version string) *SecretCache {
+	return &SecretCache{
+		client:  client,
+		Project: project,
+		Secret:  secret,
+		Version: version,
+		Log:     zapr.NewLogger(zap.L()),
+	}
 }
 
 func (c *SecretCache) GetToken() (*oauth2.Token, error) {
@@ -65,36 +70,9 @@ func (c *SecretCache) Save(token *oauth2.Token) error {
 	log := c.Log
 	log.Info("Saving credential to secret manager", "project", c.Project, "secret", c.Secret)
 
-	// Create the request to create the secret.
-	createSecretReq := &secretmanagerpb.CreateSecretRequest{
-		Parent:   fmt.Sprintf("projects/%s", c.Project),
-		SecretId: c.Secret,
-		Secret: &secretmanagerpb.Secret{
-			Replication: &secretmanagerpb.Replication{
-				Replication: &secretmanagerpb.Replication_Automatic_{
-					Automatic: &secretmanagerpb.Replication_Automatic{},
-				},
-			},
-		},
-	}
-
 	ctx := context.Background()
-
-	_, err := c.client.CreateSecret(ctx, createSecretReq)
-	if err != nil {
-		status, ok := status.FromError(err)
-
-		if !ok {
-			log.Error(err, "Error creating secret.", "project", c.Project, "secret", c.Secret)
-			return err
-		}
-
-		if status.Code() == codes.AlreadyExists {
-			log.Info("Secret exists", "project", c.Project, "secret", c.Secret)
-		} else {
-			log.Error(err, "Error creating secret.", "project", c.Project, "secret", c.Secret)
-			return err
-		}
+	if err := files.CreateSecretIfMissing(ctx, c.client, c.Project, c.Secret); err != nil {
+		return err
 	}
 
 	payload, err := json.Marshal(token)
@@ -102,16 +80,7 @@ func (c *SecretCache) Save(token *oauth2.Token) error {
 		return err
 	}
 
-	// Build the request.
-	addSecretVersionReq := &secretmanagerpb.AddSecretVersionRequest{
-		Parent: fmt.Sprintf("projects/%v/secrets/%v", c.Project, c.Secret),
-		Payload: &secretmanagerpb.SecretPayload{
-			Data: payload,
-		},
-	}
-
-	// Call the API.
-	version, err := c.client.AddSecretVersion(ctx, addSecretVersionReq)
+	version, err := files.AddSecretVersion(ctx, c.client, c.Project, c.Secret, payload)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,65 @@
+package gcp
+
+import (
+	"context"
+	"testing"
+
+	"cloud.google.com/go/secretmanager/apiv1/secretmanagerpb"
+	"github.com/google/go-cmp/cmp"
+	"github.com/jlewi/monogo/gcp/smtest"
+	"golang.org/x/oauth2"
+)
+
+func Test_SecretCache(t *testing.T) {
+	srv, err := smtest.NewServer()
+	if err != nil {
+		t.Fatalf("Failed to start fake secret manager: %v", err)
+	}
+	defer srv.Close()
+
+	ctx := context.Background()
+	client, err := srv.Client(ctx)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	defer client.Close()
+
+	c := NewSecretCacheWithClient(client, "p", "token", "latest")
+
+	// NotFound should be treated as no cached token.
+	tok, err := c.GetToken()
+	if err != nil {
+		t.Fatalf("GetToken error: %v", err)
+	}
+	if tok != nil {
+		t.Errorf("GetToken got %v; want nil", tok)
+	}
+
+	expected := &oauth2.Token{AccessToken: "access", RefreshToken: "refresh", TokenType: "Bearer"}
+	if err := c.Save(expected); err != nil {
+		t.Fatalf("Save error: %v", err)
+	}
+
+	tok, err = c.GetToken()
+	if err != nil {
+		t.Fatalf("GetToken error: %v", err)
+	}
+	if d := cmp.Diff(expected.AccessToken, tok.AccessToken); d != "" {
+		t.Errorf("Unexpected access token; diff:\n%v", d)
+	}
+	if d := cmp.Diff(expected.RefreshToken, tok.RefreshToken); d != "" {
+		t.Errorf("Unexpected refresh token; diff:\n%v", d)
+	}
+
+	// FailedPrecondition, e.g. the latest version is disabled, should also be treated as no cached token.
+	if _, err := client.DisableSecretVersion(ctx, &secretmanagerpb.DisableSecretVersionRequest{Name: "projects/p/secrets/token/versions/1"}); err != nil {
+		t.Fatalf("DisableSecretVersion error: %v", err)
+	}
+	tok, err = c.GetToken()
+	if err != nil {
+		t.Fatalf("GetToken error: %v", err)
+	}
+	if tok != nil {
+		t.Errorf("GetToken got %v; want nil", tok)
+	}
+}
@@ -0,0 +1,280 @@
+// Package smtest provides an in-process fake of the GCP Secret Manager service for tests.
+//
+// The fake implements the gRPC API so the real client can be used with it e.g.
+//
+//	srv, err := smtest.NewServer()
+//	...
+//	defer srv.Close()
+//	client, err := srv.Client(ctx)
+//
+// Only the subset of the API needed to create, read, disable and destroy secrets is implemented.
+package smtest
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	secretmanager "cloud.google.com/go/secretmanager/apiv1"
+	"cloud.google.com/go/secretmanager/apiv1/secretmanagerpb"
+	"github.com/pkg/errors"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Server is a fake Secret Manager server.
+type Server struct {
+	secretmanagerpb.UnimplementedSecretManagerServiceServer
+
+	// Addr is the address the server is listening on.
+	Addr string
+
+	gsrv *grpc.Server
+
+	// mu guards secrets. The stored protos are never returned directly since gRPC marshals the responses after the
+	// lock is released; copies are returned instead.
+	mu sync.Mutex
+	// secrets maps the resource name of a secret, i.e. projects/${project}/secrets/${secret}, to the secret.
+	secrets map[string]*secret
+}
+
+type secret struct {
+	pb       *secretmanagerpb.Secret
+	versions []*version
+}
+
+type version struct {
+	pb   *secretmanagerpb.SecretVersion
+	data []byte
+}
+
+// NewServer starts a new fake server listening on a free port on localhost.
+func NewServer() (*Server, error) {
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to listen on a free port")
+	}
+
+	s := &Server{
+		Addr:    l.Addr().String(),
+		gsrv:    grpc.NewServer(),
+		secrets: map[string]*secret{},
+	}
+	secretmanagerpb.RegisterSecretManagerServiceServer(s.gsrv, s)
+
+	go func() {
+		// Serve returns once the server is stopped.
+		_ = s.gsrv.Serve(l)
+	}()
+	return s, nil
+}
+
+// Close stops the server.
+func (s *Server) Close() {
+	s.gsrv.Stop()
+}
+
+// Client returns a client that talks to the server.
+func (s *Server) Client(ctx context.Context) (*secretmanager.Client, error) {
+	conn, err := grpc.Dial(s.Addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to dial %v", s.Addr)
+	}
+	return secretmanager.NewClient(ctx, option.WithGRPCConn(conn))
+}
+
+func (s *Server) CreateSecret(ctx context.Context, req *secretmanagerpb.CreateSecretRequest) (*secretmanagerpb.Secret, error) {
+	if req.SecretId == "" {
+		return nil, status.Error(codes.InvalidArgument, "secret_id is required")
+	}
+	name := fmt.Sprintf("%v/secrets/%v", req.Parent, req.SecretId)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.secrets[name]; ok {
+		return nil, status.Errorf(codes.AlreadyExists, "Secret [%v] already exists.", name)
+	}
+
+	pb := &secretmanagerpb.Secret{
+		Name:       name,
+		CreateTime: timestamppb.Now(),
+	}
+	if req.Secret != nil {
+		pb.Replication = req.Secret.Replication
+		pb.Labels = req.Secret.Labels
+	}
+	s.secrets[name] = &secret{pb: pb}
+	return proto.Clone(pb).(*secretmanagerpb.Secret), nil
+}
+
+func (s *Server) GetSecret(ctx context.Context, req *secretmanagerpb.GetSecretRequest) (*secretmanagerpb.Secret, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sec, err := s.getSecret(req.Name)
+	if err != nil {
+		return nil, err
+	}
+	return proto.Clone(sec.pb).(*secretmanagerpb.Secret), nil
+}
+
+func (s *Server) DeleteSecret(ctx context.Context, req *secretmanagerpb.DeleteSecretRequest) (*emptypb.Empty, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.getSecret(req.Name); err != nil {
+		return nil, err
+	}
+	delete(s.secrets, req.Name)
+	return &emptypb.Empty{}, nil
+}
+
+func (s *Server) ListSecrets(ctx context.Context, req *secretmanagerpb.ListSecretsRequest) (*secretmanagerpb.ListSecretsResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	resp := &secretmanagerpb.ListSecretsResponse{}
+	for name, sec := range s.secrets {
+		if strings.HasPrefix(name, req.Parent+"/secrets/") {
+			resp.Secrets = append(resp.Secrets, proto.Clone(sec.pb).(*secretmanagerpb.Secret))
+		}
+	}
+	sort.Slice(resp.Secrets, func(i, j int) bool {
+		return resp.Secrets[i].Name < resp.Secrets[j].Name
+	})
+	resp.TotalSize = int32(len(resp.Secrets))
+	return resp, nil
+}
+
+func (s *Server) AddSecretVersion(ctx context.Context, req *secretmanagerpb.AddSecretVersionRequest) (*secretmanagerpb.SecretVersion, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sec, err := s.getSecret(req.Parent)
+	if err != nil {
+		return nil, err
+	}
+	v := &version{
+		pb: &secretmanagerpb.SecretVersion{
+			Name:       fmt.Sprintf("%v/versions/%d", req.Parent, len(sec.versions)+1),
+			CreateTime: timestamppb.Now(),
+			State:      secretmanagerpb.SecretVersion_ENABLED,
+		},
+		data: req.GetPayload().GetData(),
+	}
+	sec.versions = append(sec.versions, v)
+	return proto.Clone(v.pb).(*secretmanagerpb.SecretVersion), nil
+}
+
+func (s *Server) ListSecretVersions(ctx context.Context, req *secretmanagerpb.ListSecretVersionsRequest) (*secretmanagerpb.ListSecretVersionsResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sec, err := s.getSecret(req.Parent)
+	if err != nil {
+		return nil, err
+	}
+	resp := &secretmanagerpb.ListSecretVersionsResponse{}
+	// Like the real service, list the newest versions first.
+	for i := len(sec.versions) - 1; i >= 0; i-- {
+		resp.Versions = append(resp.Versions, proto.Clone(sec.versions[i].pb).(*secretmanagerpb.SecretVersion))
+	}
+	resp.TotalSize = int32(len(resp.Versions))
+	return resp, nil
+}
+
+func (s *Server) GetSecretVersion(ctx context.Context, req *secretmanagerpb.GetSecretVersionRequest) (*secretmanagerpb.SecretVersion, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, err := s.getVersion(req.Name)
+	if err != nil {
+		return nil, err
+	}
+	return proto.Clone(v.pb).(*secretmanagerpb.SecretVersion), nil
+}
+
+func (s *Server) AccessSecretVersion(ctx context.Context, req *secretmanagerpb.AccessSecretVersionRequest) (*secretmanagerpb.AccessSecretVersionResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, err := s.getVersion(req.Name)
+	if err != nil {
+		return nil, err
+	}
+	if v.pb.State != secretmanagerpb.SecretVersion_ENABLED {
+		return nil, status.Errorf(codes.FailedPrecondition, "%v is in %v state.", v.pb.Name, v.pb.State.String())
+	}
+	return &secretmanagerpb.AccessSecretVersionResponse{
+		Name: v.pb.Name,
+		Payload: &secretmanagerpb.SecretPayload{
+			Data: v.data,
+		},
+	}, nil
+}
+
+func (s *Server) DisableSecretVersion(ctx context.Context, req *secretmanagerpb.DisableSecretVersionRequest) (*secretmanagerpb.SecretVersion, error) {
+	return s.setState(req.Name, secretmanagerpb.SecretVersion_DISABLED)
+}
+
+func (s *Server) EnableSecretVersion(ctx context.Context, req *secretmanagerpb.EnableSecretVersionRequest) (*secretmanagerpb.SecretVersion, error) {
+	return s.setState(req.Name, secretmanagerpb.SecretVersion_ENABLED)
+}
+
+func (s *Server) DestroySecretVersion(ctx context.Context, req *secretmanagerpb.DestroySecretVersionRequest) (*secretmanagerpb.SecretVersion, error) {
+	return s.setState(req.Name, secretmanagerpb.SecretVersion_DESTROYED)
+}
+
+func (s *Server) setState(name string, state secretmanagerpb.SecretVersion_State) (*secretmanagerpb.SecretVersion, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, err := s.getVersion(name)
+	if err != nil {
+		return nil, err
+	}
+	if v.pb.State == secretmanagerpb.SecretVersion_DESTROYED {
+		return nil, status.Errorf(codes.FailedPrecondition, "%v is in DESTROYED state.", v.pb.Name)
+	}
+	v.pb.State = state
+	if state == secretmanagerpb.SecretVersion_DESTROYED {
+		v.data = nil
+		v.pb.DestroyTime = timestamppb.Now()
+	}
+	return proto.Clone(v.pb).(*secretmanagerpb.SecretVersion), nil
+}
+
+// getSecret returns the secret. The caller must hold the lock.
+func (s *Server) getSecret(name string) (*secret, error) {
+	sec, ok := s.secrets[name]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "Secret [%v] not found.", name)
+	}
+	return sec, nil
+}
+
+// getVersion returns the version; name can use the alias latest. The caller must hold the lock.
+func (s *Server) getVersion(name string) (*version, error) {
+	i := strings.LastIndex(name, "/versions/")
+	if i < 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "%v isn't the name of a secret version", name)
+	}
+	sec, err := s.getSecret(name[:i])
+	if err != nil {
+		return nil, err
+	}
+	id := name[i+len("/versions/"):]
+	if id == "latest" {
+		if len(sec.versions) == 0 {
+			return nil, status.Errorf(codes.NotFound, "Secret [%v] has no versions.", name[:i])
+		}
+		return sec.versions[len(sec.versions)-1], nil
+	}
+	n, err := strconv.Atoi(id)
+	if err != nil || n < 1 || n > len(sec.versions) {
+		return nil, status.Errorf(codes.NotFound, "Secret Version [%v] not found.", name)
+	}
+	return sec.versions[n-1], nil
+}
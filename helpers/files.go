@@ -1,5 +1,31 @@
 package helpers
 
+import "time"
+
 const (
 	UserGroupAllPerm = 0770
 )
+
+// FileInfo describes a file. It is returned by the Stat and List methods of files.DirectoryHelper.
+//
+// It is defined here rather than in the files package so that helpers in packages which the files package
+// depends on (e.g. gcs) can return it.
+type FileInfo struct {
+	// URI of the file.
+	URI string
+	// Size of the file in bytes.
+	Size int64
+	// ModTime is the time the file was last modified.
+	ModTime time.Time
+	// ContentType of the file. For local files this is inferred from the extension and may be empty.
+	ContentType ContentType
+	// Generation of the object; only set by backends which version objects e.g. GCS.
+	Generation int64
+	// Noncurrent is true if this is an old version of the object rather than the live version; only set by
+	// backends which version objects.
+	Noncurrent bool
+	// Etag of the object; only set by backends which support it e.g. GCS.
+	Etag string
+	// MD5 hash of the contents; only set by backends which store it. GCS doesn't store it for composite objects.
+	MD5 []byte
+}
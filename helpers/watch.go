@@ -0,0 +1,237 @@
+package helpers
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/go-logr/zapr"
+	"go.uber.org/zap"
+)
+
+const (
+	// DefaultWatchDebounce is the debounce interval used when WatchOptions.Debounce isn't set.
+	DefaultWatchDebounce = 100 * time.Millisecond
+	// DefaultWatchPollInterval is the poll interval used when WatchOptions.PollInterval isn't set.
+	DefaultWatchPollInterval = 10 * time.Second
+)
+
+// WatchOp is the type of change reported by a WatchEvent.
+type WatchOp int
+
+const (
+	WatchCreate WatchOp = iota + 1
+	WatchModify
+	WatchDelete
+)
+
+func (o WatchOp) String() string {
+	switch o {
+	case WatchCreate:
+		return "create"
+	case WatchModify:
+		return "modify"
+	case WatchDelete:
+		return "delete"
+	default:
+		return "unknown"
+	}
+}
+
+// WatchEvent reports a change to a file. It is emitted by the Watch methods of files.DirectoryHelper backends.
+//
+// Like FileInfo it is defined here so that helpers in packages which the files package depends on (e.g. gcs)
+// can emit it.
+type WatchEvent struct {
+	// URI of the file that changed.
+	URI string
+	Op  WatchOp
+	// Info describes the file after the change; it is nil for WatchDelete. Backends only set the fields they can
+	// determine cheaply.
+	Info *FileInfo
+}
+
+// WatchOptions control how files are watched. The zero value uses the defaults.
+type WatchOptions struct {
+	// Debounce is how long to wait for a file to stop changing before reporting it; changes to the same file within
+	// the interval are combined into a single event. Defaults to DefaultWatchDebounce. Set it to a negative value
+	// to disable debouncing.
+	Debounce time.Duration
+	// PollInterval is how often backends that don't support notifications check for changes. Defaults to
+	// DefaultWatchPollInterval.
+	PollInterval time.Duration
+}
+
+func (o *WatchOptions) debounce() time.Duration {
+	if o == nil || o.Debounce == 0 {
+		return DefaultWatchDebounce
+	}
+	return o.Debounce
+}
+
+// GetPollInterval returns the poll interval to use.
+func (o *WatchOptions) GetPollInterval() time.Duration {
+	if o == nil || o.PollInterval <= 0 {
+		return DefaultWatchPollInterval
+	}
+	return o.PollInterval
+}
+
+// SnapshotFunc returns the files being watched keyed by URI.
+type SnapshotFunc func(ctx context.Context) (map[string]*FileInfo, error)
+
+// PollWatch reports changes by comparing successive snapshots; a file is modified if its generation, etag,
+// modification time or size changed. The first snapshot is taken before PollWatch returns so any change made
+// after it returns is reported. Errors taking later snapshots are logged and the snapshot is retried at the
+// next interval. The channel is closed when ctx is done.
+func PollWatch(ctx context.Context, opts *WatchOptions, snapshot SnapshotFunc) (<-chan WatchEvent, error) {
+	log := zapr.NewLogger(zap.L())
+	last, err := snapshot(ctx)
+	if err != nil {
+		return nil, err
+	}
+	events := make(chan WatchEvent)
+	go func() {
+		defer close(events)
+		ticker := time.NewTicker(opts.GetPollInterval())
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			current, err := snapshot(ctx)
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Error(err, "Failed to check for changes")
+				}
+				continue
+			}
+			for _, e := range diffSnapshots(last, current) {
+				select {
+				case events <- e:
+				case <-ctx.Done():
+					return
+				}
+			}
+			last = current
+		}
+	}()
+	return Debounce(ctx, events, opts), nil
+}
+
+// diffSnapshots returns the events needed to go from before to after sorted by URI.
+func diffSnapshots(before map[string]*FileInfo, after map[string]*FileInfo) []WatchEvent {
+	events := []WatchEvent{}
+	for uri, info := range after {
+		old, ok := before[uri]
+		switch {
+		case !ok:
+			events = append(events, WatchEvent{URI: uri, Op: WatchCreate, Info: info})
+		case old.Generation != info.Generation || old.Etag != info.Etag || !old.ModTime.Equal(info.ModTime) || old.Size != info.Size:
+			events = append(events, WatchEvent{URI: uri, Op: WatchModify, Info: info})
+		}
+	}
+	for uri := range before {
+		if _, ok := after[uri]; !ok {
+			events = append(events, WatchEvent{URI: uri, Op: WatchDelete})
+		}
+	}
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].URI < events[j].URI
+	})
+	return events
+}
+
+// Debounce combines events for the same URI which occur within the debounce interval of opts; an event is only
+// emitted once the file has stopped changing for the interval. The combined event describes the net change, e.g.
+// a create followed by a modify is a create and a create followed by a delete is dropped. When in is closed any
+// pending events are emitted immediately and then the returned channel is closed; it is also closed when ctx is
+// done.
+func Debounce(ctx context.Context, in <-chan WatchEvent, opts *WatchOptions) <-chan WatchEvent {
+	d := opts.debounce()
+	if d < 0 {
+		return in
+	}
+	out := make(chan WatchEvent)
+	go func() {
+		defer close(out)
+		type pendingEvent struct {
+			e        WatchEvent
+			deadline time.Time
+			// created is true if the file didn't exist before the first event in the batch.
+			created bool
+		}
+		pending := map[string]*pendingEvent{}
+		order := []string{}
+		timer := time.NewTimer(d)
+		timer.Stop()
+		defer timer.Stop()
+		// closed is true once in is closed; the pending events are then flushed.
+		closed := false
+
+		for !closed {
+			select {
+			case <-ctx.Done():
+				return
+			case e, ok := <-in:
+				if !ok {
+					closed = true
+					break
+				}
+				p, exists := pending[e.URI]
+				if !exists {
+					p = &pendingEvent{created: e.Op == WatchCreate}
+					pending[e.URI] = p
+					order = append(order, e.URI)
+				}
+				p.e = e
+				switch {
+				case p.created && e.Op == WatchModify:
+					p.e.Op = WatchCreate
+				case !p.created && exists && e.Op == WatchCreate:
+					// The file was deleted and recreated.
+					p.e.Op = WatchModify
+				}
+				p.deadline = time.Now().Add(d)
+				if len(order) == 1 {
+					timer.Reset(d)
+				}
+			case <-timer.C:
+			}
+
+			// Emit the events whose deadline has passed or all of them once in is closed.
+			now := time.Now()
+			remaining := []string{}
+			for _, uri := range order {
+				p := pending[uri]
+				if !closed && p.deadline.After(now) {
+					remaining = append(remaining, uri)
+					continue
+				}
+				delete(pending, uri)
+				if p.created && p.e.Op == WatchDelete {
+					continue
+				}
+				select {
+				case out <- p.e:
+				case <-ctx.Done():
+					return
+				}
+			}
+			order = remaining
+			if len(order) > 0 {
+				next := pending[order[0]].deadline
+				for _, uri := range order[1:] {
+					if pending[uri].deadline.Before(next) {
+						next = pending[uri].deadline
+					}
+				}
+				timer.Stop()
+				timer.Reset(time.Until(next))
+			}
+		}
+	}()
+	return out
+}
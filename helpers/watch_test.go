@@ -0,0 +1,166 @@
+package helpers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_Debounce(t *testing.T) {
+	type testCase struct {
+		name     string
+		input    []WatchEvent
+		expected []WatchEvent
+	}
+
+	cases := []testCase{
+		{
+			name: "modifies",
+			input: []WatchEvent{
+				{URI: "a", Op: WatchModify},
+				{URI: "a", Op: WatchModify},
+				{URI: "b", Op: WatchModify},
+			},
+			expected: []WatchEvent{
+				{URI: "a", Op: WatchModify},
+				{URI: "b", Op: WatchModify},
+			},
+		},
+		{
+			name: "create-modify",
+			input: []WatchEvent{
+				{URI: "a", Op: WatchCreate},
+				{URI: "a", Op: WatchModify},
+			},
+			expected: []WatchEvent{
+				{URI: "a", Op: WatchCreate},
+			},
+		},
+		{
+			name: "create-delete",
+			input: []WatchEvent{
+				{URI: "a", Op: WatchCreate},
+				{URI: "a", Op: WatchDelete},
+			},
+			expected: []WatchEvent{},
+		},
+		{
+			name: "delete-create",
+			input: []WatchEvent{
+				{URI: "a", Op: WatchDelete},
+				{URI: "a", Op: WatchCreate},
+			},
+			expected: []WatchEvent{
+				{URI: "a", Op: WatchModify},
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			in := make(chan WatchEvent)
+			out := Debounce(ctx, in, &WatchOptions{Debounce: 50 * time.Millisecond})
+			for _, e := range c.input {
+				in <- e
+			}
+
+			actual := []WatchEvent{}
+			timeout := time.After(500 * time.Millisecond)
+		loop:
+			for {
+				select {
+				case e := <-out:
+					actual = append(actual, e)
+				case <-timeout:
+					break loop
+				}
+			}
+			if d := cmp.Diff(c.expected, actual); d != "" {
+				t.Errorf("Unexpected events; diff:\n%v", d)
+			}
+		})
+	}
+}
+
+// Test_DebounceClose checks that pending events aren't lost when the input is closed e.g. because receiving
+// notifications failed.
+func Test_DebounceClose(t *testing.T) {
+	in := make(chan WatchEvent)
+	out := Debounce(context.Background(), in, &WatchOptions{Debounce: time.Hour})
+	for _, e := range []WatchEvent{
+		{URI: "a", Op: WatchModify},
+		{URI: "b", Op: WatchCreate},
+		{URI: "c", Op: WatchCreate},
+		{URI: "c", Op: WatchDelete},
+	} {
+		in <- e
+	}
+	close(in)
+
+	actual := []WatchEvent{}
+	timeout := time.After(10 * time.Second)
+	for done := false; !done; {
+		select {
+		case e, ok := <-out:
+			if !ok {
+				done = true
+				break
+			}
+			actual = append(actual, e)
+		case <-timeout:
+			t.Fatalf("Timed out waiting for the channel to be closed; got %v", actual)
+		}
+	}
+	expected := []WatchEvent{
+		{URI: "a", Op: WatchModify},
+		{URI: "b", Op: WatchCreate},
+	}
+	if d := cmp.Diff(expected, actual); d != "" {
+		t.Errorf("Unexpected events; diff:\n%v", d)
+	}
+}
+
+func Test_PollWatch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	snapshots := make(chan map[string]*FileInfo, 1)
+	snapshots <- map[string]*FileInfo{
+		"a": {URI: "a", Generation: 1},
+		"b": {URI: "b", Generation: 1},
+	}
+	events, err := PollWatch(ctx, &WatchOptions{PollInterval: 10 * time.Millisecond, Debounce: -1}, func(ctx context.Context) (map[string]*FileInfo, error) {
+		select {
+		case s := <-snapshots:
+			return s, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	})
+	if err != nil {
+		t.Fatalf("PollWatch failed; error: %v", err)
+	}
+
+	c := &FileInfo{URI: "c", Generation: 1}
+	a := &FileInfo{URI: "a", Generation: 2}
+	snapshots <- map[string]*FileInfo{"a": a, "c": c}
+	expected := []WatchEvent{
+		{URI: "a", Op: WatchModify, Info: a},
+		{URI: "b", Op: WatchDelete},
+		{URI: "c", Op: WatchCreate, Info: c},
+	}
+	actual := []WatchEvent{}
+	for len(actual) < len(expected) {
+		actual = append(actual, <-events)
+	}
+	if d := cmp.Diff(expected, actual); d != "" {
+		t.Errorf("Unexpected events; diff:\n%v", d)
+	}
+
+	cancel()
+	if _, ok := <-events; ok {
+		t.Errorf("The channel should be closed when the context is cancelled")
+	}
+}
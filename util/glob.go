@@ -0,0 +1,171 @@
+package util
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Glob is a compiled glob pattern. It is the pattern language used by the Glob methods of all the
+// files.DirectoryHelper implementations (local files, GCS, etc...) so a pattern matches the same files regardless
+// of where they are stored. The syntax is the same as GCS's matchGlob:
+//
+//   - * matches any sequence of characters except /
+//   - ** matches any sequence of characters including /. If it is a complete path segment, i.e. a/**/b, it also
+//     matches zero segments so a/**/b matches a/b
+//   - ? matches any single character except /
+//   - [abc] and [a-z] match a character in the class; [!abc] matches a character not in the class other than /
+//   - {a,b} matches any of the comma separated alternatives; alternatives can't be nested
+//   - \ escapes the following character
+//
+// The pattern must match the entire name. Unlike filepath.Glob there's no special handling of files beginning
+// with a dot.
+type Glob struct {
+	pattern string
+	re      *regexp.Regexp
+	prefix  string
+	literal bool
+	// nested is true if a name can contain a / after the prefix.
+	nested bool
+}
+
+// CompileGlob parses a glob pattern.
+func CompileGlob(pattern string) (*Glob, error) {
+	g := &Glob{
+		pattern: pattern,
+		literal: true,
+	}
+
+	b := strings.Builder{}
+	b.WriteString("^")
+	inAlt := false
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		if isGlobMeta(c) && g.literal {
+			g.literal = false
+		}
+		switch c {
+		case '*':
+			if i+1 < len(pattern) && pattern[i+1] == '*' {
+				i++
+				g.nested = true
+				// A complete ** segment also matches zero segments.
+				if (i == 1 || pattern[i-2] == '/') && i+1 < len(pattern) && pattern[i+1] == '/' {
+					b.WriteString("(?:.*/)?")
+					i++
+					continue
+				}
+				b.WriteString(".*")
+				continue
+			}
+			b.WriteString("[^/]*")
+		case '?':
+			b.WriteString("[^/]")
+		case '[':
+			j := strings.IndexByte(pattern[i+1:], ']')
+			if j < 1 {
+				return nil, errors.Errorf("Invalid glob %v; unterminated or empty character class", pattern)
+			}
+			class := pattern[i+1 : i+1+j]
+			i += j + 1
+			if !strings.HasPrefix(class, "!") && strings.Contains(class, "/") {
+				g.nested = true
+			}
+			b.WriteString("[")
+			if strings.HasPrefix(class, "!") {
+				b.WriteString("^/")
+				class = class[1:]
+			}
+			for k := 0; k < len(class); k++ {
+				if class[k] == '-' {
+					b.WriteString("-")
+					continue
+				}
+				if class[k] == '\\' && k+1 < len(class) {
+					k++
+				}
+				b.WriteString(regexp.QuoteMeta(class[k : k+1]))
+			}
+			b.WriteString("]")
+		case '{':
+			if inAlt {
+				return nil, errors.Errorf("Invalid glob %v; nested alternatives aren't supported", pattern)
+			}
+			inAlt = true
+			b.WriteString("(?:")
+		case '}':
+			if !inAlt {
+				return nil, errors.Errorf("Invalid glob %v; unbalanced }", pattern)
+			}
+			inAlt = false
+			b.WriteString(")")
+		case ',':
+			if inAlt {
+				b.WriteString("|")
+				continue
+			}
+			b.WriteString(",")
+		case '\\':
+			if i+1 >= len(pattern) {
+				return nil, errors.Errorf("Invalid glob %v; it ends with an escape character", pattern)
+			}
+			i++
+			if g.literal {
+				g.prefix += pattern[i : i+1]
+			} else if pattern[i] == '/' {
+				g.nested = true
+			}
+			b.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		default:
+			if g.literal {
+				g.prefix += pattern[i : i+1]
+			} else if c == '/' {
+				g.nested = true
+			}
+			b.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		}
+	}
+	if inAlt {
+		return nil, errors.Errorf("Invalid glob %v; unterminated alternative", pattern)
+	}
+	b.WriteString("$")
+
+	re, err := regexp.Compile(b.String())
+	if err != nil {
+		return nil, errors.Wrapf(err, "Invalid glob %v", pattern)
+	}
+	g.re = re
+	return g, nil
+}
+
+func isGlobMeta(c byte) bool {
+	return c == '*' || c == '?' || c == '[' || c == '{'
+}
+
+// Match reports whether name matches the pattern.
+func (g *Glob) Match(name string) bool {
+	return g.re.MatchString(name)
+}
+
+// Prefix returns the literal prefix of the pattern, i.e. the part before the first wildcard, with any escapes
+// removed. Every matching name begins with the prefix so it can be used to narrow a listing.
+func (g *Glob) Prefix() string {
+	return g.prefix
+}
+
+// IsLiteral reports whether the pattern has no wildcards; in which case it only matches Prefix().
+func (g *Glob) IsLiteral() bool {
+	return g.literal
+}
+
+// IsNested reports whether a matching name can contain a / after Prefix(). If it can't, all the matches are
+// in the "directory" given by the prefix so a listing can use / as a delimiter rather than being recursive.
+func (g *Glob) IsNested() bool {
+	return g.nested
+}
+
+// String returns the pattern.
+func (g *Glob) String() string {
+	return g.pattern
+}
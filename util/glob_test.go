@@ -0,0 +1,96 @@
+package util
+
+import (
+	"testing"
+)
+
+func Test_Glob(t *testing.T) {
+	type testCase struct {
+		pattern string
+		name    string
+		match   bool
+	}
+
+	cases := []testCase{
+		{pattern: "dir/*.txt", name: "dir/a.txt", match: true},
+		{pattern: "dir/*.txt", name: "dir/sub/a.txt", match: false},
+		{pattern: "dir/*.txt", name: "dir/a.txt.bak", match: false},
+		{pattern: "dir/**", name: "dir/sub/a.txt", match: true},
+		{pattern: "dir/**.txt", name: "dir/sub/a.txt", match: true},
+		{pattern: "dir/**/*.txt", name: "dir/a.txt", match: true},
+		{pattern: "dir/**/*.txt", name: "dir/sub/deeper/a.txt", match: true},
+		{pattern: "**/*.txt", name: "a.txt", match: true},
+		{pattern: "**/*.txt", name: "dir/a.csv", match: false},
+		{pattern: "file-?.txt", name: "file-1.txt", match: true},
+		{pattern: "file-?.txt", name: "file-22.txt", match: false},
+		{pattern: "file-?.txt", name: "file-/.txt", match: false},
+		{pattern: "file-[0-9].txt", name: "file-7.txt", match: true},
+		{pattern: "file-[!0-9].txt", name: "file-7.txt", match: false},
+		{pattern: "file-[!0-9].txt", name: "file-a.txt", match: true},
+		{pattern: "*.{txt,csv}", name: "a.csv", match: true},
+		{pattern: "*.{txt,csv}", name: "a.json", match: false},
+		{pattern: `a\*.txt`, name: "a*.txt", match: true},
+		{pattern: `a\*.txt`, name: "ab.txt", match: false},
+		{pattern: "a.txt", name: "abtxt", match: false},
+		{pattern: "a,b", name: "a,b", match: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.pattern+"-"+c.name, func(t *testing.T) {
+			g, err := CompileGlob(c.pattern)
+			if err != nil {
+				t.Fatalf("CompileGlob(%v) failed; error: %v", c.pattern, err)
+			}
+			if actual := g.Match(c.name); actual != c.match {
+				t.Errorf("Match(%v) = %v; want %v", c.name, actual, c.match)
+			}
+		})
+	}
+}
+
+func Test_GlobPrefix(t *testing.T) {
+	type testCase struct {
+		pattern string
+		prefix  string
+		literal bool
+		nested  bool
+	}
+
+	cases := []testCase{
+		{pattern: "dir/sub/*.txt", prefix: "dir/sub/", literal: false},
+		{pattern: "dir/file-?.txt", prefix: "dir/file-", literal: false},
+		{pattern: `dir/a\[1\]/{a,b}`, prefix: "dir/a[1]/", literal: false},
+		{pattern: "dir/a.txt", prefix: "dir/a.txt", literal: true},
+		{pattern: "**", prefix: "", literal: false, nested: true},
+		{pattern: "dir/*/a.txt", prefix: "dir/", literal: false, nested: true},
+		{pattern: "dir/{a,b/c}", prefix: "dir/", literal: false, nested: true},
+		{pattern: "dir/[/]a", prefix: "dir/", literal: false, nested: true},
+		{pattern: "*.txt", prefix: "", literal: false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.pattern, func(t *testing.T) {
+			g, err := CompileGlob(c.pattern)
+			if err != nil {
+				t.Fatalf("CompileGlob(%v) failed; error: %v", c.pattern, err)
+			}
+			if g.Prefix() != c.prefix {
+				t.Errorf("Prefix() = %v; want %v", g.Prefix(), c.prefix)
+			}
+			if g.IsLiteral() != c.literal {
+				t.Errorf("IsLiteral() = %v; want %v", g.IsLiteral(), c.literal)
+			}
+			if g.IsNested() != c.nested {
+				t.Errorf("IsNested() = %v; want %v", g.IsNested(), c.nested)
+			}
+		})
+	}
+}
+
+func Test_GlobInvalid(t *testing.T) {
+	for _, p := range []string{"file-[0-9.txt", "{a,b", "a}", "{a,{b,c}}", `a\`, "[]"} {
+		if _, err := CompileGlob(p); err == nil {
+			t.Errorf("CompileGlob(%v) should have failed", p)
+		}
+	}
+}
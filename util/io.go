@@ -9,6 +9,9 @@ import (
 
 // MaybeClose will close the writer if its a Closer.
 // Intended to be used with calls to defer.
+//
+// Deprecated: files.FileHelper now returns an io.WriteCloser; callers should call Close directly and check the
+// error since some writers (e.g. GCS) only report write errors on Close.
 func MaybeClose(writer io.Writer) {
 	log := zapr.NewLogger(zap.L())
 	if closer, isCloser := writer.(io.Closer); isCloser {